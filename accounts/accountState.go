@@ -1,13 +1,23 @@
 package accounts
 
 import (
-	"log"
+	"crypto/ed25519"
 	"simple-blockchain-go/geneis"
+	"simple-blockchain-go/logger"
 )
 
+var stateLog = logger.New(logger.STATE)
+
+// public keys, multisig and contract addresses are all 32 bytes
+func IsAccountKey(key []byte) bool {
+	return len(key) == ed25519.PublicKeySize
+}
+
 type AccountState struct {
 	Nonce   uint64
 	Balance uint64
+	// staked, delegated or unbonding, not spendable
+	Locked uint64
 }
 
 func (as *AccountState) Subtract(amount uint64) bool {
@@ -29,11 +39,43 @@ func (as *AccountState) Add(amount uint64) bool {
 	return true
 }
 
+// moves amount from balance to locked
+func (as *AccountState) Lock(amount uint64) bool {
+	if amount > as.Balance {
+		return false
+	}
+
+	as.Balance -= amount
+	as.Locked += amount
+	return true
+}
+
+// moves amount from locked to balance
+func (as *AccountState) Unlock(amount uint64) bool {
+	if amount > as.Locked {
+		return false
+	}
+
+	as.Locked -= amount
+	as.Balance += amount
+	return true
+}
+
+// locked amount is gone, for slashing
+func (as *AccountState) Burn(amount uint64) bool {
+	if amount > as.Locked {
+		return false
+	}
+
+	as.Locked -= amount
+	return true
+}
+
 func (as *AccountState) CheckNonce(nonce uint64) bool {
-	log.Printf("checking nonce, received: %d expected: %d", nonce, as.Nonce)
-	ok := as.Nonce == nonce
-	if ok {
-		as.Nonce++
+	if as.Nonce != nonce {
+		stateLog.Debug("unexpected nonce", logger.F("nonce", nonce), logger.F("expected", as.Nonce))
+		return false
 	}
-	return ok
+	as.Nonce++
+	return true
 }
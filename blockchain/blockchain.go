@@ -2,26 +2,44 @@ package blockchain
 
 import (
 	"bytes"
-	"log"
+	"errors"
+	"fmt"
 	"simple-blockchain-go/accounts"
 	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/consensus"
 	"simple-blockchain-go/database"
-	"simple-blockchain-go/pow"
+	"simple-blockchain-go/geneis"
+	"simple-blockchain-go/logger"
 	"sync"
-)
+	"time"
 
-const (
-	DEFAULT_DIFFICULTY byte = 20
+	"golang.org/x/exp/slices"
 )
 
+var stateLog = logger.New(logger.STATE)
+
+var ErrHeightConflict = errors.New("block is not next to head")
+
 type Blockchain struct {
 	sync.Mutex
 	blocks.BlockInfo
 	database.Database
+	Config *geneis.Config
+	Engine consensus.Engine
 }
 
 func NewBlockchain(id string) (*Blockchain, error) {
 	bc := Blockchain{}
+	config, err := geneis.LoadConfig()
+	if err != nil {
+		return &bc, err
+	}
+	bc.Config = config
+	bc.Engine, err = newEngine(id, config)
+	if err != nil {
+		return &bc, err
+	}
+
 	db, err := database.Open(id)
 	if err != nil {
 		return &bc, err
@@ -40,14 +58,31 @@ func NewBlockchain(id string) (*Blockchain, error) {
 	bc.Height = height
 	bc.PreviousBlockHash = latestHash
 	bc.Database = db
-	bc.Difficulty = DEFAULT_DIFFICULTY
-	log.Printf(
-		"blockchain starts at\n height: %d\n difficulty: %d\n letest: %x",
-		bc.Height, bc.Difficulty, bc.PreviousBlockHash,
+	bc.Difficulty, err = bc.CalcNextDifficulty()
+	if err != nil {
+		return &bc, err
+	}
+	stateLog.Info(
+		"blockchain starts",
+		logger.F("consensus", bc.Engine.Name()),
+		logger.Height(bc.Height),
+		logger.F("difficulty", bc.Difficulty),
+		logger.Hash(bc.PreviousBlockHash),
 	)
 	return &bc, nil
 }
 
+// chain which reads only stored data, pending block is invisible,
+// it must not be written
+func (bc *Blockchain) Committed() *Blockchain {
+	return &Blockchain{
+		BlockInfo: bc.BlockInfo,
+		Database:  bc.Database.Committed(),
+		Config:    bc.Config,
+		Engine:    bc.Engine,
+	}
+}
+
 func (bc *Blockchain) GetAccountStateSafe(pubKey []byte) (*accounts.AccountState, error) {
 	state, err := bc.GetAccountState(pubKey)
 	if err != nil {
@@ -68,37 +103,165 @@ func (bc *Blockchain) GetAccountStateSafe(pubKey []byte) (*accounts.AccountState
 	return state, nil
 }
 
+func (bc *Blockchain) AddBalance(pubKey []byte, amount uint64) error {
+	state, err := bc.GetAccountStateSafe(pubKey)
+	if err != nil {
+		return err
+	}
+	if !state.Add(amount) {
+		return errors.New("overflow")
+	}
+	return bc.PutAccountState(pubKey, state)
+}
+
+// headers of latest n blocks ordered by height, genesis is excluded
+func (bc *Blockchain) GetRecentHeaders(n int) ([]blocks.BlockHeader, error) {
+	headers := []blocks.BlockHeader{}
+	if bc.Height == 0 || n <= 0 {
+		return headers, nil
+	}
+
+	from := uint64(1)
+	if bc.Height > uint64(n) {
+		from = bc.Height - uint64(n) + 1
+	}
+	for h := from; h <= bc.Height; h++ {
+		block, err := bc.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, block.BlockHeader)
+	}
+	return headers, nil
+}
+
+// difficulty which next block has to have
+func (bc *Blockchain) CalcNextDifficulty() (byte, error) {
+	return bc.Engine.CalcDifficulty(bc)
+}
+
+// median of timestamps of recent blocks,
+// zero when there is no block except genesis
+func (bc *Blockchain) CalcMedianTimePast() (int64, error) {
+	window, err := bc.GetRecentHeaders(bc.Config.MedianTimeWindow)
+	if err != nil {
+		return 0, err
+	}
+	if len(window) == 0 {
+		return 0, nil
+	}
+
+	timestamps := make([]int64, 0, len(window))
+	for _, h := range window {
+		timestamps = append(timestamps, h.Timestamp)
+	}
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2], nil
+}
+
+// earliest timestamp next block can have
+func (bc *Blockchain) MinNextTimestamp() (int64, error) {
+	mtp, err := bc.CalcMedianTimePast()
+	if err != nil {
+		return 0, err
+	}
+	return mtp + 1, nil
+}
+
+func (bc *Blockchain) verifyTimestamp(block *blocks.Block) (bool, error) {
+	min, err := bc.MinNextTimestamp()
+	if err != nil {
+		return false, err
+	}
+	if block.Timestamp < min {
+		stateLog.Warn(
+			"block timestamp is not after median time past",
+			logger.Height(block.Height),
+			logger.F("timestamp", block.Timestamp),
+			logger.F("medianTimePast", min-1),
+		)
+		return false, nil
+	}
+
+	max := time.Now().Unix() + bc.Config.MaxFutureDrift
+	if block.Timestamp > max {
+		stateLog.Warn(
+			"block timestamp is too far in the future",
+			logger.Height(block.Height),
+			logger.F("timestamp", block.Timestamp),
+			logger.F("max", max),
+		)
+		return false, nil
+	}
+	return true, nil
+}
+
 func (bc *Blockchain) VerifyBlock(block *blocks.Block) (bool, error) {
 	receivedHeight := block.Height
 	expectedHeight := bc.Height + 1
 
 	if receivedHeight != expectedHeight {
-		log.Printf(
-			"received block height is %d, expected %d\n",
-			receivedHeight, expectedHeight,
+		stateLog.Debug(
+			"block height is unexpected",
+			logger.Height(receivedHeight),
+			logger.F("expected", expectedHeight),
 		)
 		return false, nil
 	}
 
 	if !bytes.Equal(bc.PreviousBlockHash, block.PreviousBlockHash) {
-		log.Printf(
-			"received previous hash:\n %x\nexpected:\n %x\n",
-			block.PreviousBlockHash, bc.PreviousBlockHash,
+		stateLog.Warn(
+			"block previous hash does not match",
+			logger.Height(receivedHeight),
+			logger.HashOf("previous", block.PreviousBlockHash),
+			logger.HashOf("expected", bc.PreviousBlockHash),
 		)
 		return false, nil
 	}
 
-	validator := pow.NewProofOfWork(block)
-	ok, err := validator.Validate()
+	ok, err := bc.verifyTimestamp(block)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+
+	ok, err = block.VerifyIntegrity()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		stateLog.Warn("block hash or tx root does not match contents", logger.Height(receivedHeight))
+		return false, nil
+	}
+
+	ok, err = bc.verifyResources(block)
 	if err != nil {
 		return false, err
 	}
 	if !ok {
-		log.Println("pow block validation failed...")
 		return false, nil
 	}
 
-	log.Printf("verified block at height: %d\n", expectedHeight)
+	ok, err = bc.verifyCheckpoint(block)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+
+	ok, err = bc.Engine.VerifyHeader(bc, &block.BlockHeader, block.Hash)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		stateLog.Warn("header validation failed", logger.Height(receivedHeight), logger.F("consensus", bc.Engine.Name()))
+		return false, nil
+	}
+
+	stateLog.Debug("verified block", logger.Height(expectedHeight), logger.Hash(block.Hash))
 	return true, nil
 }
 
@@ -109,27 +272,59 @@ func (bc *Blockchain) PutBlockWithCheck(block *blocks.Block) error {
 	}
 	expectedHeight := currentHeight + 1
 	if block.Height != expectedHeight {
-		log.Printf(
-			"height conflict\n current: %d\n expected: %d\n received: %d\n",
-			currentHeight, expectedHeight, block.Height,
+		stateLog.Warn(
+			"height conflict",
+			logger.Height(block.Height),
+			logger.F("current", currentHeight),
+			logger.F("expected", expectedHeight),
 		)
-		return nil
+		// state of block must not be committed without it
+		return fmt.Errorf("%w: height %d", ErrHeightConflict, block.Height)
+	}
+
+	err = bc.PutBlock(block)
+	if err != nil {
+		return err
 	}
 
 	bc.Height = block.Height
 	bc.PreviousBlockHash = block.Hash
-
-	return bc.PutBlock(block)
+	bc.Difficulty, err = bc.CalcNextDifficulty()
+	return err
 }
 
-// need better way to open database
-// this func should be removed
-func (bc *Blockchain) OverwriteGenesis(block blocks.Block) error {
+// replaces local genesis with the one of network,
+// false when local chain has already grown on own genesis
+// or block does not match checkpoint
+func (bc *Blockchain) OverwriteGenesis(block blocks.Block) (bool, error) {
 	if block.Height != 0 {
-		block.Height = 0
+		return false, nil
+	}
+	if bc.Height > 0 {
+		stateLog.Info("local chain has already grown on own genesis", logger.Height(bc.Height))
+		return false, nil
+	}
+
+	ok, err := block.VerifyIntegrity()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		stateLog.Warn("received genesis is broken", logger.Hash(block.Hash))
+		return false, nil
+	}
+	ok, err = bc.verifyCheckpoint(&block)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	err = bc.PutBlock(&block)
+	if err != nil {
+		return false, err
 	}
 
 	bc.Height = block.Height
 	bc.PreviousBlockHash = block.Hash
-	return bc.PutBlock(&block)
+	bc.Difficulty, err = bc.CalcNextDifficulty()
+	return true, err
 }
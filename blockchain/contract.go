@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"simple-blockchain-go/common"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	CONTRACT_PREFIX = "contract:"
+)
+
+type Contract struct {
+	Address []byte
+	Creator []byte
+	Code    []byte
+}
+
+// creator's nonce makes address unique
+func ContractAddress(creator []byte, nonce uint64) ([]byte, error) {
+	n, err := common.ToHex(nonce)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha3.Sum256(
+		append(append([]byte(CONTRACT_PREFIX), creator...), n...),
+	)
+	return hash[:], nil
+}
+
+func contractKey(address []byte) []byte {
+	return append([]byte(CONTRACT_PREFIX), address...)
+}
+
+// nil when contract does not exist
+func (bc *Blockchain) GetContract(address []byte) (*Contract, error) {
+	raw, err := bc.GetStateValue(contractKey(address))
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	return common.Decode[Contract](raw)
+}
+
+func (bc *Blockchain) PutContract(contract *Contract) error {
+	enc, err := common.Encode(contract)
+	if err != nil {
+		return err
+	}
+	return bc.PutStateValue(contractKey(contract.Address), enc)
+}
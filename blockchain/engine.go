@@ -0,0 +1,38 @@
+package blockchain
+
+import (
+	"fmt"
+	"simple-blockchain-go/consensus"
+	"simple-blockchain-go/geneis"
+	"simple-blockchain-go/poa"
+	"simple-blockchain-go/pos"
+	"simple-blockchain-go/pow"
+	"simple-blockchain-go/wallets"
+)
+
+func newEngine(id string, config *geneis.Config) (consensus.Engine, error) {
+	switch config.Consensus {
+	case consensus.POW_ENGINE:
+		return pow.NewEngine(0), nil
+	case consensus.POA_ENGINE:
+		// every executer has key, it signs only when listed in genesis config
+		signer, err := wallets.NewWallet(id, poa.SIGNER_KEY)
+		if err != nil {
+			return nil, err
+		}
+		return poa.NewEngine(config.Signers, signer)
+	case consensus.POS_ENGINE:
+		validator, err := wallets.NewWallet(id, pos.VALIDATOR_WALLET)
+		if err != nil {
+			return nil, err
+		}
+		return pos.NewEngine(
+			config.Validators,
+			config.UnbondingPeriod,
+			config.MinStake,
+			validator,
+		)
+	default:
+		return nil, fmt.Errorf("unknown consensus engine: %s", config.Consensus)
+	}
+}
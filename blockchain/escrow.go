@@ -0,0 +1,57 @@
+package blockchain
+
+import (
+	"simple-blockchain-go/common"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	ESCROW_PREFIX = "escrow:"
+)
+
+// funds of locked transfer, not belonging to any account
+type Escrow struct {
+	Id           []byte
+	From         []byte
+	To           []byte
+	Amount       uint64
+	UnlockHeight uint64
+	RefundKey    []byte
+	RefundHeight uint64
+}
+
+// sender's nonce makes id unique
+func EscrowId(from []byte, nonce uint64) ([]byte, error) {
+	n, err := common.ToHex(nonce)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha3.Sum256(append(append([]byte{}, from...), n...))
+	return hash[:], nil
+}
+
+func escrowKey(id []byte) []byte {
+	return append([]byte(ESCROW_PREFIX), id...)
+}
+
+// nil when escrow does not exist
+func (bc *Blockchain) GetEscrow(id []byte) (*Escrow, error) {
+	raw, err := bc.GetStateValue(escrowKey(id))
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	return common.Decode[Escrow](raw)
+}
+
+func (bc *Blockchain) PutEscrow(escrow *Escrow) error {
+	enc, err := common.Encode(escrow)
+	if err != nil {
+		return err
+	}
+	return bc.PutStateValue(escrowKey(escrow.Id), enc)
+}
+
+func (bc *Blockchain) DeleteEscrow(id []byte) error {
+	return bc.DeleteStateValue(escrowKey(id))
+}
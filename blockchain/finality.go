@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/logger"
+)
+
+// hex block hashes by height which every node must have.
+// filled when network is launched, genesis config can add more
+var checkpoints = map[uint64]string{}
+
+// nil when height has no checkpoint
+func (bc *Blockchain) CheckpointHash(height uint64) ([]byte, error) {
+	s, ok := bc.Config.Checkpoints[height]
+	if !ok {
+		s, ok = checkpoints[height]
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	hash, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint at height %d: %s", height, err)
+	}
+	return hash, nil
+}
+
+func (bc *Blockchain) verifyCheckpoint(block *blocks.Block) (bool, error) {
+	hash, err := bc.CheckpointHash(block.Height)
+	if err != nil {
+		return false, err
+	}
+	if hash != nil && !bytes.Equal(hash, block.Hash) {
+		stateLog.Warn(
+			"block does not match checkpoint",
+			logger.Height(block.Height),
+			logger.Hash(block.Hash),
+			logger.HashOf("checkpoint", hash),
+		)
+		return false, nil
+	}
+	return true, nil
+}
+
+// highest checkpoint which local chain has reached
+func (bc *Blockchain) latestCheckpoint() (uint64, bool) {
+	var latest uint64
+	found := false
+	for _, cps := range []map[uint64]string{checkpoints, bc.Config.Checkpoints} {
+		for h := range cps {
+			if h <= bc.Height && (!found || h > latest) {
+				latest = h
+				found = true
+			}
+		}
+	}
+	return latest, found
+}
+
+// blocks at or under this height are never rewritten,
+// chain only appends next block and replaces genesis before
+// anything is built on it, so no deeper reorg can happen
+func (bc *Blockchain) FinalizedHeight() uint64 {
+	finalized, _ := bc.latestCheckpoint()
+	if bc.Height >= bc.Config.MaxReorgDepth &&
+		bc.Height-bc.Config.MaxReorgDepth > finalized {
+		finalized = bc.Height - bc.Config.MaxReorgDepth
+	}
+	return finalized
+}
+
+// genesis is final once any block is built on it
+func (bc *Blockchain) IsFinal(height uint64) bool {
+	if height > bc.Height {
+		return false
+	}
+	if bc.Height-height >= bc.Config.MaxReorgDepth {
+		return true
+	}
+	if cp, ok := bc.latestCheckpoint(); ok && height <= cp {
+		return true
+	}
+	return height == 0 && bc.Height > 0
+}
@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/geneis"
+	"testing"
+)
+
+func chainAt(height uint64, checkpoints map[uint64]string) *Blockchain {
+	config := geneis.DefaultConfig()
+	config.MaxReorgDepth = 6
+	config.Checkpoints = checkpoints
+	return &Blockchain{
+		BlockInfo: blocks.BlockInfo{Height: height},
+		Config:    config,
+	}
+}
+
+func TestFinalizedHeight(t *testing.T) {
+	cases := []struct {
+		name        string
+		height      uint64
+		checkpoints map[uint64]string
+		want        uint64
+	}{
+		{"shorter than depth", 3, nil, 0},
+		{"deeper than depth", 20, nil, 14},
+		{"checkpoint above depth", 20, map[uint64]string{17: "00"}, 17},
+		{"checkpoint not reached", 20, map[uint64]string{30: "00"}, 14},
+	}
+	for _, c := range cases {
+		got := chainAt(c.height, c.checkpoints).FinalizedHeight()
+		if got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	bc := chainAt(20, map[uint64]string{16: "00"})
+	cases := []struct {
+		height uint64
+		want   bool
+	}{
+		{14, true},
+		// under checkpoint
+		{16, true},
+		{17, false},
+		{20, false},
+		{21, false},
+	}
+	for _, c := range cases {
+		if got := bc.IsFinal(c.height); got != c.want {
+			t.Errorf("height %d: got %v, want %v", c.height, got, c.want)
+		}
+	}
+}
+
+// genesis can be replaced only until something is built on it
+func TestGenesisIsFinalOnceBuiltOn(t *testing.T) {
+	if chainAt(0, nil).IsFinal(0) {
+		t.Error("lone genesis is final")
+	}
+	if !chainAt(1, nil).IsFinal(0) {
+		t.Error("genesis under a block is not final")
+	}
+}
+
+func TestCheckpointHash(t *testing.T) {
+	bc := chainAt(0, map[uint64]string{5: "0a0b", 6: "zz"})
+	hash, err := bc.CheckpointHash(5)
+	if err != nil || string(hash) != "\x0a\x0b" {
+		t.Errorf("got %x, %v", hash, err)
+	}
+	hash, err = bc.CheckpointHash(4)
+	if err != nil || hash != nil {
+		t.Errorf("height without checkpoint: got %x, %v", hash, err)
+	}
+	_, err = bc.CheckpointHash(6)
+	if err == nil {
+		t.Error("broken checkpoint is accepted")
+	}
+}
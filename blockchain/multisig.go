@@ -0,0 +1,33 @@
+package blockchain
+
+import (
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/transactions"
+)
+
+const (
+	MULTISIG_PREFIX = "multisig:"
+)
+
+func multisigKey(address []byte) []byte {
+	return append([]byte(MULTISIG_PREFIX), address...)
+}
+
+// nil when address is not a multisig account
+func (bc *Blockchain) GetMultisig(
+	address []byte,
+) (*transactions.MultisigSpec, error) {
+	raw, err := bc.GetStateValue(multisigKey(address))
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	return common.Decode[transactions.MultisigSpec](raw)
+}
+
+func (bc *Blockchain) PutMultisig(spec *transactions.MultisigSpec) error {
+	enc, err := common.Encode(spec)
+	if err != nil {
+		return err
+	}
+	return bc.PutStateValue(multisigKey(spec.Address()), enc)
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+)
+
+// limits of weight, size and fee in genesis config
+func (bc *Blockchain) verifyResources(block *blocks.Block) (bool, error) {
+	var weight uint64
+	for _, tx := range block.Bundle.Transactions {
+		// broken transaction makes block invalid, it is not failure of this node
+		ok, err := tx.PaysMinFee(bc.Config.MinFeePerWeight)
+		if err != nil {
+			stateLog.Warn("block includes malformed transaction", logger.Height(block.Height), logger.HashOf("tx", tx.Hash[:]), logger.Err(err))
+			return false, nil
+		}
+		if !ok {
+			stateLog.Warn("block includes transaction under min fee", logger.Height(block.Height), logger.HashOf("tx", tx.Hash[:]))
+			return false, nil
+		}
+
+		w, err := tx.Weight()
+		if err != nil {
+			stateLog.Warn("block includes malformed transaction", logger.Height(block.Height), logger.HashOf("tx", tx.Hash[:]), logger.Err(err))
+			return false, nil
+		}
+		if w > bc.Config.MaxBlockWeight-weight {
+			stateLog.Warn("block weight exceeds limit", logger.Height(block.Height), logger.F("limit", bc.Config.MaxBlockWeight))
+			return false, nil
+		}
+		weight += w
+	}
+
+	enc, err := common.Encode(block)
+	if err != nil {
+		return false, err
+	}
+	if len(enc) > bc.Config.MaxBlockSize {
+		stateLog.Warn(
+			"block size exceeds limit",
+			logger.Height(block.Height),
+			logger.F("size", len(enc)),
+			logger.F("limit", bc.Config.MaxBlockSize),
+		)
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,79 @@
+package blockchain
+
+import (
+	"simple-blockchain-go/common"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	TOKEN_PREFIX         = "token:"
+	TOKEN_BALANCE_PREFIX = "token-balance:"
+)
+
+type Token struct {
+	Id       []byte
+	Symbol   string
+	Decimals byte
+	// Supply never exceeds SupplyCap
+	SupplyCap     uint64
+	Supply        uint64
+	MintAuthority []byte
+}
+
+// creator's nonce makes id unique
+func TokenId(creator []byte, nonce uint64) ([]byte, error) {
+	n, err := common.ToHex(nonce)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha3.Sum256(
+		append(append([]byte(TOKEN_PREFIX), creator...), n...),
+	)
+	return hash[:], nil
+}
+
+func tokenKey(id []byte) []byte {
+	return append([]byte(TOKEN_PREFIX), id...)
+}
+
+func tokenBalanceKey(id []byte, pubKey []byte) []byte {
+	key := append([]byte(TOKEN_BALANCE_PREFIX), id...)
+	return append(key, pubKey...)
+}
+
+// nil when token does not exist
+func (bc *Blockchain) GetToken(id []byte) (*Token, error) {
+	raw, err := bc.GetStateValue(tokenKey(id))
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	return common.Decode[Token](raw)
+}
+
+func (bc *Blockchain) PutToken(token *Token) error {
+	enc, err := common.Encode(token)
+	if err != nil {
+		return err
+	}
+	return bc.PutStateValue(tokenKey(token.Id), enc)
+}
+
+// 0 when account has never held the token
+func (bc *Blockchain) GetTokenBalance(id []byte, pubKey []byte) (uint64, error) {
+	raw, err := bc.GetStateValue(tokenBalanceKey(id, pubKey))
+	if err != nil || raw == nil {
+		return 0, err
+	}
+	return common.FromHex[uint64](raw)
+}
+
+func (bc *Blockchain) PutTokenBalance(
+	id []byte, pubKey []byte, balance uint64,
+) error {
+	enc, err := common.ToHex(balance)
+	if err != nil {
+		return err
+	}
+	return bc.PutStateValue(tokenBalanceKey(id, pubKey), enc)
+}
@@ -1,8 +1,18 @@
 package blocks
 
 import (
+	"bytes"
+	"simple-blockchain-go/common"
 	"simple-blockchain-go/transactions"
 	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	BLOCK_VERSION byte = 2
+	// bytes of encoded block which are not transactions
+	HEADER_SIZE_RESERVE = 1024
 )
 
 type BlockInfo struct {
@@ -11,25 +21,167 @@ type BlockInfo struct {
 	PreviousBlockHash []byte
 }
 
+// header commits to everything except the body,
+// body is committed through TxRoot
+type BlockHeader struct {
+	Version           byte
+	Height            uint64
+	PreviousBlockHash []byte
+	TxRoot            []byte
+	StateRoot         []byte
+	ReceiptsRoot      []byte
+	Timestamp         int64
+	Difficulty        byte
+	// producer of the block, empty when sealed by miners
+	Coinbase []byte
+	Nonce    uint64
+	// seal by Coinbase over SealHash, empty when sealed by miners
+	Signature []byte
+}
+
 type Block struct {
-	BlockInfo
-	Timestamp int64
-	Bundle    transactions.TxBundle
-	Hash      []byte
-	Nonce     uint64
-	StateHash []byte
+	BlockHeader
+	Bundle transactions.TxBundle
+	Hash   []byte
 }
 
 func NewBlock(
 	transactions transactions.TxBundle,
 	info BlockInfo,
-) *Block {
+) (*Block, error) {
+	txRoot, err := transactions.HashTransactions()
+	if err != nil {
+		return nil, err
+	}
+
 	block := Block{
-		BlockInfo: info,
-		Timestamp: time.Now().Unix(),
-		Bundle:    transactions,
-		Hash:      nil,
-		Nonce:     0,
+		BlockHeader: BlockHeader{
+			Version:           BLOCK_VERSION,
+			Height:            info.Height,
+			PreviousBlockHash: info.PreviousBlockHash,
+			TxRoot:            txRoot,
+			StateRoot:         nil,
+			ReceiptsRoot:      nil,
+			Timestamp:         time.Now().Unix(),
+			Difficulty:        info.Difficulty,
+			Nonce:             0,
+		},
+		Bundle: transactions,
+		Hash:   nil,
+	}
+	return &block, nil
+}
+
+// byte slices are length prefixed so that
+// boundaries between fields can not be shifted
+func appendBytes(buff *bytes.Buffer, bs []byte) error {
+	l, err := common.ToHex(uint32(len(bs)))
+	if err != nil {
+		return err
+	}
+	buff.Write(l)
+	buff.Write(bs)
+	return nil
+}
+
+// serialized header except nonce,
+// nonce is always appended at the end as 8 bytes big endian
+func (h *BlockHeader) SerializePrefix() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	buff.WriteByte(h.Version)
+	height, err := common.ToHex(h.Height)
+	if err != nil {
+		return nil, err
+	}
+	buff.Write(height)
+	for _, bs := range [][]byte{
+		h.PreviousBlockHash,
+		h.TxRoot,
+		h.StateRoot,
+		h.ReceiptsRoot,
+	} {
+		err = appendBytes(buff, bs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	timestamp, err := common.ToHex(h.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	buff.Write(timestamp)
+	buff.WriteByte(h.Difficulty)
+	err = appendBytes(buff, h.Coinbase)
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// serialized header except signature
+func (h *BlockHeader) serializeForSeal() ([]byte, error) {
+	prefix, err := h.SerializePrefix()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := common.ToHex(h.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, nonce...), nil
+}
+
+// signature is appended only when header has it,
+// so hash of header sealed by miners is prefix and nonce
+func (h *BlockHeader) Serialize() ([]byte, error) {
+	ser, err := h.serializeForSeal()
+	if err != nil {
+		return nil, err
+	}
+	if len(h.Signature) == 0 {
+		return ser, nil
+	}
+	buff := bytes.NewBuffer(ser)
+	err = appendBytes(buff, h.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (h *BlockHeader) CalcHash() ([]byte, error) {
+	ser, err := h.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha3.Sum256(ser)
+	return hash[:], nil
+}
+
+// hash which producer signs
+func (h *BlockHeader) SealHash() ([]byte, error) {
+	ser, err := h.serializeForSeal()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha3.Sum256(ser)
+	return hash[:], nil
+}
+
+// checks hash and tx root are consistent with contents,
+// does not check any consensus rule
+func (b *Block) VerifyIntegrity() (bool, error) {
+	hash, err := b.CalcHash()
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(hash, b.Hash) {
+		return false, nil
+	}
+
+	txRoot, err := b.Bundle.HashTransactions()
+	if err != nil {
+		return false, err
 	}
-	return &block
+	return bytes.Equal(txRoot, b.TxRoot), nil
 }
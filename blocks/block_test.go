@@ -0,0 +1,117 @@
+package blocks
+
+import (
+	"bytes"
+	"simple-blockchain-go/transactions"
+	"testing"
+)
+
+func newTestBlock(t *testing.T) *Block {
+	bundle := transactions.TxBundle{Transactions: []transactions.Transaction{
+		{Hash: [32]byte{1}},
+		{Hash: [32]byte{2}},
+	}}
+	block, err := NewBlock(bundle, BlockInfo{
+		Height:            1,
+		Difficulty:        3,
+		PreviousBlockHash: []byte{9, 9},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.StateRoot = []byte{4}
+	block.ReceiptsRoot = []byte{5}
+	block.Hash, err = block.CalcHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestHeaderHashCommitsToEveryField(t *testing.T) {
+	changes := map[string]func(h *BlockHeader){
+		"version":       func(h *BlockHeader) { h.Version++ },
+		"height":        func(h *BlockHeader) { h.Height++ },
+		"previous hash": func(h *BlockHeader) { h.PreviousBlockHash = []byte{9} },
+		"tx root":       func(h *BlockHeader) { h.TxRoot = nil },
+		"state root":    func(h *BlockHeader) { h.StateRoot = []byte{6} },
+		"receipts root": func(h *BlockHeader) { h.ReceiptsRoot = []byte{6} },
+		"timestamp":     func(h *BlockHeader) { h.Timestamp++ },
+		"difficulty":    func(h *BlockHeader) { h.Difficulty++ },
+		"coinbase":      func(h *BlockHeader) { h.Coinbase = []byte{7} },
+		"nonce":         func(h *BlockHeader) { h.Nonce++ },
+		"signature":     func(h *BlockHeader) { h.Signature = []byte{8} },
+	}
+	for name, change := range changes {
+		block := newTestBlock(t)
+		change(&block.BlockHeader)
+		hash, err := block.CalcHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(hash, block.Hash) {
+			t.Errorf("hash does not change with %s", name)
+		}
+	}
+}
+
+// bytes moved from one field to next must not give same hash
+func TestHeaderFieldBoundaries(t *testing.T) {
+	a := BlockHeader{StateRoot: []byte{1, 2}, ReceiptsRoot: []byte{3}}
+	b := BlockHeader{StateRoot: []byte{1}, ReceiptsRoot: []byte{2, 3}}
+	ha, err := a.CalcHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := b.CalcHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ha, hb) {
+		t.Error("shifted fields give same hash")
+	}
+}
+
+func TestSealHashExcludesSignature(t *testing.T) {
+	block := newTestBlock(t)
+	before, err := block.SealHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Signature = []byte{1, 2, 3}
+	after, err := block.SealHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("seal hash changes with signature")
+	}
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	block := newTestBlock(t)
+	ok, err := block.VerifyIntegrity()
+	if err != nil || !ok {
+		t.Fatalf("valid block is refused: %v", err)
+	}
+
+	// body which is not committed by tx root
+	block.Bundle.Transactions[0].Hash = [32]byte{3}
+	ok, err = block.VerifyIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("block with changed body is accepted")
+	}
+
+	block = newTestBlock(t)
+	block.Hash = []byte{1}
+	ok, err = block.VerifyIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("block with wrong hash is accepted")
+	}
+}
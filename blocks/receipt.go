@@ -0,0 +1,73 @@
+package blocks
+
+import (
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/merkleTree"
+)
+
+const (
+	RECEIPT_FAILED byte = iota
+	RECEIPT_SUCCESS
+)
+
+// error codes of failed receipts
+const (
+	ERR_CODE_NONE byte = iota
+	ERR_CODE_EXECUTION
+	ERR_CODE_NONCE
+	ERR_CODE_FEE
+	ERR_CODE_OUT_OF_GAS
+	ERR_CODE_CONTRACT
+)
+
+const (
+	EVENT_MULTISIG_CREATED  = "multisig_created"
+	EVENT_ESCROW_CREATED    = "escrow_created"
+	EVENT_TOKEN_CREATED     = "token_created"
+	EVENT_CONTRACT_DEPLOYED = "contract_deployed"
+	EVENT_CONTRACT_LOG      = "contract_log"
+)
+
+type BalanceChange struct {
+	PublicKey []byte
+	Before    uint64
+	After     uint64
+}
+
+// Source is contract address for contract logs
+type Event struct {
+	Name   string
+	Source []byte
+	Data   []byte
+}
+
+// outcome of a transaction,
+// failed transaction still consumes nonce and pays fee when it can
+type Receipt struct {
+	TxHash         [32]byte
+	Status         byte
+	ErrorCode      byte
+	Error          string
+	Fee            uint64
+	GasUsed        uint64
+	BalanceChanges []BalanceChange
+	Events         []Event
+}
+
+// receipts are as many as transactions, so power of 2
+func HashReceipts(receipts []Receipt) ([]byte, error) {
+	var leaves [][]byte
+	for _, r := range receipts {
+		enc, err := common.Encode(r)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, enc)
+	}
+
+	mTree, err := merkleTree.NewMerkleTree(leaves)
+	if err != nil {
+		return nil, err
+	}
+	return mTree.RootNode.Data, nil
+}
@@ -0,0 +1,34 @@
+package blocks
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHashReceipts(t *testing.T) {
+	receipts := []Receipt{
+		{TxHash: [32]byte{1}, Status: RECEIPT_SUCCESS, Fee: 1},
+		{TxHash: [32]byte{2}, Status: RECEIPT_SUCCESS, Fee: 1},
+	}
+	root, err := HashReceipts(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// outcome is committed, not only which transactions ran
+	receipts[1].Status = RECEIPT_FAILED
+	failed, err := HashReceipts(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(root, failed) {
+		t.Error("root does not change with status")
+	}
+}
+
+func TestHashReceiptsNeedsPowerOf2(t *testing.T) {
+	_, err := HashReceipts(make([]Receipt, 3))
+	if err == nil {
+		t.Error("3 receipts are hashed")
+	}
+}
@@ -1,18 +1,54 @@
 package cli
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"simple-blockchain-go/logger"
+	"strings"
+	"syscall"
 )
 
 func printUsage() {
 	fmt.Println()
 	fmt.Println("usage:")
-	fmt.Println(" miner -p PORT (start miner on PORT)")
-	fmt.Println(" executer -p PORT (start storage node on PORT)")
-	fmt.Println(" wallet -p PORT (start wallet on PORT)")
+	fmt.Println(" miner -p PORT [-t WORKERS] [-pool PORT] [-metrics ADDR] (start miner on PORT)")
+	fmt.Println(" pool -p PORT [-metrics ADDR] (start mining pool on PORT)")
+	fmt.Println(" executer -p PORT [-index] [-rpc ADDR] [-rpc-token TOKEN] [-explorer ADDR] [-metrics ADDR] (start storage node on PORT)")
+	fmt.Println(" reindex -p PORT (rebuild indexes of executer on PORT)")
+	fmt.Println(" wallet -p PORT [-token ID,...] [-metrics ADDR] (start wallet on PORT)")
 	fmt.Println()
+	fmt.Println(" every command takes [-log LEVELS] [-log-format json|text]")
+	fmt.Println()
+}
+
+const metricsUsage = "bind address of prometheus metrics, empty means disabled"
+
+var logUsage = fmt.Sprintf(
+	"log levels as default and subsystem=level pairs, e.g. info,sync=debug (subsystems: %s)",
+	strings.Join(logger.SUBSYSTEMS, ", "),
+)
+
+type logFlags struct {
+	levels *string
+	format *string
+}
+
+func addLogFlags(cmd *flag.FlagSet) *logFlags {
+	return &logFlags{
+		levels: cmd.String("log", "info", logUsage),
+		format: cmd.String("log-format", logger.FORMAT_JSON, "log output format, json or text"),
+	}
+}
+
+func (f *logFlags) configure() error {
+	err := logger.Configure(*f.levels)
+	if err != nil {
+		return err
+	}
+	return logger.SetFormat(*f.format)
 }
 
 func validateArgs() {
@@ -27,10 +63,30 @@ func Run() error {
 	executerCmd := flag.NewFlagSet("executer", flag.ExitOnError)
 	minerCmd := flag.NewFlagSet("miner", flag.ExitOnError)
 	walletCmd := flag.NewFlagSet("wallet", flag.ExitOnError)
+	poolCmd := flag.NewFlagSet("pool", flag.ExitOnError)
+	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
 
 	executerPort := executerCmd.String("p", "3000", "port number to use")
+	executerIndex := executerCmd.Bool("index", false, "maintain tx and account history indexes")
+	executerRpc := executerCmd.String("rpc", "", "bind address of json-rpc server, empty means disabled")
+	executerRpcToken := executerCmd.String("rpc-token", "", "bearer token which rpc clients must send")
+	executerExplorer := executerCmd.String("explorer", "", "bind address of block explorer, empty means disabled")
+	executerMetrics := executerCmd.String("metrics", "", metricsUsage)
 	minerPort := minerCmd.String("p", "3001", "port number to use")
+	minerWorkers := minerCmd.Int("t", 0, "number of mining workers, 0 means all cores")
+	minerPool := minerCmd.String("pool", "", "port number of pool to mine for")
+	minerMetrics := minerCmd.String("metrics", "", metricsUsage)
 	walletPort := walletCmd.String("p", "3002", "port number to use")
+	walletTokens := walletCmd.String("token", "", "comma separated base58 token ids to query")
+	walletMetrics := walletCmd.String("metrics", "", metricsUsage)
+	poolPort := poolCmd.String("p", "3004", "port number to use")
+	poolMetrics := poolCmd.String("metrics", "", metricsUsage)
+	reindexPort := reindexCmd.String("p", "3000", "port number of executer")
+
+	logs := map[string]*logFlags{}
+	for _, cmd := range []*flag.FlagSet{executerCmd, minerCmd, walletCmd, poolCmd, reindexCmd} {
+		logs[cmd.Name()] = addLogFlags(cmd)
+	}
 
 	var err error
 	switch os.Args[1] {
@@ -40,6 +96,10 @@ func Run() error {
 		err = minerCmd.Parse(os.Args[2:])
 	case "wallet":
 		err = walletCmd.Parse(os.Args[2:])
+	case "pool":
+		err = poolCmd.Parse(os.Args[2:])
+	case "reindex":
+		err = reindexCmd.Parse(os.Args[2:])
 	default:
 		printUsage()
 		os.Exit(1)
@@ -47,14 +107,29 @@ func Run() error {
 	if err != nil {
 		return err
 	}
+	err = logs[os.Args[1]].configure()
+	if err != nil {
+		return err
+	}
+
+	// interrupt stops node gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	fmt.Println()
 	if executerCmd.Parsed() {
-		err = startExecuterNode(*executerPort)
+		err = startExecuterNode(
+			ctx, *executerPort, *executerIndex, *executerRpc, *executerRpcToken,
+			*executerExplorer, *executerMetrics,
+		)
 	} else if minerCmd.Parsed() {
-		err = startMinerNode(*minerPort)
+		err = startMinerNode(ctx, *minerPort, *minerWorkers, *minerPool, *minerMetrics)
 	} else if walletCmd.Parsed() {
-		err = startWalletNode(*walletPort)
+		err = startWalletNode(ctx, *walletPort, *walletTokens, *walletMetrics)
+	} else if poolCmd.Parsed() {
+		err = startPoolNode(ctx, *poolPort, *poolMetrics)
+	} else if reindexCmd.Parsed() {
+		err = reindexDatabase(*reindexPort)
 	}
 	return err
 }
@@ -1,13 +1,39 @@
 package cli
 
 import (
+	"context"
+	"errors"
+	"simple-blockchain-go/database"
 	"simple-blockchain-go/nodes"
 )
 
-func startExecuterNode(port string) error {
-	s, err := nodes.NewExecuterNode(port)
+func startExecuterNode(
+	ctx context.Context, port string, index bool, rpcAddr string, rpcToken string,
+	explorerAddr string, metricsAddr string,
+) error {
+	s, err := nodes.NewExecuterNode(port, index)
 	if err != nil {
 		return err
 	}
-	return s.Run()
+	s.EnableMetrics(metricsAddr)
+	s.EnableRpc(rpcAddr, rpcToken)
+	err = s.EnableExplorer(explorerAddr)
+	if err != nil {
+		return err
+	}
+	return s.Run(ctx)
+}
+
+// rebuilds indexes of executer's database on PORT,
+// the executer must not be running
+func reindexDatabase(port string) error {
+	if !database.ExistsDatabaseFile(port) {
+		return errors.New("database is not found")
+	}
+	db, err := database.Open(port)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Reindex()
 }
@@ -1,10 +1,14 @@
 package cli
 
 import (
+	"context"
 	"simple-blockchain-go/nodes"
 )
 
-func startMinerNode(port string) error {
-	m := nodes.NewMinerNode(port)
-	return m.Run()
+func startMinerNode(
+	ctx context.Context, port string, workers int, poolPort string, metricsAddr string,
+) error {
+	m := nodes.NewMinerNode(port, workers, poolPort)
+	m.EnableMetrics(metricsAddr)
+	return m.Run(ctx)
 }
@@ -0,0 +1,15 @@
+package cli
+
+import (
+	"context"
+	"simple-blockchain-go/nodes"
+)
+
+func startPoolNode(ctx context.Context, port string, metricsAddr string) error {
+	p, err := nodes.NewPoolNode(port)
+	if err != nil {
+		return err
+	}
+	p.EnableMetrics(metricsAddr)
+	return p.Run(ctx)
+}
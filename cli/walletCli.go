@@ -1,13 +1,31 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"simple-blockchain-go/nodes"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
 )
 
-func startWalletNode(port string) error {
-	w, err := nodes.NewWalletNode(port)
+func startWalletNode(ctx context.Context, port string, tokens string, metricsAddr string) error {
+	var tokenIds [][]byte
+	for _, t := range strings.Split(tokens, ",") {
+		if t == "" {
+			continue
+		}
+		id := base58.Decode(t)
+		if len(id) == 0 {
+			return errors.New("invalid token id")
+		}
+		tokenIds = append(tokenIds, id)
+	}
+
+	w, err := nodes.NewWalletNode(port, tokenIds)
 	if err != nil {
 		return err
 	}
-	return w.Run()
+	w.EnableMetrics(metricsAddr)
+	return w.Run(ctx)
 }
@@ -62,7 +62,11 @@ func ExistFile(name string) bool {
 	return !os.IsNotExist(err)
 }
 
+// keys come from peers and ed25519 panics on other sizes
 func QuickVerify(sig []byte, pubKey []byte, content []byte) bool {
+	if len(pubKey) != ed25519.PublicKeySize {
+		return false
+	}
 	return ed25519.Verify(pubKey, content, sig)
 }
 
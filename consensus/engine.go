@@ -0,0 +1,69 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"simple-blockchain-go/blocks"
+)
+
+const (
+	POW_ENGINE = "pow"
+	POA_ENGINE = "poa"
+	POS_ENGINE = "pos"
+)
+
+var (
+	// returned by Prepare when this node is not allowed to seal the header
+	ErrNotSealer = errors.New("this node can not seal the block")
+)
+
+// read access to local chain which engines need,
+// head of the chain is always parent of verified block
+type ChainReader interface {
+	GetRecentHeaders(n int) ([]blocks.BlockHeader, error)
+	GetBlockByHeight(height uint64) (*blocks.Block, error)
+	GetStateValue(key []byte) ([]byte, error)
+}
+
+// state access for finalization and consensus transactions
+type StateWriter interface {
+	AddBalance(pubKey []byte, amount uint64) error
+	PutStateValue(key []byte, value []byte) error
+}
+
+// consensus rules of the chain.
+// executer nodes run blocks through it
+// instead of depending on a particular algorithm
+type Engine interface {
+	Name() string
+
+	// checks consensus fields of header which is next of chain's head.
+	// false means header is invalid, error means failure of checking itself
+	VerifyHeader(
+		chain ChainReader, header *blocks.BlockHeader, hash []byte,
+	) (bool, error)
+
+	// difficulty which next block of chain's head has to have
+	CalcDifficulty(chain ChainReader) (byte, error)
+
+	// true when this node is allowed to produce next block of chain's head.
+	// checked before executing transactions for the block
+	CanSeal(chain ChainReader) (bool, error)
+
+	// fills consensus fields of new header which is next of chain's head.
+	// called before transactions of the block are executed
+	Prepare(chain ChainReader, header *blocks.BlockHeader) error
+
+	// true when seal is produced out of executer, by miners for example.
+	// then executer offers block and waits for it to come back
+	IsRemoteSealing() bool
+
+	// fills seal and hash of block, blocks until done or ctx is done
+	Seal(ctx context.Context, block *blocks.Block) error
+
+	// applies rewards after transactions of block are executed,
+	// state root is calculated after this
+	Finalize(
+		chain ChainReader, state StateWriter, block *blocks.Block,
+	) error
+}
@@ -1,26 +1,40 @@
 package database
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"simple-blockchain-go/accounts"
 	"simple-blockchain-go/blocks"
 	"simple-blockchain-go/common"
 	"simple-blockchain-go/geneis"
+	"simple-blockchain-go/logger"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+var stateLog = logger.New(logger.STATE)
+
 const (
 	DATABASE_FILE = "%s_database.db"
 	BLOCKS_BUCKET = "blocks"
 	STATE_BUCKET  = "state"
-	LATEST_TAG    = "latest"
-	HEIGHT_TAG    = "height"
+	// consensus, escrow, token, contract and multisig objects,
+	// kept apart so that their keys never collide with accounts
+	OBJECTS_BUCKET = "objects"
+	// encoded receipts by block hash
+	RECEIPTS_BUCKET = "receipts"
+	LATEST_TAG      = "latest"
+	HEIGHT_TAG      = "height"
 )
 
 type Database struct {
-	innerDb *bolt.DB
+	innerDb  *bolt.DB
+	journal  *journal
+	pending  *pendingBlock
+	indexing bool
+	// reads skip pending block
+	committed bool
 }
 
 func DatabaseFileName(id string) string {
@@ -33,9 +47,26 @@ func ExistsDatabaseFile(id string) bool {
 
 func Open(id string) (Database, error) {
 	if ExistsDatabaseFile(id) {
-		log.Printf("found existing database for id: %s\n", id)
+		stateLog.Info("found existing database", logger.F("id", id))
 		db, err := bolt.Open(DatabaseFileName(id), 0600, nil)
-		return Database{db}, err
+		if err != nil {
+			return Database{}, err
+		}
+		// database might be older than receipts and indexes
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, name := range []string{
+				RECEIPTS_BUCKET, TX_INDEX_BUCKET, ACCOUNT_INDEX_BUCKET,
+			} {
+				_, err := tx.CreateBucketIfNotExists([]byte(name))
+				if err != nil {
+					return err
+				}
+			}
+			return migrateObjects(tx)
+		})
+		return Database{
+			innerDb: db, journal: newJournal(), pending: &pendingBlock{},
+		}, err
 	}
 
 	// create new
@@ -57,6 +88,28 @@ func Open(id string) (Database, error) {
 			return err
 		}
 
+		// bucket for state objects
+		_, err = tx.CreateBucket([]byte(OBJECTS_BUCKET))
+		if err != nil {
+			return err
+		}
+
+		// bucket for receipts
+		_, err = tx.CreateBucket([]byte(RECEIPTS_BUCKET))
+		if err != nil {
+			return err
+		}
+
+		// buckets for indexes
+		_, err = tx.CreateBucket([]byte(TX_INDEX_BUCKET))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte(ACCOUNT_INDEX_BUCKET))
+		if err != nil {
+			return err
+		}
+
 		// genesis block
 		genesis, err := geneis.GenerateGenesis()
 		if err != nil {
@@ -99,15 +152,87 @@ func Open(id string) (Database, error) {
 		return err
 	})
 
-	log.Printf("database for id: %s is created\n", id)
-	return Database{db}, err
+	stateLog.Info("database is created", logger.F("id", id))
+	return Database{
+		innerDb: db, journal: newJournal(), pending: &pendingBlock{},
+	}, err
+}
+
+// older databases kept objects in state bucket,
+// every key there which is not a public key is moved
+func migrateObjects(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(OBJECTS_BUCKET)) != nil {
+		return nil
+	}
+	o, err := tx.CreateBucket([]byte(OBJECTS_BUCKET))
+	if err != nil {
+		return err
+	}
+
+	s := tx.Bucket([]byte(STATE_BUCKET))
+	keys := [][]byte{}
+	c := s.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if accounts.IsAccountKey(k) {
+			continue
+		}
+		err = o.Put(k, v)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte{}, k...))
+	}
+	// deleting while iterating skips keys
+	for _, k := range keys {
+		err = s.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		stateLog.Info("state objects are moved", logger.F("count", len(keys)))
+	}
+	return nil
+}
+
+// close waits for writable transaction, so pending block is dropped
+func (db *Database) Close() error {
+	err := db.RollbackBlock()
+	if err != nil {
+		return err
+	}
+	return db.innerDb.Close()
+}
+
+// errors of bolt or file system, state can not be trusted after them
+func IsStorageError(err error) bool {
+	for _, target := range []error{
+		bolt.ErrDatabaseNotOpen, bolt.ErrInvalid, bolt.ErrVersionMismatch,
+		bolt.ErrChecksum, bolt.ErrTimeout, bolt.ErrTxClosed,
+		bolt.ErrDatabaseReadOnly,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	// network errors are not path errors
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr)
+}
+
+// values are valid only in transaction, nil stays nil
+func copyValue(v []byte) []byte {
+	if v == nil {
+		return nil
+	}
+	return append([]byte{}, v...)
 }
 
 func (db *Database) GetHeight() (uint64, error) {
 	var hex []byte
-	err := db.innerDb.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(BLOCKS_BUCKET))
-		hex = b.Get([]byte(HEIGHT_TAG))
+		hex = copyValue(b.Get([]byte(HEIGHT_TAG)))
 		return nil
 	})
 	if err != nil {
@@ -118,9 +243,9 @@ func (db *Database) GetHeight() (uint64, error) {
 
 func (db *Database) GetLatest() ([]byte, error) {
 	var hash []byte
-	err := db.innerDb.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(BLOCKS_BUCKET))
-		hash = b.Get([]byte(LATEST_TAG))
+		hash = copyValue(b.Get([]byte(LATEST_TAG)))
 		return nil
 	})
 	if err != nil {
@@ -131,9 +256,9 @@ func (db *Database) GetLatest() ([]byte, error) {
 
 func (db *Database) GetBlockByHash(blockHash []byte) (*blocks.Block, error) {
 	var enc []byte
-	err := db.innerDb.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(BLOCKS_BUCKET))
-		enc = b.Get(blockHash)
+		enc = copyValue(b.Get(blockHash))
 		return nil
 	})
 	if err != nil {
@@ -144,14 +269,14 @@ func (db *Database) GetBlockByHash(blockHash []byte) (*blocks.Block, error) {
 
 func (db *Database) GetBlockByHeight(height uint64) (*blocks.Block, error) {
 	var enc []byte
-	err := db.innerDb.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(BLOCKS_BUCKET))
 		h, err := common.ToHex(height)
 		if err != nil {
 			return err
 		}
 		hash := b.Get(h)
-		enc = b.Get(hash)
+		enc = copyValue(b.Get(hash))
 		return nil
 	})
 	if err != nil {
@@ -161,7 +286,7 @@ func (db *Database) GetBlockByHeight(height uint64) (*blocks.Block, error) {
 }
 
 func (db *Database) PutBlock(block *blocks.Block) error {
-	return db.innerDb.Update(func(tx *bolt.Tx) error {
+	return db.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(BLOCKS_BUCKET))
 		enc, err := common.Encode(block)
 		if err != nil {
@@ -190,9 +315,9 @@ func (db *Database) PutBlock(block *blocks.Block) error {
 
 func (db *Database) GetAccountState(pubKey []byte) (*accounts.AccountState, error) {
 	var enc []byte
-	err := db.innerDb.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(STATE_BUCKET))
-		enc = b.Get(pubKey)
+		enc = copyValue(b.Get(pubKey))
 		return nil
 	})
 	if err != nil {
@@ -208,25 +333,125 @@ func (db *Database) GetAccountState(pubKey []byte) (*accounts.AccountState, erro
 func (db *Database) PutAccountState(
 	pubKey []byte, state *accounts.AccountState,
 ) error {
-	return db.innerDb.Update(func(tx *bolt.Tx) error {
+	return db.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(STATE_BUCKET))
 		enc, err := common.Encode(state)
 		if err != nil {
 			return err
 		}
+		db.record(b, STATE_BUCKET, pubKey)
 		return b.Put(pubKey, enc)
 	})
 }
 
+// all states are written in one transaction
+func (db *Database) PutAccountStates(
+	pubKeys [][]byte, states []*accounts.AccountState,
+) error {
+	if len(pubKeys) != len(states) {
+		return errors.New("length of keys and states mismatch")
+	}
+	return db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(STATE_BUCKET))
+		for i, state := range states {
+			enc, err := common.Encode(state)
+			if err != nil {
+				return err
+			}
+			db.record(b, STATE_BUCKET, pubKeys[i])
+			err = b.Put(pubKeys[i], enc)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// accounts first and objects after them, both in key order
 func (db *Database) GetAllStates() ([][]byte, error) {
 	raw := [][]byte{}
-	err := db.innerDb.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(STATE_BUCKET))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			raw = append(raw, append(k, v...))
+	err := db.view(func(tx *bolt.Tx) error {
+		for _, name := range []string{STATE_BUCKET, OBJECTS_BUCKET} {
+			c := tx.Bucket([]byte(name)).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				raw = append(raw, append(append([]byte{}, k...), v...))
+			}
 		}
 		return nil
 	})
 	return raw, err
 }
+
+// raw access to objects other than accounts,
+// keys of those objects have own prefix
+func (db *Database) GetStateValue(key []byte) ([]byte, error) {
+	var value []byte
+	err := db.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(OBJECTS_BUCKET))
+		v := b.Get(key)
+		if v != nil {
+			// value is valid only in transaction
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (db *Database) PutStateValue(key []byte, value []byte) error {
+	return db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(OBJECTS_BUCKET))
+		db.record(b, OBJECTS_BUCKET, key)
+		return b.Put(key, value)
+	})
+}
+
+func (db *Database) DeleteStateValue(key []byte) error {
+	return db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(OBJECTS_BUCKET))
+		db.record(b, OBJECTS_BUCKET, key)
+		return b.Delete(key)
+	})
+}
+
+// indexes are updated together when enabled
+func (db *Database) PutReceipts(
+	block *blocks.Block, receipts []blocks.Receipt,
+) error {
+	enc, err := common.Encode(receipts)
+	if err != nil {
+		return err
+	}
+	return db.update(func(tx *bolt.Tx) error {
+		if db.indexing {
+			err := indexBlock(tx, block, receipts)
+			if err != nil {
+				return err
+			}
+		}
+		b := tx.Bucket([]byte(RECEIPTS_BUCKET))
+		return b.Put(block.Hash, enc)
+	})
+}
+
+// nil when block has no receipts, like genesis
+func (db *Database) GetReceipts(blockHash []byte) ([]blocks.Receipt, error) {
+	var enc []byte
+	err := db.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(RECEIPTS_BUCKET))
+		v := b.Get(blockHash)
+		if v != nil {
+			enc = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	receipts, err := common.Decode[[]blocks.Receipt](enc)
+	if err != nil {
+		return nil, err
+	}
+	return *receipts, nil
+}
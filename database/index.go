@@ -0,0 +1,208 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// tx hash to tx reference
+	TX_INDEX_BUCKET = "tx-index"
+	// len(pubkey)||pubkey||height||index to tx hash
+	ACCOUNT_INDEX_BUCKET = "account-index"
+
+	MAX_HISTORY_PAGE = 100
+)
+
+// where transaction is in the chain
+type TxRef struct {
+	Hash   []byte
+	Height uint64
+	Index  uint32
+}
+
+// indexes are maintained only when enabled,
+// enable on running node needs reindex for older blocks,
+// they are written in the transaction of their block, so block which is
+// rolled back leaves none, overwritten blocks leave stale entries
+// until reindex rebuilds them from scratch
+func (db *Database) SetIndexing(enabled bool) {
+	db.indexing = enabled
+}
+
+func (db *Database) Indexing() bool {
+	return db.indexing
+}
+
+func historyPrefix(pubKey []byte) []byte {
+	return append([]byte{byte(len(pubKey))}, pubKey...)
+}
+
+func historyKey(pubKey []byte, height uint64, index uint32) []byte {
+	k := historyPrefix(pubKey)
+	k = binary.BigEndian.AppendUint64(k, height)
+	return binary.BigEndian.AppendUint32(k, index)
+}
+
+// sender and every account whose balance is changed
+func involvedAccounts(
+	block *blocks.Block, receipts []blocks.Receipt, i int,
+) [][]byte {
+	tx := block.Bundle.Transactions[i]
+	accounts := [][]byte{tx.InnerData.PublicKey}
+	seen := map[string]bool{string(tx.InnerData.PublicKey): true}
+	// old blocks might have no receipts
+	if i >= len(receipts) {
+		return accounts
+	}
+	for _, c := range receipts[i].BalanceChanges {
+		if seen[string(c.PublicKey)] {
+			continue
+		}
+		seen[string(c.PublicKey)] = true
+		accounts = append(accounts, c.PublicKey)
+	}
+	return accounts
+}
+
+func indexBlock(
+	tx *bolt.Tx, block *blocks.Block, receipts []blocks.Receipt,
+) error {
+	txIndex := tx.Bucket([]byte(TX_INDEX_BUCKET))
+	accountIndex := tx.Bucket([]byte(ACCOUNT_INDEX_BUCKET))
+	for i, t := range block.Bundle.Transactions {
+		ref := TxRef{
+			Hash:   t.Hash[:],
+			Height: block.Height,
+			Index:  uint32(i),
+		}
+		enc, err := common.Encode(ref)
+		if err != nil {
+			return err
+		}
+		err = txIndex.Put(ref.Hash, enc)
+		if err != nil {
+			return err
+		}
+		for _, pubKey := range involvedAccounts(block, receipts, i) {
+			err = accountIndex.Put(
+				historyKey(pubKey, ref.Height, ref.Index), ref.Hash,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nil when transaction is not indexed
+func (db *Database) GetTxRef(hash []byte) (*TxRef, error) {
+	var enc []byte
+	err := db.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(TX_INDEX_BUCKET))
+		v := b.Get(hash)
+		if v != nil {
+			enc = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	return common.Decode[TxRef](enc)
+}
+
+// newest first, skips offset entries
+func (db *Database) GetAccountHistory(
+	pubKey []byte, offset int, limit int,
+) ([]TxRef, error) {
+	if offset < 0 || limit <= 0 || limit > MAX_HISTORY_PAGE {
+		return nil, errors.New("invalid page")
+	}
+	prefix := historyPrefix(pubKey)
+	// first key after every key of prefix
+	end := historyKey(pubKey, ^uint64(0), ^uint32(0))
+
+	refs := []TxRef{}
+	err := db.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(ACCOUNT_INDEX_BUCKET)).Cursor()
+		k, v := c.Seek(end)
+		if k == nil {
+			k, v = c.Last()
+		} else if string(k) != string(end) {
+			k, v = c.Prev()
+		}
+		skipped := 0
+		for ; k != nil && hasPrefix(k, prefix); k, v = c.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			refs = append(refs, TxRef{
+				Hash:   append([]byte{}, v...),
+				Height: binary.BigEndian.Uint64(k[len(prefix):]),
+				Index:  binary.BigEndian.Uint32(k[len(prefix)+8:]),
+			})
+			if len(refs) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	return refs, err
+}
+
+func hasPrefix(k []byte, prefix []byte) bool {
+	return len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix)
+}
+
+// rebuilds both indexes from stored blocks and receipts
+func (db *Database) Reindex() error {
+	height, err := db.GetHeight()
+	if err != nil {
+		return err
+	}
+
+	err = db.update(func(tx *bolt.Tx) error {
+		for _, name := range []string{TX_INDEX_BUCKET, ACCOUNT_INDEX_BUCKET} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// one transaction per block keeps memory flat
+	for h := uint64(0); h <= height; h++ {
+		block, err := db.GetBlockByHeight(h)
+		if err != nil {
+			return err
+		}
+		receipts, err := db.GetReceipts(block.Hash)
+		if err != nil {
+			return err
+		}
+		err = db.update(func(tx *bolt.Tx) error {
+			return indexBlock(tx, block, receipts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	stateLog.Info("reindexed blocks", logger.Height(height))
+	return nil
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type journalEntry struct {
+	bucket  string
+	key     []byte
+	value   []byte
+	existed bool
+}
+
+// original values of state keys written since begin,
+// it rolls back a failed transaction inside block,
+// every write is recorded, so callers must not write state concurrently
+// while journal is active (executer writes under chain lock)
+type journal struct {
+	active  bool
+	entries map[string]*journalEntry
+	// keys in order of first write
+	order []string
+}
+
+func newJournal() *journal {
+	return &journal{}
+}
+
+func (j *journal) begin() {
+	j.active = true
+	j.entries = map[string]*journalEntry{}
+	j.order = nil
+}
+
+func (j *journal) end() {
+	j.active = false
+	j.entries = map[string]*journalEntry{}
+	j.order = nil
+}
+
+// keeps only the first value, which is the one before begin
+func (j *journal) record(b *bolt.Bucket, bucket string, key []byte) {
+	if !j.active {
+		return
+	}
+	id := bucket + "/" + string(key)
+	if _, ok := j.entries[id]; ok {
+		return
+	}
+
+	v := b.Get(key)
+	j.entries[id] = &journalEntry{
+		bucket:  bucket,
+		key:     append([]byte{}, key...),
+		value:   append([]byte{}, v...),
+		existed: v != nil,
+	}
+	j.order = append(j.order, id)
+}
+
+// restores every key written since begin, latest first
+func (j *journal) revert(db *Database) error {
+	err := db.update(func(tx *bolt.Tx) error {
+		for i := len(j.order) - 1; i >= 0; i-- {
+			e := j.entries[j.order[i]]
+			b := tx.Bucket([]byte(e.bucket))
+			var err error
+			if e.existed {
+				err = b.Put(e.key, e.value)
+			} else {
+				err = b.Delete(e.key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	j.entries = map[string]*journalEntry{}
+	j.order = nil
+	return nil
+}
+
+func (db *Database) record(b *bolt.Bucket, bucket string, key []byte) {
+	if db.journal == nil {
+		return
+	}
+	db.journal.record(b, bucket, key)
+}
+
+func (db *Database) BeginJournal() {
+	db.journal.begin()
+}
+
+// restores every key written since begin,
+// journal keeps recording after this
+func (db *Database) RevertJournal() error {
+	return db.journal.revert(db)
+}
+
+// balances of accounts written since begin which differ from before
+func (db *Database) JournalBalanceChanges() ([]blocks.BalanceChange, error) {
+	j := db.journal
+	changes := []blocks.BalanceChange{}
+	for _, k := range j.order {
+		e := j.entries[k]
+		if e.bucket != STATE_BUCKET {
+			continue
+		}
+
+		var before uint64
+		if e.existed {
+			state, err := common.Decode[accounts.AccountState](e.value)
+			if err != nil {
+				return nil, err
+			}
+			before = state.Balance
+		}
+		var after uint64
+		state, err := db.GetAccountState(e.key)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			after = state.Balance
+		}
+
+		if before != after {
+			changes = append(changes, blocks.BalanceChange{
+				PublicKey: e.key,
+				Before:    before,
+				After:     after,
+			})
+		}
+	}
+	return changes, nil
+}
+
+func (db *Database) EndJournal() {
+	db.journal.end()
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"errors"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// state of block being executed is kept in one writable transaction,
+// it is committed together with the block or rolled back,
+// so that neither crash nor readers outside of execution see it
+type pendingBlock struct {
+	sync.Mutex
+	tx *bolt.Tx
+}
+
+// reads see pending block unless database is committed view,
+// bolt transaction is not safe for concurrent use, so it is locked
+func (db *Database) view(fn func(tx *bolt.Tx) error) error {
+	if !db.committed {
+		p := db.pending
+		p.Lock()
+		if p.tx != nil {
+			defer p.Unlock()
+			return fn(p.tx)
+		}
+		p.Unlock()
+	}
+	return db.innerDb.View(fn)
+}
+
+// writes go to pending block when there is one,
+// lock is kept so that block can not begin in the middle of write
+func (db *Database) update(fn func(tx *bolt.Tx) error) error {
+	p := db.pending
+	p.Lock()
+	defer p.Unlock()
+	if p.tx != nil {
+		return fn(p.tx)
+	}
+	return db.innerDb.Update(fn)
+}
+
+// every read and write after this goes to the block
+func (db *Database) BeginBlock() error {
+	p := db.pending
+	p.Lock()
+	defer p.Unlock()
+	if p.tx != nil {
+		return errors.New("block is already pending")
+	}
+	tx, err := db.innerDb.Begin(true)
+	if err != nil {
+		return err
+	}
+	p.tx = tx
+	return nil
+}
+
+func (db *Database) InBlock() bool {
+	p := db.pending
+	p.Lock()
+	defer p.Unlock()
+	return p.tx != nil
+}
+
+// state, block and receipts are stored at once
+func (db *Database) CommitBlock() error {
+	p := db.pending
+	p.Lock()
+	defer p.Unlock()
+	if p.tx == nil {
+		return errors.New("no block is pending")
+	}
+	// bolt rolls back by itself when commit fails
+	err := p.tx.Commit()
+	p.tx = nil
+	return err
+}
+
+// state is back to the one before block
+func (db *Database) RollbackBlock() error {
+	p := db.pending
+	p.Lock()
+	defer p.Unlock()
+	if p.tx == nil {
+		return nil
+	}
+	err := p.tx.Rollback()
+	p.tx = nil
+	return err
+}
+
+// view of stored data without pending block,
+// for readers outside of execution such as rpc and wallets
+func (db *Database) Committed() Database {
+	c := *db
+	c.committed = true
+	return c
+}
@@ -1,28 +1,50 @@
 package epoch
 
+import "context"
+
 type Epoch struct {
-	f func()
-	c chan bool
+	f    func()
+	c    chan bool
+	done chan struct{}
 }
 
 func NewEpoch(f func()) *Epoch {
 	return &Epoch{
-		f: f,
-		c: make(chan bool),
+		f:    f,
+		c:    make(chan bool),
+		done: make(chan struct{}),
 	}
 }
 
+// sending false stops routine as well as ctx does
 func (e *Epoch) C() chan<- bool {
 	return e.c
 }
 
-func (e *Epoch) StartEpochRoutine() {
-	for flg := range e.c {
-		if flg {
+// runs f once more, does nothing after routine stopped
+func (e *Epoch) Trigger() {
+	select {
+	case e.c <- true:
+	case <-e.done:
+	}
+}
+
+// closed when routine stopped and f is not running
+func (e *Epoch) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *Epoch) StartEpochRoutine(ctx context.Context) {
+	defer close(e.done)
+	for {
+		select {
+		case flg := <-e.c:
+			if !flg {
+				return
+			}
 			e.f()
-		} else {
-			break
+		case <-ctx.Done():
+			return
 		}
 	}
-	close(e.c)
 }
@@ -0,0 +1,380 @@
+package explorer
+
+import (
+	"context"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/database"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/p2p"
+	"simple-blockchain-go/transactions"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+var rpcLog = logger.New(logger.RPC)
+
+const (
+	LATEST_BLOCKS = 20
+	HISTORY_PAGE  = 25
+	// seconds
+	READ_TIMEOUT  = 10
+	WRITE_TIMEOUT = 10
+)
+
+//go:embed assets
+var assets embed.FS
+
+// what explorer needs from running node
+type Source interface {
+	Summary() Summary
+	GetBlockByHeight(height uint64) (*blocks.Block, error)
+	GetBlockByHash(hash []byte) (*blocks.Block, error)
+	GetReceipts(blockHash []byte) ([]blocks.Receipt, error)
+	GetAccountState(pubKey []byte) (*accounts.AccountState, error)
+	GetTxRef(hash []byte) (*database.TxRef, error)
+	GetAccountHistory(pubKey []byte, offset int, limit int) ([]database.TxRef, error)
+	Mempool() []transactions.Transaction
+	Peers() []p2p.NodeId
+}
+
+type Summary struct {
+	Consensus       string
+	Height          uint64
+	Difficulty      byte
+	LatestHash      []byte
+	FinalizedHeight uint64
+	IsSyncing       bool
+	MempoolSize     int
+	Indexing        bool
+}
+
+// read only, so no auth
+type Server struct {
+	addr      string
+	source    Source
+	templates map[string]*template.Template
+	server    *http.Server
+}
+
+func NewServer(addr string, source Source) (*Server, error) {
+	s := &Server{
+		addr:      addr,
+		source:    source,
+		templates: map[string]*template.Template{},
+	}
+	funcs := template.FuncMap{
+		"hex":     hex.EncodeToString,
+		"b58":     base58.Encode,
+		"txHash":  func(h [32]byte) string { return hex.EncodeToString(h[:]) },
+		"command": commandName,
+		"time":    formatTime,
+		"add":     func(a, b int) int { return a + b },
+		"sub":     func(a, b int) int { return a - b },
+	}
+	pages := []string{"index", "block", "tx", "account", "mempool", "peers", "error"}
+	for _, p := range pages {
+		t, err := template.New("layout.html").Funcs(funcs).ParseFS(
+			assets, "assets/layout.html", "assets/"+p+".html",
+		)
+		if err != nil {
+			return nil, err
+		}
+		s.templates[p] = t
+	}
+
+	static, err := fs.Sub(assets, "assets/static")
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/block/", s.handleBlock)
+	mux.HandleFunc("/tx/", s.handleTx)
+	mux.HandleFunc("/account/", s.handleAccount)
+	mux.HandleFunc("/mempool", s.handleMempool)
+	mux.HandleFunc("/peers", s.handlePeers)
+	mux.HandleFunc("/search", s.handleSearch)
+
+	s.server = &http.Server{
+		Addr:         s.addr,
+		Handler:      mux,
+		ReadTimeout:  time.Second * READ_TIMEOUT,
+		WriteTimeout: time.Second * WRITE_TIMEOUT,
+	}
+	return s, nil
+}
+
+// returns http.ErrServerClosed after Shutdown
+func (s *Server) ListenAndServe() error {
+	rpcLog.Info("explorer is listening", logger.F("addr", s.addr))
+	return s.server.ListenAndServe()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func commandName(tx transactions.Transaction) string {
+	if len(tx.InnerData.Data) == 0 {
+		return "empty"
+	}
+	kind := transactions.CommandKind(tx.InnerData.Data[0])
+	if kind < transactions.AIRDROP_CMD || kind > transactions.CONTRACT_CALL_CMD {
+		return "unknown command"
+	}
+	return strings.TrimSuffix(kind.ToString(), " command")
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format("2006-01-02 15:04:05")
+}
+
+type page struct {
+	Title   string
+	Summary Summary
+	Data    interface{}
+}
+
+func (s *Server) render(w http.ResponseWriter, status int, name string, title string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := s.templates[name].Execute(w, &page{
+		Title:   title,
+		Summary: s.source.Summary(),
+		Data:    data,
+	})
+	if err != nil {
+		rpcLog.Warn("rendering explorer page failed", logger.F("page", name), logger.Err(err))
+	}
+}
+
+func (s *Server) renderError(w http.ResponseWriter, status int, message string) {
+	s.render(w, status, "error", http.StatusText(status), message)
+}
+
+func (s *Server) internalError(w http.ResponseWriter, err error) {
+	rpcLog.Error("explorer request failed", logger.Err(err))
+	s.renderError(w, http.StatusInternalServerError, "internal error")
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		s.renderError(w, http.StatusNotFound, "page is not found")
+		return
+	}
+	height := s.source.Summary().Height
+	latest := []*blocks.Block{}
+	for i := 0; i < LATEST_BLOCKS; i++ {
+		block, err := s.source.GetBlockByHeight(height)
+		if err != nil {
+			s.internalError(w, err)
+			return
+		}
+		latest = append(latest, block)
+		if height == 0 {
+			break
+		}
+		height--
+	}
+	s.render(w, http.StatusOK, "index", "latest blocks", latest)
+}
+
+type blockView struct {
+	Block    *blocks.Block
+	Receipts []blocks.Receipt
+}
+
+// path is /block/HEIGHT or /block/HEX_HASH
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/block/")
+	var block *blocks.Block
+	var err error
+	if height, perr := strconv.ParseUint(id, 10, 64); perr == nil {
+		if height > s.source.Summary().Height {
+			s.renderError(w, http.StatusNotFound, "block is not found")
+			return
+		}
+		block, err = s.source.GetBlockByHeight(height)
+	} else {
+		hash, herr := hex.DecodeString(id)
+		if herr != nil || len(hash) != 32 {
+			s.renderError(w, http.StatusBadRequest, "invalid block id")
+			return
+		}
+		block, err = s.source.GetBlockByHash(hash)
+		if err != nil {
+			s.renderError(w, http.StatusNotFound, "block is not found")
+			return
+		}
+	}
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+
+	receipts, err := s.source.GetReceipts(block.Hash)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	title := "block " + strconv.FormatUint(block.Height, 10)
+	s.render(w, http.StatusOK, "block", title, &blockView{block, receipts})
+}
+
+type txView struct {
+	Tx      transactions.Transaction
+	Pending bool
+	Ref     *database.TxRef
+	Block   *blocks.Block
+	Receipt *blocks.Receipt
+}
+
+func (s *Server) handleTx(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/tx/"))
+	if err != nil || len(hash) != 32 {
+		s.renderError(w, http.StatusBadRequest, "invalid transaction hash")
+		return
+	}
+	title := "transaction"
+
+	for _, tx := range s.source.Mempool() {
+		if string(tx.Hash[:]) == string(hash) {
+			s.render(w, http.StatusOK, "tx", title, &txView{Tx: tx, Pending: true})
+			return
+		}
+	}
+	if !s.source.Summary().Indexing {
+		s.renderError(w, http.StatusNotFound, "transaction is not pending and indexing is disabled")
+		return
+	}
+	ref, err := s.source.GetTxRef(hash)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if ref == nil {
+		s.renderError(w, http.StatusNotFound, "transaction is not found")
+		return
+	}
+	block, err := s.source.GetBlockByHeight(ref.Height)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	receipts, err := s.source.GetReceipts(block.Hash)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	// index might be stale, it is never pruned
+	txs := block.Bundle.Transactions
+	if int(ref.Index) >= len(txs) ||
+		string(txs[ref.Index].Hash[:]) != string(hash) {
+		s.renderError(w, http.StatusNotFound, "transaction is not found")
+		return
+	}
+	view := &txView{
+		Tx:    txs[ref.Index],
+		Ref:   ref,
+		Block: block,
+	}
+	if int(ref.Index) < len(receipts) {
+		view.Receipt = &receipts[ref.Index]
+	}
+	s.render(w, http.StatusOK, "tx", title, view)
+}
+
+type accountView struct {
+	PublicKey []byte
+	State     *accounts.AccountState
+	History   []database.TxRef
+	Page      int
+	HasNext   bool
+}
+
+// path is /account/BASE58_KEY?page=N
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	pubKey := base58.Decode(strings.TrimPrefix(r.URL.Path, "/account/"))
+	if !accounts.IsAccountKey(pubKey) {
+		s.renderError(w, http.StatusBadRequest, "invalid public key")
+		return
+	}
+	pageNum, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || pageNum < 0 {
+		pageNum = 0
+	}
+
+	state, err := s.source.GetAccountState(pubKey)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if state == nil {
+		state = &accounts.AccountState{}
+	}
+	view := &accountView{PublicKey: pubKey, State: state, Page: pageNum}
+	if s.source.Summary().Indexing {
+		// one more tells whether next page exists
+		refs, err := s.source.GetAccountHistory(
+			pubKey, pageNum*HISTORY_PAGE, HISTORY_PAGE+1,
+		)
+		if err != nil {
+			s.internalError(w, err)
+			return
+		}
+		if len(refs) > HISTORY_PAGE {
+			view.HasNext = true
+			refs = refs[:HISTORY_PAGE]
+		}
+		view.History = refs
+	}
+	s.render(w, http.StatusOK, "account", "account", view)
+}
+
+// 64 hex chars are tx hash when it is known, otherwise block hash
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	target := "/"
+	if _, err := strconv.ParseUint(q, 10, 64); err == nil {
+		target = "/block/" + q
+	} else if hash, err := hex.DecodeString(q); err == nil && len(hash) == 32 {
+		target = "/block/" + q
+		if s.isKnownTx(hash) {
+			target = "/tx/" + q
+		}
+	} else if q != "" {
+		target = "/account/" + q
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func (s *Server) isKnownTx(hash []byte) bool {
+	for _, tx := range s.source.Mempool() {
+		if string(tx.Hash[:]) == string(hash) {
+			return true
+		}
+	}
+	if !s.source.Summary().Indexing {
+		return false
+	}
+	ref, err := s.source.GetTxRef(hash)
+	return err == nil && ref != nil
+}
+
+func (s *Server) handleMempool(w http.ResponseWriter, r *http.Request) {
+	s.render(w, http.StatusOK, "mempool", "mempool", s.source.Mempool())
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	s.render(w, http.StatusOK, "peers", "peers", s.source.Peers())
+}
@@ -0,0 +1,89 @@
+package geneis
+
+import (
+	"encoding/json"
+	"os"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+)
+
+var stateLog = logger.New(logger.STATE)
+
+const (
+	// shared by all nodes on the network
+	GENESIS_CONFIG_FILE = "genesis_config.json"
+
+	DEFAULT_CONSENSUS                 = "pow"
+	DEFAULT_MAX_FUTURE_DRIFT   int64  = 15
+	DEFAULT_MEDIAN_TIME_WINDOW        = 11
+	DEFAULT_UNBONDING_PERIOD   uint64 = 100
+	DEFAULT_MIN_STAKE          uint64 = 1_000
+	DEFAULT_MAX_REORG_DEPTH    uint64 = 6
+	DEFAULT_MAX_BLOCK_WEIGHT   uint64 = 4_000_000
+	DEFAULT_MAX_BLOCK_SIZE            = 1 << 20
+	DEFAULT_MIN_FEE_PER_WEIGHT uint64 = 0
+)
+
+// chain parameters every node on the network has to agree on
+type Config struct {
+	// name of consensus engine
+	Consensus string
+	// base58 public keys of authorized signers for poa, in turn order
+	Signers []string
+	// base58 public keys of validators for pos until someone stakes
+	Validators []string
+	// blocks until unstaked amount is unlocked
+	UnbondingPeriod uint64
+	// self stake which validator needs to propose
+	MinStake uint64
+	// hex block hashes by height which sync must match
+	Checkpoints map[uint64]string
+	// blocks deeper than this from head are reported final,
+	// chain never rewrites them because it has no fork choice
+	MaxReorgDepth uint64
+	// seconds, how far block's timestamp can be ahead of local clock
+	MaxFutureDrift int64
+	// number of previous blocks for median time past
+	MedianTimeWindow int
+	// sum of weight of transactions in a block
+	MaxBlockWeight uint64
+	// bytes of encoded block
+	MaxBlockSize int
+	// 0 means transactions are free
+	MinFeePerWeight uint64
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Consensus:        DEFAULT_CONSENSUS,
+		MaxFutureDrift:   DEFAULT_MAX_FUTURE_DRIFT,
+		MedianTimeWindow: DEFAULT_MEDIAN_TIME_WINDOW,
+		UnbondingPeriod:  DEFAULT_UNBONDING_PERIOD,
+		MinStake:         DEFAULT_MIN_STAKE,
+		Checkpoints:      map[uint64]string{},
+		MaxReorgDepth:    DEFAULT_MAX_REORG_DEPTH,
+		MaxBlockWeight:   DEFAULT_MAX_BLOCK_WEIGHT,
+		MaxBlockSize:     DEFAULT_MAX_BLOCK_SIZE,
+		MinFeePerWeight:  DEFAULT_MIN_FEE_PER_WEIGHT,
+	}
+}
+
+// fields which are not in the file keep default value
+func LoadConfig() (*Config, error) {
+	config := DefaultConfig()
+	if !common.ExistFile(GENESIS_CONFIG_FILE) {
+		stateLog.Info("genesis config is not found, using default")
+		return config, nil
+	}
+
+	f, err := os.ReadFile(GENESIS_CONFIG_FILE)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(f, config)
+	if err != nil {
+		return nil, err
+	}
+	stateLog.Info("loaded genesis config", logger.F("file", GENESIS_CONFIG_FILE))
+	return config, nil
+}
@@ -0,0 +1,303 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int32
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+// subsystems which verbosity is configured by
+const (
+	P2P     = "p2p"
+	SYNC    = "sync"
+	MEMPOOL = "mempool"
+	POW     = "pow"
+	STATE   = "state"
+	// poa and pos engines
+	CONSENSUS = "consensus"
+	// json-rpc, websocket and explorer servers
+	RPC = "rpc"
+)
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_TEXT = "text"
+	// bytes of hash which are shown in text format
+	SHORT_HASH_SIZE = 6
+)
+
+var SUBSYSTEMS = []string{P2P, SYNC, MEMPOOL, POW, STATE, CONSENSUS, RPC}
+
+func (l Level) ToString() string {
+	switch l {
+	case DEBUG:
+		return "debug"
+	case INFO:
+		return "info"
+	case WARN:
+		return "warn"
+	case ERROR:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseLevel(s string) (Level, error) {
+	for l := DEBUG; l <= ERROR; l++ {
+		if strings.EqualFold(s, l.ToString()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// global output, shared by every logger
+type config struct {
+	lock         sync.RWMutex
+	out          io.Writer
+	format       string
+	defaultLevel Level
+	// by subsystem, default level when missing
+	levels map[string]Level
+}
+
+var cfg = &config{
+	out:          os.Stderr,
+	format:       FORMAT_JSON,
+	defaultLevel: INFO,
+	levels:       map[string]Level{},
+}
+
+func SetOutput(w io.Writer) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.out = w
+}
+
+func SetFormat(format string) error {
+	if format != FORMAT_JSON && format != FORMAT_TEXT {
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.format = format
+	return nil
+}
+
+func SetLevel(subsystem string, level Level) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.levels[subsystem] = level
+}
+
+// spec is comma separated, entry without subsystem sets default,
+// e.g. "warn,sync=debug,p2p=info"
+func Configure(spec string) error {
+	defaultLevel := INFO
+	levels := map[string]Level{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		subsystem, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			l, err := ParseLevel(entry)
+			if err != nil {
+				return err
+			}
+			defaultLevel = l
+			continue
+		}
+		if !isSubsystem(subsystem) {
+			return fmt.Errorf("unknown log subsystem %q", subsystem)
+		}
+		l, err := ParseLevel(value)
+		if err != nil {
+			return err
+		}
+		levels[subsystem] = l
+	}
+
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.defaultLevel = defaultLevel
+	cfg.levels = levels
+	return nil
+}
+
+func isSubsystem(s string) bool {
+	for _, sub := range SUBSYSTEMS {
+		if s == sub {
+			return true
+		}
+	}
+	return false
+}
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Height(h uint64) Field {
+	return Field{Key: "height", Value: h}
+}
+
+func Peer(ip string) Field {
+	return Field{Key: "peer", Value: ip}
+}
+
+// full hash in json, short one in text
+func Hash(hash []byte) Field {
+	return Field{Key: "hash", Value: hashValue(hash)}
+}
+
+func HashOf(key string, hash []byte) Field {
+	return Field{Key: key, Value: hashValue(hash)}
+}
+
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+type hashValue []byte
+
+func (h hashValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h hashValue) String() string {
+	if len(h) <= SHORT_HASH_SIZE {
+		return hex.EncodeToString(h)
+	}
+	return hex.EncodeToString(h[:SHORT_HASH_SIZE]) + ".."
+}
+
+// logger of one subsystem
+type Logger struct {
+	subsystem string
+}
+
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	cfg.lock.RLock()
+	defer cfg.lock.RUnlock()
+	min, ok := cfg.levels[l.subsystem]
+	if !ok {
+		min = cfg.defaultLevel
+	}
+	return level >= min
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.log(DEBUG, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.log(INFO, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.log(WARN, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...Field) {
+	l.log(ERROR, msg, fields)
+}
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now()
+
+	cfg.lock.RLock()
+	format := cfg.format
+	cfg.lock.RUnlock()
+
+	var line []byte
+	if format == FORMAT_TEXT {
+		line = l.formatText(now, level, msg, fields)
+	} else {
+		line = l.formatJson(now, level, msg, fields)
+	}
+
+	// whole line at once so concurrent lines do not interleave
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.out.Write(line)
+}
+
+func (l *Logger) formatJson(
+	now time.Time, level Level, msg string, fields []Field,
+) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(`{"time":`)
+	writeJson(buf, now.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJson(buf, level.ToString())
+	buf.WriteString(`,"subsystem":`)
+	writeJson(buf, l.subsystem)
+	buf.WriteString(`,"msg":`)
+	writeJson(buf, msg)
+	for _, f := range fields {
+		buf.WriteByte(',')
+		writeJson(buf, f.Key)
+		buf.WriteByte(':')
+		writeJson(buf, f.Value)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// value which can not be encoded is written as its string form
+func writeJson(buf *bytes.Buffer, v interface{}) {
+	enc, err := json.Marshal(v)
+	if err != nil {
+		enc, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(enc)
+}
+
+func (l *Logger) formatText(
+	now time.Time, level Level, msg string, fields []Field,
+) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(
+		buf, "%s %-5s [%s] %s",
+		now.Format("2006/01/02 15:04:05.000"),
+		strings.ToUpper(level.ToString()), l.subsystem, msg,
+	)
+	for _, f := range fields {
+		fmt.Fprintf(buf, " %s=%v", f.Key, f.Value)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
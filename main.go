@@ -8,6 +8,6 @@ import (
 func main() {
 	err := cli.Run()
 	if err != nil {
-		log.Panic(err)
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/transactions"
+)
+
+const MEMPOOL_JOURNAL_FILE = "%s_mempool.json"
+
+func JournalFileName(id string) string {
+	return fmt.Sprintf(MEMPOOL_JOURNAL_FILE, id)
+}
+
+// writes pending transactions so that restarted node keeps them,
+// file is replaced at once so crash never leaves half of it
+func (p *TxPool) SaveJournal(path string) error {
+	txs := p.GetAll()
+	enc, err := common.Encode(txs)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, enc, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	mempoolLog.Info("mempool journal is flushed", logger.F("txs", len(txs)), logger.F("file", path))
+	return nil
+}
+
+// missing journal means empty pool
+func LoadJournal(path string) ([]transactions.Transaction, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	txs, err := common.Decode[[]transactions.Transaction](raw)
+	if err != nil {
+		return nil, err
+	}
+	return *txs, nil
+}
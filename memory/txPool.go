@@ -1,19 +1,25 @@
 package memory
 
 import (
-	"log"
 	"simple-blockchain-go/blocks"
 	"simple-blockchain-go/common"
+	"simple-blockchain-go/geneis"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/transactions"
 	"sync"
+	"sync/atomic"
 
 	"github.com/btcsuite/btcutil/base58"
 	"golang.org/x/exp/maps"
 )
 
+var mempoolLog = logger.New(logger.MEMPOOL)
+
 type TxPool struct {
 	sync.Mutex
 	pool map[string]transactions.Transaction
+	// overwritten or dropped without being included
+	evictions atomic.Uint64
 }
 
 func NewTransactionPool() *TxPool {
@@ -31,6 +37,10 @@ func GetTxKeys(block *blocks.Block) []string {
 	return keys
 }
 
+func (p *TxPool) Evictions() uint64 {
+	return p.evictions.Load()
+}
+
 func (p *TxPool) Len() int {
 	return len(p.pool)
 }
@@ -51,7 +61,8 @@ func (p *TxPool) AppendOrOverwrite(tx *transactions.Transaction) {
 	key := base58.Encode(tx.Hash[:])
 	_, ok := p.pool[key]
 	if ok {
-		log.Printf("key is already exists, overwritten:\n%s\n", key)
+		mempoolLog.Debug("transaction is already pooled, overwritten", logger.F("tx", key))
+		p.evictions.Add(1)
 	}
 	p.pool[key] = *tx
 }
@@ -76,17 +87,81 @@ func (p *TxPool) Get(n int) []transactions.Transaction {
 	return bundle.Transactions[:n]
 }
 
-func (p *TxPool) GetTransactionForBlock() ([]transactions.Transaction, error) {
-	txLen := len(p.pool)
-	if txLen == 0 {
-		return nil, nil
+// tells whether transaction can follow picked ones in next block,
+// evict is true when it never can
+type AdmitFunc func(tx *transactions.Transaction) (ok bool, evict bool, err error)
+
+// picks transactions in nonce order while they fit in limits,
+// transactions paying less than min fee or not admitted are skipped,
+// ones which can not be measured or admitted ever are evicted,
+// result is cut to power of 2 for merkle tree
+func (p *TxPool) GetTransactionForBlock(
+	config *geneis.Config, admit AdmitFunc,
+) ([]transactions.Transaction, error) {
+	maxSize := config.MaxBlockSize - blocks.HEADER_SIZE_RESERVE
+	var picked []transactions.Transaction
+	var evicted []string
+	var weight uint64
+	var size int
+	for _, tx := range p.GetAll() {
+		key := base58.Encode(tx.Hash[:])
+		ok, w, s, err := measure(&tx, config)
+		if err != nil {
+			mempoolLog.Debug("transaction can not be measured, evicted", logger.F("tx", key), logger.Err(err))
+			evicted = append(evicted, key)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		// smaller one later might fit
+		if w > config.MaxBlockWeight-weight || size+s > maxSize {
+			continue
+		}
+
+		ok, evict, err := admit(&tx)
+		if err != nil {
+			return nil, err
+		}
+		if evict {
+			mempoolLog.Debug("transaction can never be included, evicted", logger.F("tx", key))
+			evicted = append(evicted, key)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		weight += w
+		size += s
+		picked = append(picked, tx)
 	}
-	if txLen == 1 {
-		return p.GetAll(), nil
+	if len(evicted) > 0 {
+		p.BatchRemove(evicted)
+		p.evictions.Add(uint64(len(evicted)))
 	}
 
-	len := common.LastPowerOf2(txLen)
-	return p.Get(len), nil
+	if len(picked) == 0 {
+		return nil, nil
+	}
+	return picked[:common.LastPowerOf2(len(picked))], nil
+}
+
+func measure(
+	tx *transactions.Transaction, config *geneis.Config,
+) (bool, uint64, int, error) {
+	ok, err := tx.PaysMinFee(config.MinFeePerWeight)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	w, err := tx.Weight()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	s, err := tx.Size()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	return ok, w, s, nil
 }
 
 func (p *TxPool) BatchRemove(keys []string) {
@@ -96,3 +171,13 @@ func (p *TxPool) BatchRemove(keys []string) {
 		delete(p.pool, k)
 	}
 }
+
+func (p *TxPool) GetByHash(hash []byte) (*transactions.Transaction, bool) {
+	p.Lock()
+	defer p.Unlock()
+	tx, ok := p.pool[base58.Encode(hash)]
+	if !ok {
+		return nil, false
+	}
+	return &tx, true
+}
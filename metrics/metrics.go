@@ -0,0 +1,363 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// every metric name starts with this
+	NAMESPACE = "sbg"
+	// seconds
+	READ_TIMEOUT  = 10
+	WRITE_TIMEOUT = 10
+)
+
+// seconds, for latencies of block processing
+var DefaultBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// something which writes itself in prometheus text format
+type collector interface {
+	write(w io.Writer)
+}
+
+// metrics are written in order of registration
+type Registry struct {
+	lock       sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.names[name] {
+		log.Panicf("metric %s is already registered", name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+func fullName(name string) string {
+	return NAMESPACE + "_" + name
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func escapeLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, n, escapeLabel(values[i]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (r *Registry) Write(w io.Writer) {
+	r.lock.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.lock.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	buf := new(bytes.Buffer)
+	r.Write(buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// server which serves /metrics at addr
+func (r *Registry) NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	return &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  time.Second * READ_TIMEOUT,
+		WriteTimeout: time.Second * WRITE_TIMEOUT,
+	}
+}
+
+// only goes up
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func (r *Registry) NewCounter(name string, help string) *Counter {
+	c := &Counter{name: fullName(name), help: help}
+	r.register(c.name, c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.value.Load())
+}
+
+// counters by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	values map[string]*atomic.Uint64
+	// label values by key of values
+	keys map[string][]string
+}
+
+func (r *Registry) NewCounterVec(
+	name string, help string, labels ...string,
+) *CounterVec {
+	c := &CounterVec{
+		name:   fullName(name),
+		help:   help,
+		labels: labels,
+		values: map[string]*atomic.Uint64{},
+		keys:   map[string][]string{},
+	}
+	r.register(c.name, c)
+	return c
+}
+
+// label values are in order of label names
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(n uint64, values ...string) {
+	if len(values) != len(c.labels) {
+		log.Panicf("metric %s needs %d labels", c.name, len(c.labels))
+	}
+	key := strings.Join(values, "\xff")
+	c.lock.Lock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &atomic.Uint64{}
+		c.values[key] = v
+		c.keys[key] = append([]string{}, values...)
+	}
+	c.lock.Unlock()
+	v.Add(n)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(
+			w, "%s%s %d\n",
+			c.name, formatLabels(c.labels, c.keys[k]), c.values[k].Load(),
+		)
+	}
+}
+
+// goes up and down
+type Gauge struct {
+	name string
+	help string
+	bits atomic.Uint64
+}
+
+func (r *Registry) NewGauge(name string, help string) *Gauge {
+	g := &Gauge{name: fullName(name), help: help}
+	r.register(g.name, g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.Value()))
+}
+
+// gauge which is read from node when scraped
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (r *Registry) NewGaugeFunc(
+	name string, help string, fn func() float64,
+) *GaugeFunc {
+	g := &GaugeFunc{name: fullName(name), help: help, fn: fn}
+	r.register(g.name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.fn()))
+}
+
+// counter which is read from node when scraped
+type CounterFunc struct {
+	name string
+	help string
+	fn   func() uint64
+}
+
+func (r *Registry) NewCounterFunc(
+	name string, help string, fn func() uint64,
+) *CounterFunc {
+	c := &CounterFunc{name: fullName(name), help: help, fn: fn}
+	r.register(c.name, c)
+	return c
+}
+
+func (c *CounterFunc) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.fn())
+}
+
+// gauges with one label which are read when scraped
+type GaugeVecFunc struct {
+	name  string
+	help  string
+	label string
+	fn    func() map[string]float64
+}
+
+func (r *Registry) NewGaugeVecFunc(
+	name string, help string, label string, fn func() map[string]float64,
+) *GaugeVecFunc {
+	g := &GaugeVecFunc{name: fullName(name), help: help, label: label, fn: fn}
+	r.register(g.name, g)
+	return g
+}
+
+func (g *GaugeVecFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(
+			w, "%s%s %s\n",
+			g.name, formatLabels([]string{g.label}, []string{k}), formatValue(values[k]),
+		)
+	}
+}
+
+// observations by upper bound
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	lock    sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// buckets are upper bounds in ascending order, +Inf is implicit
+func (r *Registry) NewHistogram(
+	name string, help string, buckets []float64,
+) *Histogram {
+	h := &Histogram{
+		name:    fullName(name),
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	r.register(h.name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// seconds since start
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
@@ -0,0 +1,123 @@
+package nodes
+
+import (
+	"errors"
+	"simple-blockchain-go/blockchain"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/transactions"
+	"simple-blockchain-go/vm"
+)
+
+func (e *ExecuterNode) executeContractDeploy(
+	raw []byte, sender []byte, nonce uint64,
+) error {
+	cmd, err := common.Decode[transactions.ContractDeploy](raw)
+	if err != nil {
+		return err
+	}
+	if len(cmd.Code) == 0 || len(cmd.Code) > transactions.MAX_CODE_SIZE {
+		return errors.New("invalid code size")
+	}
+	_, err = vm.ValidateCode(cmd.Code)
+	if err != nil {
+		return err
+	}
+
+	address, err := blockchain.ContractAddress(sender, nonce)
+	if err != nil {
+		return err
+	}
+	err = e.consumeNonce(sender, nonce)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Debug("deploying contract", logger.HashOf("address", address))
+	err = e.PutContract(&blockchain.Contract{
+		Address: address,
+		Creator: sender,
+		Code:    cmd.Code,
+	})
+	if err != nil {
+		return err
+	}
+	e.emit(blocks.EVENT_CONTRACT_DEPLOYED, sender, address)
+	// contract can hold balance
+	_, err = e.GetAccountStateSafe(address)
+	return err
+}
+
+// failure of contract fails the transaction,
+// writes of the call are discarded with overlay
+func (e *ExecuterNode) executeContractCall(
+	raw []byte, sender []byte, nonce uint64, header *blocks.BlockHeader,
+) error {
+	cmd, err := common.Decode[transactions.ContractCall](raw)
+	if err != nil {
+		return err
+	}
+	if len(cmd.Args) > transactions.MAX_CALL_ARGS {
+		return errors.New("too many call arguments")
+	}
+	contract, err := e.GetContract(cmd.Contract)
+	if err != nil {
+		return err
+	}
+	if contract == nil {
+		return errors.New("contract does not exist")
+	}
+
+	err = e.consumeNonce(sender, nonce)
+	if err != nil {
+		return err
+	}
+
+	overlay := vm.NewOverlay(e.Blockchain)
+	result, err := vm.Execute(
+		contract.Code,
+		&vm.Context{
+			Caller:    sender,
+			Contract:  contract.Address,
+			Height:    header.Height,
+			Timestamp: header.Timestamp,
+			Args:      cmd.Args,
+		},
+		overlay,
+		cmd.GasLimit,
+	)
+	if err != nil {
+		return err
+	}
+	if e.receipt != nil {
+		e.receipt.GasUsed = result.GasUsed
+	}
+	if result.Err != nil {
+		return &contractError{result.Err}
+	}
+	for _, data := range overlay.Events() {
+		e.emit(blocks.EVENT_CONTRACT_LOG, contract.Address, data)
+	}
+
+	stateLog.Debug(
+		"contract call succeeded",
+		logger.HashOf("address", contract.Address),
+		logger.F("gasUsed", result.GasUsed),
+		logger.F("returnSize", len(result.Return)),
+	)
+	return overlay.Commit(e.Blockchain)
+}
+
+// failure inside of contract code
+type contractError struct {
+	err error
+}
+
+func (ce *contractError) Error() string {
+	return "contract call failed: " + ce.err.Error()
+}
+
+func (ce *contractError) Unwrap() error {
+	return ce.err
+}
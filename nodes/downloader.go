@@ -3,10 +3,12 @@ package nodes
 import (
 	"bytes"
 	"errors"
-	"log"
+	"fmt"
 	"simple-blockchain-go/blocks"
 	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/p2p"
+	"time"
 )
 
 // impl simplest way
@@ -18,7 +20,7 @@ func (e *ExecuterNode) startDownloadBlocks(to p2p.NodeId) {
 		// we want next block, except genesis block
 		h++
 	}
-	log.Printf("start downloading blocks from %d...\n", h)
+	syncLog.Info("start downloading blocks", logger.Peer(to.Ip), logger.Height(h))
 	e.sendSyncBlockRequest(to, h)
 }
 
@@ -40,7 +42,7 @@ func (e *ExecuterNode) sendSyncBlockRequest(to p2p.NodeId, height uint64) error
 }
 
 func (e *ExecuterNode) handleSyncBlockRequest(raw []byte) error {
-	msg, err := common.Decode[p2p.SyncBlockRequestMsg](raw)
+	msg, err := decodeMsg[p2p.SyncBlockRequestMsg](raw)
 	if err != nil {
 		return err
 	}
@@ -48,14 +50,16 @@ func (e *ExecuterNode) handleSyncBlockRequest(raw []byte) error {
 	if msg.From.Kind != p2p.EXECUTER_NODE {
 		return nil
 	}
-	log.Printf(
-		"node's height: %d, requested height: %d \n",
-		e.Height, msg.Height,
+	syncLog.Debug(
+		"received sync block request",
+		logger.Peer(msg.From.Ip),
+		logger.Height(e.Height),
+		logger.F("requested", msg.Height),
 	)
 	if msg.Height > e.Height {
 		// here means there might be higher(longer) forks
 		// or just be spam
-		log.Println("requested higher blocks, skipping...")
+		syncLog.Debug("requested higher block, skipping", logger.Peer(msg.From.Ip))
 		return nil
 	}
 
@@ -86,7 +90,7 @@ func (e *ExecuterNode) handleSyncBlockResponse(raw []byte) error {
 	e.Lock()
 	defer e.Unlock()
 
-	msg, err := common.Decode[p2p.SyncBlockResponseMsg](raw)
+	msg, err := decodeMsg[p2p.SyncBlockResponseMsg](raw)
 	if err != nil {
 		return err
 	}
@@ -94,23 +98,39 @@ func (e *ExecuterNode) handleSyncBlockResponse(raw []byte) error {
 	if msg.From.Kind != p2p.EXECUTER_NODE {
 		return nil
 	}
-	log.Printf(
-		"node's height: %d, received height: %d \n",
-		e.Height, msg.Block.Height,
+	// state of pending template is not of the chain being synced
+	_, err = e.abandonWorkTemplate()
+	if err != nil {
+		return err
+	}
+	syncLog.Debug(
+		"received sync block",
+		logger.Peer(msg.From.Ip),
+		logger.Height(e.Height),
+		logger.F("received", msg.Block.Height),
 	)
 
 	if msg.Block.Height == 0 {
-		err = e.OverwriteGenesis(msg.Block)
+		ok, err := e.OverwriteGenesis(msg.Block)
 		if err != nil {
 			return err
 		}
+		if !ok {
+			syncLog.Warn("received genesis is refused, stop syncing", logger.Peer(msg.From.Ip))
+			e.isSyncing = false
+			return nil
+		}
 	} else {
 		if e.Height+1 != msg.Block.Height {
-			log.Println("received unexpected block, skipping...")
+			syncLog.Debug("received unexpected block, skipping", logger.Peer(msg.From.Ip), logger.Height(msg.Block.Height))
 			return nil
 		}
 
 		err = e.syncBlockImpl(&msg.Block)
+		if err != nil && classify(err) == PEER_FAULT {
+			syncLog.Warn("received block is refused, stop syncing", logger.Peer(msg.From.Ip))
+			e.isSyncing = false
+		}
 		if err != nil {
 			return err
 		}
@@ -118,34 +138,59 @@ func (e *ExecuterNode) handleSyncBlockResponse(raw []byte) error {
 
 	if msg.IsLatest {
 		e.isSyncing = false
-		log.Println("syncing is done...")
+		syncLog.Info("syncing is done", logger.Height(e.Height))
 		return nil
 	}
 
 	return e.sendSyncBlockRequest(msg.From, e.Height+1)
 }
 
-func (e *ExecuterNode) syncBlockImpl(block *blocks.Block) error {
+// block is executed in block transaction and stored only when
+// both roots match, so that invalid block leaves nothing behind,
+// caller holds chain lock
+func (e *ExecuterNode) syncBlockImpl(block *blocks.Block) (err error) {
 	// verify
 	ok, err := e.VerifyBlock(block)
 	if err != nil {
 		return err
 	}
 	if !ok {
-		log.Println("received block is invalid")
-		return nil
+		return peerFault(fmt.Errorf("%w: height %d", ErrInvalidBlock, block.Height))
 	}
 
-	// execute
-	for _, tx := range block.Bundle.Transactions {
-		err = e.executeTransaction(tx)
-		if err != nil {
-			return err
+	processingStart := time.Now()
+	err = e.BeginBlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
 		}
+		rollbackErr := e.RollbackBlock()
+		if rollbackErr != nil {
+			err = rollbackErr
+		}
+	}()
+
+	// execute
+	receipts, err := e.executeBlock(block)
+	if errors.Is(err, ErrInvalidTransaction) {
+		return peerFault(fmt.Errorf("%w: height %d: %v", ErrInvalidBlock, block.Height, err))
+	}
+	if err != nil {
+		return err
+	}
+	receiptsHash, err := blocks.HashReceipts(receipts)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(receiptsHash, block.ReceiptsRoot) {
+		return peerFault(fmt.Errorf("%w: receipts root of height %d", ErrInvalidBlock, block.Height))
 	}
 
-	// put to db
-	err = e.PutBlockWithCheck(block)
+	// rewards
+	err = e.Engine.Finalize(e.Blockchain, e.Blockchain, block)
 	if err != nil {
 		return err
 	}
@@ -155,10 +200,14 @@ func (e *ExecuterNode) syncBlockImpl(block *blocks.Block) error {
 	if err != nil {
 		return err
 	}
-	if !bytes.Equal(stateHash, block.StateHash) {
-		// there are no way to restore database for now
-		return errors.New("state hash does not match")
+	if !bytes.Equal(stateHash, block.StateRoot) {
+		return peerFault(fmt.Errorf("%w: state root of height %d", ErrInvalidBlock, block.Height))
 	}
 
+	err = e.commitBlock(block, receipts, BLOCK_SOURCE_SYNC)
+	if err != nil {
+		return err
+	}
+	e.stats.observeProcessing(processingStart)
 	return nil
 }
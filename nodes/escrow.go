@@ -0,0 +1,188 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/blockchain"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/transactions"
+)
+
+func (e *ExecuterNode) executeEscrow(
+	cmdKind transactions.CommandKind,
+	raw []byte, sender []byte, nonce uint64,
+) error {
+	stateLog.Debug("executing", logger.F("command", cmdKind.ToString()))
+	switch cmdKind {
+	case transactions.LOCKED_TRANSFER_CMD:
+		cmd, err := common.Decode[transactions.LockedTransfer](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeLockedTransfer(cmd, sender, nonce)
+	case transactions.ESCROW_CLAIM_CMD:
+		cmd, err := common.Decode[transactions.EscrowClaim](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeEscrowClaim(cmd.EscrowId, sender, nonce)
+	case transactions.ESCROW_REFUND_CMD:
+		cmd, err := common.Decode[transactions.EscrowClaim](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeEscrowRefund(cmd.EscrowId, sender, nonce)
+	case transactions.ESCROW_RELEASE_CMD:
+		cmd, err := common.Decode[transactions.EscrowRelease](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeEscrowRelease(cmd, sender, nonce)
+	default:
+		return errors.New("not an escrow command")
+	}
+}
+
+func (e *ExecuterNode) executeLockedTransfer(
+	cmd *transactions.LockedTransfer, sender []byte, nonce uint64,
+) error {
+	if !accounts.IsAccountKey(cmd.To) {
+		return ErrInvalidAccountKey
+	}
+	if len(cmd.RefundKey) > 0 && !accounts.IsAccountKey(cmd.RefundKey) {
+		return ErrInvalidAccountKey
+	}
+	if bytes.Equal(sender, cmd.To) {
+		return errors.New("invalid public keys")
+	}
+	// executing block is always next of head
+	height := e.Height + 1
+	if cmd.UnlockHeight <= height {
+		return errors.New("unlock height is already reached")
+	}
+	if len(cmd.RefundKey) > 0 &&
+		(cmd.RefundHeight <= height || cmd.RefundHeight >= cmd.UnlockHeight) {
+		return errors.New("refund height is out of range")
+	}
+
+	id, err := blockchain.EscrowId(sender, nonce)
+	if err != nil {
+		return err
+	}
+	err = e.debitImpl(sender, nonce, sender, cmd.Amount)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Debug(
+		"locking in escrow",
+		logger.HashOf("escrow", id),
+		logger.F("amount", cmd.Amount),
+		logger.F("unlockHeight", cmd.UnlockHeight),
+	)
+	e.emit(blocks.EVENT_ESCROW_CREATED, sender, id)
+	return e.PutEscrow(&blockchain.Escrow{
+		Id:           id,
+		From:         sender,
+		To:           cmd.To,
+		Amount:       cmd.Amount,
+		UnlockHeight: cmd.UnlockHeight,
+		RefundKey:    cmd.RefundKey,
+		RefundHeight: cmd.RefundHeight,
+	})
+}
+
+func (e *ExecuterNode) executeEscrowClaim(
+	id []byte, sender []byte, nonce uint64,
+) error {
+	escrow, err := e.getEscrowSafe(id)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sender, escrow.To) {
+		return errors.New("only recipient can claim escrow")
+	}
+	if e.Height+1 < escrow.UnlockHeight {
+		return errors.New("escrow is still locked")
+	}
+	return e.closeEscrow(escrow, sender, nonce, escrow.To)
+}
+
+func (e *ExecuterNode) executeEscrowRefund(
+	id []byte, sender []byte, nonce uint64,
+) error {
+	escrow, err := e.getEscrowSafe(id)
+	if err != nil {
+		return err
+	}
+	if len(escrow.RefundKey) == 0 || !bytes.Equal(sender, escrow.RefundKey) {
+		return errors.New("only refund key can refund escrow")
+	}
+	height := e.Height + 1
+	if height < escrow.RefundHeight || height >= escrow.UnlockHeight {
+		return errors.New("escrow is not refundable at this height")
+	}
+	return e.closeEscrow(escrow, sender, nonce, escrow.RefundKey)
+}
+
+// both parties agree, so height does not matter
+func (e *ExecuterNode) executeEscrowRelease(
+	cmd *transactions.EscrowRelease, sender []byte, nonce uint64,
+) error {
+	escrow, err := e.getEscrowSafe(cmd.EscrowId)
+	if err != nil {
+		return err
+	}
+
+	var counterparty []byte
+	if bytes.Equal(sender, escrow.From) {
+		counterparty = escrow.To
+	} else if bytes.Equal(sender, escrow.To) {
+		counterparty = escrow.From
+	} else {
+		return errors.New("only parties can release escrow")
+	}
+	if !ed25519.Verify(
+		counterparty,
+		transactions.EscrowReleaseMessage(escrow.Id),
+		cmd.CounterSignature,
+	) {
+		return errors.New("counter signature is invalid")
+	}
+	return e.closeEscrow(escrow, sender, nonce, escrow.To)
+}
+
+func (e *ExecuterNode) getEscrowSafe(id []byte) (*blockchain.Escrow, error) {
+	escrow, err := e.GetEscrow(id)
+	if err != nil {
+		return nil, err
+	}
+	if escrow == nil {
+		return nil, errors.New("escrow does not exist")
+	}
+	return escrow, nil
+}
+
+// pays escrowed amount and removes escrow,
+// sender's nonce is consumed here
+func (e *ExecuterNode) closeEscrow(
+	escrow *blockchain.Escrow, sender []byte, nonce uint64, to []byte,
+) error {
+	if !bytes.Equal(sender, to) {
+		err := e.consumeNonce(sender, nonce)
+		if err != nil {
+			return err
+		}
+	}
+	err := e.creditImpl(sender, nonce, to, escrow.Amount)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Debug("paying from escrow", logger.HashOf("escrow", escrow.Id), logger.F("amount", escrow.Amount))
+	return e.DeleteEscrow(escrow.Id)
+}
@@ -3,91 +3,258 @@ package nodes
 import (
 	"bytes"
 	"errors"
-	"log"
+	"fmt"
+	"simple-blockchain-go/accounts"
 	"simple-blockchain-go/blocks"
 	"simple-blockchain-go/common"
+	"simple-blockchain-go/consensus"
 	"simple-blockchain-go/geneis"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/memory"
 	"simple-blockchain-go/merkleTree"
+	"simple-blockchain-go/poa"
+	"simple-blockchain-go/pos"
 	"simple-blockchain-go/transactions"
+	"simple-blockchain-go/vm"
 	"time"
 )
 
+var (
+	ErrUnexpectedNonce = errors.New("nonce is not expected")
+	ErrFeeNotPaid      = errors.New("fee is not paid")
+	// transaction can not be in any block, not even as failed one
+	ErrInvalidTransaction = errors.New("transaction is invalid")
+	// invalid for now, but might be created by later transaction
+	errMultisigNotCreated = fmt.Errorf(
+		"%w: multisig account is not created", ErrInvalidTransaction,
+	)
+	// account keys share state with nothing else, but other
+	// sizes are never valid accounts
+	ErrInvalidAccountKey = errors.New("account key is invalid")
+)
+
 func (e *ExecuterNode) retry() {
 	time.AfterFunc(time.Millisecond*10000, func() {
-		e.epoch.C() <- true
+		e.epoch.Trigger()
 	})
 }
 
 func (e *ExecuterNode) executionRoutine() {
-	log.Printf("epoch %d: next: %d\n", e.Height, e.Height+1)
-	if e.isSyncing {
+	defer e.recoverPanic("execution routine")
+	err := e.produceBlock()
+	if err != nil && e.ctx.Err() != nil {
+		// block was abandoned by shutdown
 		return
 	}
+	if err != nil {
+		e.handleLocalFault("block production failed", err)
+		if e.fatalError() == nil &&
+			(isRendezvous(e.id) || !e.Engine.IsRemoteSealing()) {
+			e.retry()
+		}
+	}
+}
+
+// executes transactions of pool and seals or offers next block
+func (e *ExecuterNode) produceBlock() error {
+	stateLog.Debug("epoch", logger.Height(e.Height), logger.F("next", e.Height+1))
+	if e.isSyncing {
+		return nil
+	}
+	if _, t := e.currentWorkTemplate(); t != nil {
+		// pending template is solved or expires first
+		return nil
+	}
 
 	err := e.checkHealth()
 	if err != nil {
-		log.Panic(err)
+		// cached chain does not match storage
+		return fatalFault(err)
 	}
 
 	if e.txPool.Len() == 0 {
-		log.Println("no transactions to execute")
-		if isRendezvous(e.id) {
+		stateLog.Debug("no transactions to execute")
+		if isRendezvous(e.id) || !e.Engine.IsRemoteSealing() {
 			e.retry()
 		}
-		return
+		return nil
 	}
 
-	// chose transactions for block
-	txsForExecute, err := e.txPool.GetTransactionForBlock()
+	ok, err := e.Engine.CanSeal(e.Blockchain)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-
-	// execute transaction and get other tx on fail
-	var errorLen int
-	var executedTxs []transactions.Transaction
-	for {
-		for _, tx := range txsForExecute {
-			err := e.executeTransaction(tx)
-			if err != nil {
-				log.Panic(err)
-			}
-			executedTxs = append(executedTxs, tx)
+	if !ok {
+		stateLog.Debug("this node can not produce next block")
+		if isRendezvous(e.id) || !e.Engine.IsRemoteSealing() {
+			e.retry()
 		}
-		if errorLen == 0 {
-			break
+		return nil
+	}
+
+	// chose transactions for block
+	txsForExecute, err := e.txPool.GetTransactionForBlock(
+		e.Config, e.admitter(),
+	)
+	if err != nil {
+		return err
+	}
+	if len(txsForExecute) == 0 {
+		stateLog.Debug("no transactions can be included")
+		if isRendezvous(e.id) || !e.Engine.IsRemoteSealing() {
+			e.retry()
 		}
-		txsForExecute = e.txPool.Get(errorLen)
+		return nil
 	}
 
-	block := blocks.NewBlock(
-		transactions.TxBundle{Transactions: executedTxs},
+	block, err := blocks.NewBlock(
+		transactions.TxBundle{Transactions: txsForExecute},
 		e.BlockInfo,
 	)
+	if err != nil {
+		return err
+	}
 	// increment because this is next block
 	block.Height++
 
-	// delete tx pool
-	txKeys := memory.GetTxKeys(block)
-	e.txPool.BatchRemove(txKeys)
+	// local clock can be behind of recent blocks
+	minTimestamp, err := e.MinNextTimestamp()
+	if err != nil {
+		return err
+	}
+	if block.Timestamp < minTimestamp {
+		block.Timestamp = minTimestamp
+	}
+
+	// prepare before execution not to touch state for nothing
+	err = e.Engine.Prepare(e.Blockchain, &block.BlockHeader)
+	if errors.Is(err, consensus.ErrNotSealer) {
+		stateLog.Debug("this node is not sealer of next block", logger.Height(block.Height))
+		e.retry()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	processingStart := time.Now()
+	err = e.applyBlock(block)
+	if err != nil {
+		return err
+	}
+	e.stats.observeProcessing(processingStart)
+	return nil
+}
+
+// executes block, pays rewards and sets roots in block transaction,
+// block of remote sealing stays pending as work template until it is solved,
+// others are sealed and stored at once
+func (e *ExecuterNode) applyBlock(block *blocks.Block) (err error) {
+	e.Lock()
+	defer e.Unlock()
+
+	err = e.BeginBlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		rollbackErr := e.RollbackBlock()
+		if rollbackErr != nil {
+			err = rollbackErr
+		}
+	}()
+
+	receipts, err := e.executeBlock(block)
+	if err != nil {
+		return err
+	}
+	block.ReceiptsRoot, err = blocks.HashReceipts(receipts)
+	if err != nil {
+		return err
+	}
+
+	// rewards
+	err = e.Engine.Finalize(e.Blockchain, e.Blockchain, block)
+	if err != nil {
+		return err
+	}
 
 	// calc state hash
-	stateHash, err := e.calcState()
+	block.StateRoot, err = e.calcState()
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	block.StateHash = stateHash
 
-	log.Printf(
-		"block at height %d is created, broadcasting offer...\n",
-		block.Height,
+	remote := e.Engine.IsRemoteSealing()
+	stateLog.Info(
+		"block is created",
+		logger.Height(block.Height),
+		logger.F("txs", len(block.Bundle.Transactions)),
+		logger.F("remoteSealing", remote),
 	)
-	log.Printf("including %d tx\n", len(block.Bundle.Transactions))
-	err = e.broadcastOfferBlock(block)
+	if !remote {
+		return e.sealBlock(block, receipts)
+	}
+
+	// transactions come back when template is abandoned
+	e.txPool.BatchRemove(memory.GetTxKeys(block))
+	e.addWorkTemplate(block, receipts)
+	return nil
+}
+
+// seals block on this node and stores it, caller holds chain lock
+func (e *ExecuterNode) sealBlock(
+	block *blocks.Block, receipts []blocks.Receipt,
+) error {
+	err := e.Engine.Seal(e.ctx, block)
+	if err != nil {
+		return err
+	}
+
+	ok, err := e.VerifyBlock(block)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("sealed block is invalid")
+	}
+	err = e.commitBlock(block, receipts, BLOCK_SOURCE_LOCAL)
+	if err != nil {
+		return err
+	}
+	e.txPool.BatchRemove(memory.GetTxKeys(block))
+
+	// this runs in epoch routine
+	go func() {
+		e.epoch.Trigger()
+	}()
+
+	return e.broadcastAcceptedBlock(block)
+}
+
+// stores block and receipts with state of pending block,
+// caller holds chain lock
+func (e *ExecuterNode) commitBlock(
+	block *blocks.Block, receipts []blocks.Receipt, source string,
+) error {
+	err := e.PutBlockWithCheck(block)
+	if err != nil {
+		return err
+	}
+	err = e.PutReceipts(block, receipts)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	err = e.CommitBlock()
+	if err != nil {
+		return err
 	}
+	e.stats.countBlock(source)
+	e.publishBlock(block, receipts)
+	return nil
 }
 
 // this is very shortcut(rough or actually crazy) implementation...
@@ -108,36 +275,289 @@ func (e *ExecuterNode) calcState() ([]byte, error) {
 	return mTree.RootNode.Data, nil
 }
 
-func (e *ExecuterNode) executeTransaction(tx transactions.Transaction) error {
-	// check again
+// executes transactions of block in order,
+// state root and receipts root are left to caller
+func (e *ExecuterNode) executeBlock(
+	block *blocks.Block,
+) ([]blocks.Receipt, error) {
+	receipts := make([]blocks.Receipt, 0, len(block.Bundle.Transactions))
+	for _, tx := range block.Bundle.Transactions {
+		receipt, err := e.executeWithReceipt(tx, &block.BlockHeader)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, *receipt)
+	}
+	return receipts, nil
+}
+
+// failed transaction is rolled back,
+// then only nonce and fee are applied so that it can not be replayed for free,
+// transaction which can not be admitted makes the block invalid,
+// other errors are internal failures
+func (e *ExecuterNode) executeWithReceipt(
+	tx transactions.Transaction, header *blocks.BlockHeader,
+) (*blocks.Receipt, error) {
+	err := e.admit(&tx)
+	if err != nil {
+		return nil, err
+	}
+
+	e.receipt = &blocks.Receipt{
+		TxHash: tx.Hash,
+		Status: blocks.RECEIPT_SUCCESS,
+		Fee:    tx.InnerData.Fee,
+	}
+	receipt := e.receipt
+	defer func() {
+		e.receipt = nil
+	}()
+
+	e.BeginJournal()
+	defer e.EndJournal()
+
+	err = e.executeTransaction(tx, header)
+	if err != nil {
+		stateLog.Info("transaction failed", logger.Hash(tx.Hash[:]), logger.Err(err))
+		receipt.Status = blocks.RECEIPT_FAILED
+		receipt.ErrorCode = errorCodeOf(err)
+		receipt.Error = err.Error()
+		receipt.Fee = 0
+		receipt.Events = nil
+
+		err = e.RevertJournal()
+		if err != nil {
+			return nil, err
+		}
+		// nonce is admitted, so only fee can fail here
+		err = e.consumeNonce(tx.InnerData.PublicKey, tx.InnerData.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		if e.chargeFee(&tx, header) == nil {
+			receipt.Fee = tx.InnerData.Fee
+		}
+	}
+
+	receipt.BalanceChanges, err = e.JournalBalanceChanges()
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+func errorCodeOf(err error) byte {
+	var contractErr *contractError
+	switch {
+	case errors.Is(err, ErrUnexpectedNonce):
+		return blocks.ERR_CODE_NONCE
+	case errors.Is(err, ErrFeeNotPaid):
+		return blocks.ERR_CODE_FEE
+	case errors.Is(err, vm.ErrOutOfGas):
+		return blocks.ERR_CODE_OUT_OF_GAS
+	case errors.As(err, &contractErr):
+		return blocks.ERR_CODE_CONTRACT
+	default:
+		return blocks.ERR_CODE_EXECUTION
+	}
+}
+
+// adds event to receipt of executing transaction
+func (e *ExecuterNode) emit(name string, source []byte, data []byte) {
+	if e.receipt == nil {
+		return
+	}
+	e.receipt.Events = append(e.receipt.Events, blocks.Event{
+		Name:   name,
+		Source: source,
+		Data:   data,
+	})
+}
+
+// signature and multisig account
+func (e *ExecuterNode) authorize(tx *transactions.Transaction) error {
 	ok, err := tx.Verify()
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
 	}
 	if !ok {
-		return errors.New("invalid transaction")
+		return fmt.Errorf("%w: signature does not match", ErrInvalidTransaction)
+	}
+	if tx.IsMultisig() {
+		spec, err := e.GetMultisig(tx.InnerData.PublicKey)
+		if err != nil {
+			return err
+		}
+		if spec == nil {
+			return errMultisigNotCreated
+		}
+	}
+	return nil
+}
+
+// nonce of sender must be the next one
+func (e *ExecuterNode) admit(tx *transactions.Transaction) error {
+	err := e.authorize(tx)
+	if err != nil {
+		return err
+	}
+	next, err := e.nextNonce(tx.InnerData.PublicKey)
+	if err != nil {
+		return err
+	}
+	if tx.InnerData.Nonce != next {
+		return fmt.Errorf(
+			"%w: nonce %d, expected %d",
+			ErrInvalidTransaction, tx.InnerData.Nonce, next,
+		)
+	}
+	return nil
+}
+
+func (e *ExecuterNode) nextNonce(pubKey []byte) (uint64, error) {
+	state, err := e.GetAccountState(pubKey)
+	if err != nil || state == nil {
+		return 0, err
+	}
+	return state.Nonce, nil
+}
+
+// picks transactions which can follow already picked ones in next block,
+// every picked one uses next nonce of its sender
+func (e *ExecuterNode) admitter() memory.AdmitFunc {
+	nonces := map[string]uint64{}
+	return func(tx *transactions.Transaction) (bool, bool, error) {
+		err := e.authorize(tx)
+		if errors.Is(err, errMultisigNotCreated) {
+			return false, false, nil
+		}
+		if errors.Is(err, ErrInvalidTransaction) {
+			return false, true, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+
+		sender := string(tx.InnerData.PublicKey)
+		next, ok := nonces[sender]
+		if !ok {
+			next, err = e.nextNonce(tx.InnerData.PublicKey)
+			if err != nil {
+				return false, false, err
+			}
+		}
+		if tx.InnerData.Nonce < next {
+			// already used
+			return false, true, nil
+		}
+		if tx.InnerData.Nonce > next {
+			// waits for earlier one
+			return false, false, nil
+		}
+		nonces[sender] = next + 1
+		return true, false, nil
+	}
+}
+
+// header is of the block being executed,
+// transaction is already authorized
+func (e *ExecuterNode) executeTransaction(
+	tx transactions.Transaction, header *blocks.BlockHeader,
+) error {
+	err := e.chargeFee(&tx, header)
+	if err != nil {
+		return err
 	}
 
 	raw := tx.InnerData.Data
 	cmdKind := transactions.CommandKind(raw[0])
 	switch cmdKind {
 	case transactions.AIRDROP_CMD:
-		err = e.executeAirdrop(raw[1:], tx.InnerData.Nonce)
+		err = e.executeAirdrop(
+			raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
 	case transactions.TRANSFER_CMD:
-		err = e.executeTransfer(raw[1:], tx.InnerData.Nonce)
+		err = e.executeTransfer(
+			raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
+	case transactions.BATCH_TRANSFER_CMD:
+		err = e.executeBatchTransfer(
+			raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
+	case transactions.CREATE_MULTISIG_CMD:
+		err = e.executeCreateMultisig(
+			raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
+	case transactions.LOCKED_TRANSFER_CMD,
+		transactions.ESCROW_CLAIM_CMD,
+		transactions.ESCROW_REFUND_CMD,
+		transactions.ESCROW_RELEASE_CMD:
+		err = e.executeEscrow(
+			cmdKind, raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
+	case transactions.TOKEN_CREATE_CMD,
+		transactions.TOKEN_MINT_CMD,
+		transactions.TOKEN_TRANSFER_CMD,
+		transactions.TOKEN_BURN_CMD:
+		err = e.executeToken(
+			cmdKind, raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
+	case transactions.CONTRACT_DEPLOY_CMD:
+		err = e.executeContractDeploy(
+			raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
+	case transactions.CONTRACT_CALL_CMD:
+		err = e.executeContractCall(
+			raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce, header,
+		)
+	case transactions.VOTE_SIGNER_CMD:
+		err = e.executeVoteSigner(
+			raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
+	case transactions.STAKE_CMD,
+		transactions.UNSTAKE_CMD,
+		transactions.DELEGATE_CMD,
+		transactions.DOUBLE_SIGN_EVIDENCE_CMD:
+		err = e.executeStaking(
+			cmdKind, raw[1:], tx.InnerData.PublicKey, tx.InnerData.Nonce,
+		)
 	default:
-		log.Println("unknown command skipping...")
+		err = errors.New("unknown command")
 	}
 	return err
 }
 
-func (e *ExecuterNode) executeAirdrop(raw []byte, nonce uint64) error {
+// fee goes to producer, or is burned for blocks by miners
+func (e *ExecuterNode) chargeFee(
+	tx *transactions.Transaction, header *blocks.BlockHeader,
+) error {
+	fee := tx.InnerData.Fee
+	if fee == 0 {
+		return nil
+	}
+	err := e.debitImpl(nil, 0, tx.InnerData.PublicKey, fee)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFeeNotPaid, err)
+	}
+	if len(header.Coinbase) == 0 {
+		return nil
+	}
+	return e.creditImpl(nil, 0, header.Coinbase, fee)
+}
+
+func (e *ExecuterNode) executeAirdrop(
+	raw []byte, sender []byte, nonce uint64,
+) error {
 	cmd, err := common.Decode[transactions.Airdrop](raw)
 	if err != nil {
 		return err
 	}
+	// nonce of receiver is used, so it must be the signer
+	if !bytes.Equal(sender, cmd.PublicKey) {
+		return errors.New("airdrop is not signed by receiver")
+	}
 
-	log.Printf("airdropping %d...\n", cmd.Amount)
+	stateLog.Debug("airdropping", logger.F("amount", cmd.Amount))
 	if e.airdropAccount == nil {
 		generator, err := geneis.GetGenerator()
 		if err != nil {
@@ -147,7 +567,7 @@ func (e *ExecuterNode) executeAirdrop(raw []byte, nonce uint64) error {
 		if err != nil {
 			return err
 		}
-		log.Printf("genesis balance: %d\n", genesisState.Balance)
+		stateLog.Debug("genesis balance", logger.F("balance", genesisState.Balance))
 
 		e.airdropAccount = generator
 	}
@@ -158,19 +578,215 @@ func (e *ExecuterNode) executeAirdrop(raw []byte, nonce uint64) error {
 	)
 }
 
-func (e *ExecuterNode) executeTransfer(raw []byte, nonce uint64) error {
+func (e *ExecuterNode) executeTransfer(
+	raw []byte, sender []byte, nonce uint64,
+) error {
 	cmd, err := common.Decode[transactions.Transfer](raw)
 	if err != nil {
 		return err
 	}
+	// only From itself, or enough of its multisig keys, can spend
+	if !bytes.Equal(sender, cmd.From) {
+		return errors.New("transfer is not signed by sender")
+	}
 
-	log.Printf("transfering %d...\n", cmd.Amount)
+	stateLog.Debug("transfering", logger.F("amount", cmd.Amount))
 	return e.transferImpl(
 		cmd.From, nonce,
 		cmd.From, cmd.To, cmd.Amount,
 	)
 }
 
+// every state is checked in memory first,
+// then written at once so that batch is paid all or nothing
+func (e *ExecuterNode) executeBatchTransfer(
+	raw []byte, sender []byte, nonce uint64,
+) error {
+	cmd, err := common.Decode[transactions.BatchTransfer](raw)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sender, cmd.From) {
+		return errors.New("batch is not signed by sender")
+	}
+	l := len(cmd.Outputs)
+	if l == 0 || l > transactions.MAX_BATCH_OUTPUTS {
+		return errors.New("invalid number of outputs")
+	}
+
+	stateLog.Debug("batch transfering", logger.F("outputs", l))
+	fromState, err := e.GetAccountState(cmd.From)
+	if err != nil {
+		return err
+	}
+	if fromState == nil {
+		return errors.New("sender account does not exist")
+	}
+	if !fromState.CheckNonce(nonce) {
+		return ErrUnexpectedNonce
+	}
+
+	keys := [][]byte{cmd.From}
+	states := []*accounts.AccountState{fromState}
+	// the same recipient can appear more than once
+	index := map[string]int{}
+	for _, out := range cmd.Outputs {
+		if !accounts.IsAccountKey(out.To) {
+			return ErrInvalidAccountKey
+		}
+		if bytes.Equal(cmd.From, out.To) {
+			return errors.New("invalid public keys")
+		}
+		if !fromState.Subtract(out.Amount) {
+			return errors.New("underflow")
+		}
+
+		i, ok := index[string(out.To)]
+		if !ok {
+			toState, err := e.GetAccountState(out.To)
+			if err != nil {
+				return err
+			}
+			if toState == nil {
+				toState = &accounts.AccountState{}
+			}
+			i = len(states)
+			index[string(out.To)] = i
+			keys = append(keys, out.To)
+			states = append(states, toState)
+		}
+		if !states[i].Add(out.Amount) {
+			return errors.New("overflow")
+		}
+	}
+	return e.PutAccountStates(keys, states)
+}
+
+func (e *ExecuterNode) executeCreateMultisig(
+	raw []byte, sender []byte, nonce uint64,
+) error {
+	cmd, err := common.Decode[transactions.CreateMultisig](raw)
+	if err != nil {
+		return err
+	}
+	err = cmd.Spec.Check()
+	if err != nil {
+		return err
+	}
+
+	address := cmd.Spec.Address()
+	existing, err := e.GetMultisig(address)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("multisig account already exists")
+	}
+
+	err = e.consumeNonce(sender, nonce)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Debug(
+		"creating multisig account",
+		logger.HashOf("address", address),
+		logger.F("threshold", cmd.Spec.Threshold),
+		logger.F("keys", len(cmd.Spec.PublicKeys)),
+	)
+	err = e.PutMultisig(&cmd.Spec)
+	if err != nil {
+		return err
+	}
+	e.emit(blocks.EVENT_MULTISIG_CREATED, sender, address)
+	// account itself is ordinary one
+	_, err = e.GetAccountStateSafe(address)
+	return err
+}
+
+func (e *ExecuterNode) executeVoteSigner(
+	raw []byte, voter []byte, nonce uint64,
+) error {
+	engine, ok := e.Engine.(*poa.Engine)
+	if !ok {
+		return errors.New("signer vote is only for poa")
+	}
+	cmd, err := common.Decode[transactions.VoteSigner](raw)
+	if err != nil {
+		return err
+	}
+
+	err = e.consumeNonce(voter, nonce)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Debug("voting signer", logger.F("authorize", cmd.Authorize))
+	return engine.Vote(
+		e.Blockchain, e.Blockchain,
+		voter, cmd.Candidate, cmd.Authorize,
+	)
+}
+
+// for commands which do not transfer
+func (e *ExecuterNode) consumeNonce(pubKey []byte, nonce uint64) error {
+	state, err := e.GetAccountStateSafe(pubKey)
+	if err != nil {
+		return err
+	}
+	if !state.CheckNonce(nonce) {
+		return ErrUnexpectedNonce
+	}
+	return e.PutAccountState(pubKey, state)
+}
+
+func (e *ExecuterNode) executeStaking(
+	cmdKind transactions.CommandKind,
+	raw []byte, sender []byte, nonce uint64,
+) error {
+	engine, ok := e.Engine.(*pos.Engine)
+	if !ok {
+		return errors.New("staking is only for pos")
+	}
+	err := e.consumeNonce(sender, nonce)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Debug("executing", logger.F("command", cmdKind.ToString()))
+	switch cmdKind {
+	case transactions.STAKE_CMD:
+		cmd, err := common.Decode[transactions.Stake](raw)
+		if err != nil {
+			return err
+		}
+		return engine.Stake(e.Blockchain, sender, cmd.Amount)
+	case transactions.UNSTAKE_CMD:
+		cmd, err := common.Decode[transactions.Unstake](raw)
+		if err != nil {
+			return err
+		}
+		// executing block is always next of head
+		return engine.Unstake(
+			e.Blockchain, sender, cmd.Validator, cmd.Amount, e.Height+1,
+		)
+	case transactions.DELEGATE_CMD:
+		cmd, err := common.Decode[transactions.Delegate](raw)
+		if err != nil {
+			return err
+		}
+		return engine.Delegate(e.Blockchain, sender, cmd.Validator, cmd.Amount)
+	case transactions.DOUBLE_SIGN_EVIDENCE_CMD:
+		cmd, err := common.Decode[transactions.DoubleSignEvidence](raw)
+		if err != nil {
+			return err
+		}
+		return engine.Slash(e.Blockchain, cmd.HeaderA, cmd.HeaderB)
+	default:
+		return errors.New("not a staking command")
+	}
+}
+
 func (e *ExecuterNode) transferImpl(
 	caller []byte, nonce uint64,
 	from []byte, to []byte, amount uint64,
@@ -179,36 +795,56 @@ func (e *ExecuterNode) transferImpl(
 		return errors.New("invalid public keys")
 	}
 
-	// decrease from's balance
+	err := e.debitImpl(caller, nonce, from, amount)
+	if err != nil {
+		return err
+	}
+	return e.creditImpl(caller, nonce, to, amount)
+}
+
+// decreases from's balance
+func (e *ExecuterNode) debitImpl(
+	caller []byte, nonce uint64, from []byte, amount uint64,
+) error {
+	if !accounts.IsAccountKey(from) {
+		return ErrInvalidAccountKey
+	}
 	fromState, err := e.GetAccountState(from)
 	if err != nil {
 		return err
 	}
+	if fromState == nil {
+		return errors.New("account does not exist")
+	}
 	if bytes.Equal(caller, from) {
 		if !fromState.CheckNonce(nonce) {
-			return errors.New("nonce is not expected")
+			return ErrUnexpectedNonce
 		}
 	}
 	ok := fromState.Subtract(amount)
 	if !ok {
 		return errors.New("underflow")
 	}
-	err = e.PutAccountState(from, fromState)
-	if err != nil {
-		return err
-	}
+	return e.PutAccountState(from, fromState)
+}
 
-	// increase to's balance
+// increases to's balance
+func (e *ExecuterNode) creditImpl(
+	caller []byte, nonce uint64, to []byte, amount uint64,
+) error {
+	if !accounts.IsAccountKey(to) {
+		return ErrInvalidAccountKey
+	}
 	toState, err := e.GetAccountStateSafe(to)
 	if err != nil {
 		return err
 	}
 	if bytes.Equal(caller, to) {
 		if !toState.CheckNonce(nonce) {
-			return errors.New("nonce is not expected")
+			return ErrUnexpectedNonce
 		}
 	}
-	ok = toState.Add(amount)
+	ok := toState.Add(amount)
 	if !ok {
 		return errors.New("overflow")
 	}
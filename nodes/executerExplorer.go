@@ -0,0 +1,67 @@
+package nodes
+
+import (
+	"context"
+	"simple-blockchain-go/database"
+	"simple-blockchain-go/explorer"
+	"simple-blockchain-go/p2p"
+	"simple-blockchain-go/transactions"
+)
+
+// adapts running node to explorer,
+// database methods come from committed view, which is shallower than node
+type explorerSource struct {
+	*ExecuterNode
+	*database.Database
+}
+
+func (s explorerSource) Summary() explorer.Summary {
+	return explorer.Summary{
+		Consensus:       s.Engine.Name(),
+		Height:          s.Height,
+		Difficulty:      s.Difficulty,
+		LatestHash:      s.PreviousBlockHash,
+		FinalizedHeight: s.FinalizedHeight(),
+		IsSyncing:       s.isSyncing,
+		MempoolSize:     s.txPool.Len(),
+		Indexing:        s.Indexing(),
+	}
+}
+
+func (s explorerSource) Mempool() []transactions.Transaction {
+	return s.txPool.GetAll()
+}
+
+func (s explorerSource) Peers() []p2p.NodeId {
+	s.KnownNodes.Lock()
+	defer s.KnownNodes.Unlock()
+	return append([]p2p.NodeId{}, s.peers...)
+}
+
+// explorer is started with Run, empty addr disables it
+func (e *ExecuterNode) EnableExplorer(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	committed := e.Database.Committed()
+	server, err := explorer.NewServer(addr, explorerSource{e, &committed})
+	if err != nil {
+		return err
+	}
+	e.explorer = server
+	return nil
+}
+
+func (e *ExecuterNode) startExplorer() {
+	if e.explorer == nil {
+		return
+	}
+	e.serveHttp("explorer", e.explorer.ListenAndServe)
+}
+
+func (e *ExecuterNode) stopExplorer(ctx context.Context) {
+	if e.explorer == nil {
+		return
+	}
+	shutdownServer(ctx, "explorer", e.explorer)
+}
@@ -2,83 +2,165 @@ package nodes
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"log"
-	"net"
 	"simple-blockchain-go/accounts"
 	"simple-blockchain-go/blockchain"
 	"simple-blockchain-go/blocks"
 	"simple-blockchain-go/common"
 	"simple-blockchain-go/epoch"
+	"simple-blockchain-go/explorer"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/memory"
 	"simple-blockchain-go/p2p"
+	"simple-blockchain-go/rpc"
 	"simple-blockchain-go/transactions"
+	"simple-blockchain-go/ws"
 	"strings"
-	"time"
+	"sync"
 
 	"golang.org/x/exp/slices"
 )
 
-const (
-	MINE_THRESHOLD_MAX = 5100
-	MINE_THRESHOLD_MIN = 4900
-)
-
 type ExecuterNode struct {
 	Node
 	*blockchain.Blockchain
-	txPool         *memory.TxPool
+	txPool *memory.TxPool
+	// file which keeps pool over restarts
+	journal        string
 	epoch          *epoch.Epoch
 	isSyncing      bool
-	offeredTime    int64
-	offeredTxHash  []byte
+	workLock       sync.Mutex
+	templates      map[uint64]*workTemplate
+	nextTemplateId uint64
+	// receipt of transaction being executed
+	receipt        *blocks.Receipt
 	airdropAccount []byte
+	// nil when rpc is disabled
+	rpc *rpc.Server
+	hub *ws.Hub
+	// nil when explorer is disabled
+	explorer *explorer.Server
+	// nil when metrics are disabled
+	stats *executerMetrics
 }
 
-func NewExecuterNode(port string) (*ExecuterNode, error) {
+// index enables tx and account history indexes
+func NewExecuterNode(port string, index bool) (*ExecuterNode, error) {
 	bc, err := blockchain.NewBlockchain(port)
+	if err == nil {
+		bc.SetIndexing(index)
+	}
 	s := ExecuterNode{
 		Node: Node{
 			id:      p2p.NewNodeId(port, p2p.EXECUTER_NODE),
 			version: 1,
 		},
-		Blockchain:  bc,
-		txPool:      memory.NewTransactionPool(),
-		epoch:       nil,
-		offeredTime: time.Now().UnixMilli(),
+		Blockchain: bc,
+		txPool:     memory.NewTransactionPool(),
+		journal:    memory.JournalFileName(port),
+		epoch:      nil,
+		templates:  map[uint64]*workTemplate{},
 	}
 	s.AppendPeer(p2p.DefaultKnownNode(port, p2p.EXECUTER_NODE))
 	return &s, err
 }
 
-func (e *ExecuterNode) Run() error {
-	listener, err := net.Listen(p2p.TCP, string(e.id.Ip))
+func (e *ExecuterNode) Run(ctx context.Context) error {
+	e.start(ctx)
+	defer e.cancel()
+
+	err := e.restoreMempool()
+	if err != nil {
+		return err
+	}
+
+	listener, err := e.listen()
 	if err != nil {
 		return err
 	}
 	defer listener.Close()
-	log.Printf("executer node is listening at %s", e.id.Ip)
+	p2pLog.Info("executer node is listening", logger.Peer(e.id.Ip))
+
+	e.startMetrics()
 
 	err = e.broadcastJoin()
 	if err != nil {
 		return err
 	}
 
+	e.startRpc()
+	e.startExplorer()
+
 	e.epoch = epoch.NewEpoch(e.executionRoutine)
-	go e.epoch.StartEpochRoutine()
-	if isRendezvous(e.id) {
+	e.spawn("epoch routine", func() {
+		e.epoch.StartEpochRoutine(e.ctx)
+	})
+	if isRendezvous(e.id) || !e.Engine.IsRemoteSealing() {
 		e.retry()
 	}
 
-	for {
-		conn, err := listener.Accept()
+	err = e.serve(e.handleMessage)
+	e.shutdown(e.stop)
+	return err
+}
+
+// servers go first so that nothing touches pool or storage after them
+func (e *ExecuterNode) stop(ctx context.Context) {
+	e.stopRpc(ctx)
+	e.stopExplorer(ctx)
+	e.abandonPendingBlock()
+	err := e.txPool.SaveJournal(e.journal)
+	if err != nil {
+		mempoolLog.Error("flushing mempool journal failed", logger.Err(err))
+	}
+	err = e.Close()
+	if err != nil {
+		stateLog.Error("closing database failed", logger.Err(err))
+	}
+}
+
+// unsolved template must not leave its state behind,
+// its transactions are journaled with the rest of pool
+func (e *ExecuterNode) abandonPendingBlock() {
+	e.Lock()
+	defer e.Unlock()
+	_, err := e.abandonWorkTemplate()
+	if err != nil {
+		stateLog.Error("rolling back pending block failed", logger.Err(err))
+	}
+}
+
+// transactions which were pending at shutdown, stale ones are dropped
+func (e *ExecuterNode) restoreMempool() error {
+	txs, err := memory.LoadJournal(e.journal)
+	if err != nil {
+		return err
+	}
+	restored := 0
+	for _, tx := range txs {
+		ok, err := tx.Verify()
+		if err != nil || !ok {
+			continue
+		}
+		state, err := e.GetAccountState(tx.InnerData.PublicKey)
 		if err != nil {
 			return err
 		}
-
-		go e.handleConnection(conn)
+		if state != nil && tx.InnerData.Nonce < state.Nonce {
+			continue
+		}
+		e.txPool.Append(&tx)
+		restored++
+	}
+	if len(txs) > 0 {
+		mempoolLog.Info(
+			"mempool is restored from journal",
+			logger.F("txs", restored),
+			logger.F("dropped", len(txs)-restored),
+		)
 	}
+	return nil
 }
 
 func (e *ExecuterNode) checkHealth() error {
@@ -99,47 +181,42 @@ func (e *ExecuterNode) checkHealth() error {
 	return nil
 }
 
-func (e *ExecuterNode) handleConnection(conn net.Conn) {
-	request, err := io.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer conn.Close()
-
-	msgKind := p2p.MessageKind(request[0])
-	log.Printf("received msg '%s'\n", msgKind.ToString())
-
+func (e *ExecuterNode) handleMessage(msgKind p2p.MessageKind, body []byte) error {
 	switch msgKind {
 	case p2p.JOIN_MSG:
-		err = e.handleJoin(request[1:])
+		return e.handleJoin(body)
 	case p2p.ACCOUNT_MSG:
-		err = e.handleAccount(request[1:])
-	case p2p.REGISTER_BLOCK_MSG:
-		err = e.handleRegisterBlock(request[1:])
+		return e.handleAccount(body)
+	case p2p.TOKEN_ACCOUNT_MSG:
+		return e.handleTokenAccount(body)
+	case p2p.GET_WORK_MSG:
+		return e.handleGetWork(body)
+	case p2p.SUBMIT_WORK_MSG:
+		return e.handleSubmitWork(body)
 	case p2p.TX_MSG:
-		err = e.handleTransaction(request[1:])
+		return e.handleTransaction(body)
 	case p2p.TX_POOL_MSG:
-		err = e.handleTxPool(request[1:])
+		return e.handleTxPool(body)
 	case p2p.ADDRESS_MSG:
-		err = e.handleAddress(request[1:])
+		return e.handleAddress(body)
 	case p2p.BLOCKCHAIN_INFO_MSG:
-		err = e.handleBlockchainInfo(request[1:])
+		return e.handleBlockchainInfo(body)
 	case p2p.SYNC_BLOCK_REQUEST_MSG:
-		err = e.handleSyncBlockRequest(request[1:])
+		return e.handleSyncBlockRequest(body)
 	case p2p.SYNC_BLOCK_RESPONSE_MSG:
-		err = e.handleSyncBlockResponse(request[1:])
+		return e.handleSyncBlockResponse(body)
 	case p2p.ACCEPTED_BLOCK_MSG:
-		err = e.handleAcceptedBlock(request[1:])
+		return e.handleAcceptedBlock(body)
+	case p2p.FINALITY_MSG:
+		return e.handleFinality(body)
 	default:
-		log.Println("unknown message skipping...")
-	}
-	if err != nil {
-		log.Panic(err)
+		p2pLog.Debug("unexpected message, skipping", logger.F("kind", msgKind.ToString()))
 	}
+	return nil
 }
 
 func (e *ExecuterNode) handleBlockchainInfo(raw []byte) error {
-	msg, err := common.Decode[p2p.BlockchainInfoMsg](raw)
+	msg, err := decodeMsg[p2p.BlockchainInfoMsg](raw)
 	if err != nil {
 		return err
 	}
@@ -147,12 +224,16 @@ func (e *ExecuterNode) handleBlockchainInfo(raw []byte) error {
 		return nil
 	}
 
-	log.Printf(
-		"received blockchain info\n next height: %d\n difficulty: %d\n latest: %x\n",
-		msg.Height, msg.Difficulty, msg.PreviousBlockHash,
+	syncLog.Info(
+		"received blockchain info",
+		logger.Peer(msg.From.Ip),
+		logger.Height(msg.Height),
+		logger.F("difficulty", msg.Difficulty),
+		logger.Hash(msg.PreviousBlockHash),
 	)
 	if msg.Height > e.Height {
 		e.isSyncing = true
+		e.stats.setSyncTarget(msg.Height)
 		// now here means always download from RENDEZVOUS
 		// this should be changed
 		e.startDownloadBlocks(msg.From)
@@ -162,9 +243,9 @@ func (e *ExecuterNode) handleBlockchainInfo(raw []byte) error {
 }
 
 func (e *ExecuterNode) handleAccount(raw []byte) error {
-	msg, err := common.Decode[p2p.AccountMsg](raw)
+	msg, err := decodeMsg[p2p.AccountMsg](raw)
 	if err != nil {
-		return nil
+		return err
 	}
 	content, err := common.Encode(msg.From)
 	if err != nil {
@@ -172,81 +253,65 @@ func (e *ExecuterNode) handleAccount(raw []byte) error {
 	}
 	ok := common.QuickVerify(msg.Signature, msg.PublicKey, content)
 	if !ok {
-		log.Println("received signature in msg is invalid")
-		return nil
+		return peerFault(ErrInvalidSignature)
 	}
 
-	state, err := e.GetAccountStateSafe(msg.PublicKey)
+	state, err := e.committedAccountState(msg.PublicKey)
 	if err != nil {
 		return err
 	}
 	return e.sendAccountInfo(msg.From, state, msg.PublicKey)
 }
 
-func (e *ExecuterNode) handleRegisterBlock(raw []byte) error {
-	e.Lock()
-	defer e.Unlock()
-
-	msg, err := common.Decode[p2p.RegisterBlockMsg](raw)
-	if err != nil {
-		return err
+// account which does not exist yet is empty one,
+// it is not created because state changes only with blocks
+func (e *ExecuterNode) committedAccountState(pubKey []byte) (*accounts.AccountState, error) {
+	chain := e.Committed()
+	state, err := chain.GetAccountState(pubKey)
+	if err != nil || state != nil {
+		return state, err
 	}
+	return &accounts.AccountState{}, nil
+}
 
-	hash, err := msg.Block.Bundle.HashTransactions()
+func (e *ExecuterNode) handleTokenAccount(raw []byte) error {
+	msg, err := decodeMsg[p2p.TokenAccountMsg](raw)
 	if err != nil {
 		return err
 	}
-	if !bytes.Equal(e.offeredTxHash, hash) {
-		log.Println("received block's transactions are not expected")
-		return nil
-	}
-
-	if msg.Block.Difficulty != e.Difficulty {
-		log.Printf(
-			"received bloks's difficulty %d is invalid, expected: %d\n",
-			msg.Block.Difficulty, e.Difficulty,
-		)
-		return nil
-	}
-
-	ok, err := e.VerifyBlock(&msg.Block)
+	content, err := common.Encode(msg.From)
 	if err != nil {
 		return err
 	}
+	ok := common.QuickVerify(msg.Signature, msg.PublicKey, content)
 	if !ok {
-		log.Println("received block is invalid")
-		return nil
+		return peerFault(ErrInvalidSignature)
 	}
 
-	err = e.PutBlockWithCheck(&msg.Block)
+	chain := e.Committed()
+	token, err := chain.GetToken(msg.TokenId)
 	if err != nil {
 		return err
 	}
-
-	accepetdTime := time.Now().UnixMilli()
-	if accepetdTime-e.offeredTime > MINE_THRESHOLD_MAX {
-		e.Difficulty--
-	} else if accepetdTime-e.offeredTime < MINE_THRESHOLD_MIN {
-		e.Difficulty++
+	if token == nil {
+		stateLog.Debug("requested token does not exist", logger.HashOf("token", msg.TokenId))
+		return nil
 	}
-
-	// send reward only to accepted miner
-	err = e.sendReward(msg.From)
+	balance, err := chain.GetTokenBalance(msg.TokenId, msg.PublicKey)
 	if err != nil {
 		return err
 	}
-
-	// start new epoch routine
-	e.epoch.C() <- true
-
-	return e.broadcastAcceptedBlock(&msg.Block)
+	return e.sendTokenAccountInfo(msg.From, token, msg.PublicKey, balance)
 }
 
 func (e *ExecuterNode) handleJoin(raw []byte) error {
-	msg, err := common.Decode[p2p.JoinMsg](raw)
+	msg, err := decodeMsg[p2p.JoinMsg](raw)
 	if err != nil {
 		return err
 	}
+	if !msg.Kind.IsValid() {
+		return peerFault(ErrUnknownNodeKind)
+	}
 
 	if !slices.ContainsFunc(e.peers, func(node p2p.NodeId) bool {
 		return strings.Compare(node.Ip, msg.From) == 0
@@ -257,10 +322,10 @@ func (e *ExecuterNode) handleJoin(raw []byte) error {
 		}
 		e.AppendPeer(newFound)
 
-		log.Printf(
-			"found new peer at %s : %s\n",
-			newFound.Ip,
-			newFound.Kind.ToString(),
+		p2pLog.Info(
+			"found new peer",
+			logger.Peer(newFound.Ip),
+			logger.F("kind", newFound.Kind.ToString()),
 		)
 
 		err = e.sendKnownPeer(newFound)
@@ -268,7 +333,7 @@ func (e *ExecuterNode) handleJoin(raw []byte) error {
 			return err
 		}
 
-		if msg.Kind == p2p.EXECUTER_NODE || msg.Kind == p2p.MINER_NODE {
+		if msg.Kind == p2p.EXECUTER_NODE || isWorker(newFound) {
 			err = e.sendBlockchainInfo(newFound)
 			if err != nil {
 				return err
@@ -286,7 +351,7 @@ func (e *ExecuterNode) handleJoin(raw []byte) error {
 }
 
 func (e *ExecuterNode) handleAcceptedBlock(raw []byte) error {
-	msg, err := common.Decode[p2p.AcceptedBlockMsg](raw)
+	msg, err := decodeMsg[p2p.AcceptedBlockMsg](raw)
 	if err != nil {
 		return err
 	}
@@ -295,9 +360,14 @@ func (e *ExecuterNode) handleAcceptedBlock(raw []byte) error {
 		return nil
 	}
 
-	log.Println("received new accepted block")
-	log.Printf("including %d tx\n", len(msg.Block.Bundle.Transactions))
-	err = e.syncBlockImpl(&msg.Block)
+	syncLog.Info(
+		"received new accepted block",
+		logger.Peer(msg.From.Ip),
+		logger.Height(msg.Block.Height),
+		logger.Hash(msg.Block.Hash),
+		logger.F("txs", len(msg.Block.Bundle.Transactions)),
+	)
+	abandoned, err := e.syncAcceptedBlock(&msg.Block)
 	if err != nil {
 		return err
 	}
@@ -307,56 +377,159 @@ func (e *ExecuterNode) handleAcceptedBlock(raw []byte) error {
 	txKeys := memory.GetTxKeys(&msg.Block)
 	e.txPool.BatchRemove(txKeys)
 
+	// executers take turns to produce blocks,
+	// abandoned template is built again on top of this block
+	if !e.Engine.IsRemoteSealing() || abandoned {
+		e.epoch.Trigger()
+	}
+
 	// need not inform others ??
 	return nil // e.broadcastAcceptedBlock(&msg.Block)
 }
 
+// syncing holds lock itself, accepted block does not,
+// lock is released before epoch is triggered,
+// true when pending template was abandoned for the block
+func (e *ExecuterNode) syncAcceptedBlock(block *blocks.Block) (bool, error) {
+	e.Lock()
+	defer e.Unlock()
+	// pending template has the same parent, it can not land anymore
+	abandoned, err := e.abandonWorkTemplate()
+	if err != nil {
+		return abandoned, err
+	}
+	return abandoned, e.syncBlockImpl(block)
+}
+
+func (e *ExecuterNode) handleFinality(raw []byte) error {
+	msg, err := decodeMsg[p2p.FinalityMsg](raw)
+	if err != nil {
+		return err
+	}
+
+	info := p2p.FinalityInfoMsg{
+		From:            e.id,
+		Height:          msg.Height,
+		IsFinal:         e.IsFinal(msg.Height),
+		FinalizedHeight: e.FinalizedHeight(),
+	}
+	if msg.Height <= e.Height {
+		block, err := e.GetBlockByHeight(msg.Height)
+		if err != nil {
+			return err
+		}
+		info.Hash = block.Hash
+	}
+
+	enc, err := common.Encode(info)
+	if err != nil {
+		return err
+	}
+	payload := p2p.FINALITY_INFO_MSG.MakePayload(enc)
+	// requester might not be known peer
+	return e.reply(msg.From, payload)
+}
+
 func (e *ExecuterNode) handleTxPool(raw []byte) error {
-	msg, err := common.Decode[p2p.TxPoolMsg](raw)
+	msg, err := decodeMsg[p2p.TxPoolMsg](raw)
 	if err != nil {
 		return err
 	}
 
 	for _, tx := range msg.Transactions {
+		reason, err := e.checkTransaction(&tx)
+		if err != nil {
+			return peerFault(err)
+		}
+		if reason != "" {
+			mempoolLog.Debug(
+				"pooled transaction is rejected",
+				logger.Peer(msg.From.Ip),
+				logger.Hash(tx.Hash[:]),
+				logger.F("reason", reason),
+			)
+			continue
+		}
 		e.txPool.Append(&tx)
 	}
 	return nil
 }
 
 func (e *ExecuterNode) handleTransaction(raw []byte) error {
-	msg, err := common.Decode[p2p.TransactionMsg](raw)
+	msg, err := decodeMsg[p2p.TransactionMsg](raw)
 	if err != nil {
 		return err
 	}
 
-	ok, err := msg.Transaction.Verify()
+	reason, err := e.acceptTransaction(&msg.Transaction, msg.From)
 	if err != nil {
-		return err
+		// only broken transaction fails verification
+		return peerFault(err)
+	}
+	if reason != "" {
+		mempoolLog.Info(
+			"received transaction is rejected",
+			logger.Peer(msg.From.Ip),
+			logger.Hash(msg.Transaction.Hash[:]),
+			logger.F("reason", reason),
+		)
+	}
+	return nil
+}
+
+// returns reason when transaction is not worth pooling
+func (e *ExecuterNode) checkTransaction(
+	tx *transactions.Transaction,
+) (string, error) {
+	ok, err := tx.Verify()
+	if err != nil {
+		return "", err
 	}
 	if !ok {
-		log.Println("received transaction is invalid")
-		return nil
+		return "invalid transaction", nil
+	}
+	ok, err = tx.PaysMinFee(e.Config.MinFeePerWeight)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "min fee is not paid", nil
+	}
+	return "", nil
+}
+
+// relays transaction to other executers and adds it to pool,
+// returns reason when transaction is rejected
+func (e *ExecuterNode) acceptTransaction(
+	tx *transactions.Transaction, from p2p.NodeId,
+) (string, error) {
+	reason, err := e.checkTransaction(tx)
+	if err != nil || reason != "" {
+		return reason, err
 	}
 
 	executers := common.FindAll(e.peers, func(id p2p.NodeId) bool {
 		return id.Kind == p2p.EXECUTER_NODE &&
-			!e.isSelf(id) && !p2p.IsSameIp(msg.From, id)
+			!e.isSelf(id) && !p2p.IsSameIp(from, id)
 	})
 	for _, exc := range executers {
-		e.sendTransaction(exc, &msg.Transaction)
+		e.sendTransaction(exc, tx)
 	}
 
-	if msg.From.Kind == p2p.WALLET_NODE {
-		e.txPool.AppendOrOverwrite(&msg.Transaction)
-	} else if msg.From.Kind == p2p.EXECUTER_NODE {
-		e.txPool.Append(&msg.Transaction)
+	if from.Kind == p2p.WALLET_NODE {
+		e.txPool.AppendOrOverwrite(tx)
+	} else if from.Kind == p2p.EXECUTER_NODE {
+		e.txPool.Append(tx)
 	}
+	e.publishPendingTx(tx)
 
-	log.Printf(
-		"received transaction, current pool size: %d\n",
-		e.txPool.Len(),
+	mempoolLog.Debug(
+		"accepted transaction",
+		logger.Peer(from.Ip),
+		logger.Hash(tx.Hash[:]),
+		logger.F("poolSize", e.txPool.Len()),
 	)
-	return nil
+	return "", nil
 }
 
 func (e *ExecuterNode) sendTxPool(to p2p.NodeId) error {
@@ -389,6 +562,25 @@ func (e *ExecuterNode) sendAccountInfo(
 	return e.send(to, payload)
 }
 
+func (e *ExecuterNode) sendTokenAccountInfo(
+	to p2p.NodeId, token *blockchain.Token, pubKey []byte, balance uint64,
+) error {
+	msg := p2p.TokenAccountInfoMsg{
+		From:      e.id,
+		PublicKey: pubKey,
+		TokenId:   token.Id,
+		Symbol:    token.Symbol,
+		Decimals:  token.Decimals,
+		Balance:   balance,
+	}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := p2p.TOKEN_ACCOUNT_INFO_MSG.MakePayload(enc)
+	return e.send(to, payload)
+}
+
 func (e *ExecuterNode) sendKnownPeer(to p2p.NodeId) error {
 	msg := p2p.AddressMsg{
 		From:     e.id,
@@ -457,28 +649,3 @@ func (e *ExecuterNode) sendReward(to p2p.NodeId) error {
 	payload := p2p.REWARD_MSG.MakePayload(enc)
 	return e.send(to, payload)
 }
-
-func (e *ExecuterNode) broadcastOfferBlock(block *blocks.Block) error {
-	msg := p2p.OfferBlockMsg{
-		From:  e.id,
-		Block: *block,
-	}
-	enc, err := common.Encode(msg)
-	if err != nil {
-		return err
-	}
-
-	payload := p2p.OFFER_BLOCK_MSG.MakePayload(enc)
-	err = e.broadcast(payload)
-	if err != nil {
-		return err
-	}
-
-	hash, err := block.Bundle.HashTransactions()
-	if err != nil {
-		return err
-	}
-	e.offeredTxHash = hash
-	e.offeredTime = time.Now().UnixMilli()
-	return nil
-}
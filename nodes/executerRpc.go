@@ -0,0 +1,268 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/p2p"
+	"simple-blockchain-go/rpc"
+	"simple-blockchain-go/transactions"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// rpc clients submit transactions like wallets
+var rpcClientId = p2p.NodeId{Kind: p2p.WALLET_NODE}
+
+type HeightParams struct {
+	Height uint64 `json:"height"`
+}
+
+// hex block or transaction hash
+type HashParams struct {
+	Hash string `json:"hash"`
+}
+
+// base58 public key
+type AccountParams struct {
+	PublicKey string `json:"publicKey"`
+}
+
+type SendTransactionParams struct {
+	Transaction transactions.Transaction `json:"transaction"`
+}
+
+type ChainInfo struct {
+	Consensus       string `json:"consensus"`
+	Height          uint64 `json:"height"`
+	Difficulty      byte   `json:"difficulty"`
+	LatestHash      string `json:"latestHash"`
+	FinalizedHeight uint64 `json:"finalizedHeight"`
+	IsSyncing       bool   `json:"isSyncing"`
+	MempoolSize     int    `json:"mempoolSize"`
+	Indexing        bool   `json:"indexing"`
+}
+
+// pending transaction has no block
+type TransactionResult struct {
+	Transaction transactions.Transaction `json:"transaction"`
+	Pending     bool                     `json:"pending"`
+	BlockHash   string                   `json:"blockHash,omitempty"`
+	Height      uint64                   `json:"height,omitempty"`
+	Index       uint32                   `json:"index"`
+	Receipt     *blocks.Receipt          `json:"receipt,omitempty"`
+}
+
+type MempoolResult struct {
+	Size         int                        `json:"size"`
+	Transactions []transactions.Transaction `json:"transactions"`
+}
+
+type PeerResult struct {
+	Ip   string `json:"ip"`
+	Kind string `json:"kind"`
+}
+
+// rpc server is started with Run, empty addr disables it
+func (e *ExecuterNode) EnableRpc(addr string, token string) {
+	if addr == "" {
+		return
+	}
+	e.rpc = rpc.NewServer(addr, token)
+	e.rpc.Register("getChainInfo", e.rpcGetChainInfo)
+	e.rpc.Register("getBlockByHeight", e.rpcGetBlockByHeight)
+	e.rpc.Register("getBlockByHash", e.rpcGetBlockByHash)
+	e.rpc.Register("getAccount", e.rpcGetAccount)
+	e.rpc.Register("sendTransaction", e.rpcSendTransaction)
+	e.rpc.Register("getTransaction", e.rpcGetTransaction)
+	e.rpc.Register("getMempool", e.rpcGetMempool)
+	e.rpc.Register("getPeers", e.rpcGetPeers)
+	e.enableWs()
+}
+
+func (e *ExecuterNode) startRpc() {
+	if e.rpc == nil {
+		return
+	}
+	e.serveHttp("rpc", e.rpc.ListenAndServe)
+}
+
+// subscribers are told to go away before server stops
+func (e *ExecuterNode) stopRpc(ctx context.Context) {
+	if e.rpc == nil {
+		return
+	}
+	e.hub.Close()
+	shutdownServer(ctx, "rpc", e.rpc)
+}
+
+func decodeHash(s string) ([]byte, error) {
+	hash, err := hex.DecodeString(s)
+	if err != nil || len(hash) != 32 {
+		return nil, rpc.NewError(rpc.INVALID_PARAMS, "invalid hash")
+	}
+	return hash, nil
+}
+
+func decodePublicKey(s string) ([]byte, error) {
+	pubKey := base58.Decode(s)
+	if !accounts.IsAccountKey(pubKey) {
+		return nil, rpc.NewError(rpc.INVALID_PARAMS, "invalid public key")
+	}
+	return pubKey, nil
+}
+
+func (e *ExecuterNode) rpcGetChainInfo(json.RawMessage) (interface{}, error) {
+	return &ChainInfo{
+		Consensus:       e.Engine.Name(),
+		Height:          e.Height,
+		Difficulty:      e.Difficulty,
+		LatestHash:      hex.EncodeToString(e.PreviousBlockHash),
+		FinalizedHeight: e.FinalizedHeight(),
+		IsSyncing:       e.isSyncing,
+		MempoolSize:     e.txPool.Len(),
+		Indexing:        e.Indexing(),
+	}, nil
+}
+
+func (e *ExecuterNode) rpcGetBlockByHeight(
+	params json.RawMessage,
+) (interface{}, error) {
+	p, err := rpc.DecodeParams[HeightParams](params)
+	if err != nil {
+		return nil, err
+	}
+	if p.Height > e.Height {
+		return nil, rpc.NewError(rpc.NOT_FOUND, "block is not found")
+	}
+	chain := e.Committed()
+	return chain.GetBlockByHeight(p.Height)
+}
+
+func (e *ExecuterNode) rpcGetBlockByHash(
+	params json.RawMessage,
+) (interface{}, error) {
+	p, err := rpc.DecodeParams[HashParams](params)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := decodeHash(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return e.getBlockOrNotFound(hash)
+}
+
+func (e *ExecuterNode) getBlockOrNotFound(hash []byte) (*blocks.Block, error) {
+	chain := e.Committed()
+	block, err := chain.GetBlockByHash(hash)
+	if err != nil {
+		return nil, rpc.NewError(rpc.NOT_FOUND, "block is not found")
+	}
+	return block, nil
+}
+
+// account which does not exist yet is empty one
+func (e *ExecuterNode) rpcGetAccount(
+	params json.RawMessage,
+) (interface{}, error) {
+	p, err := rpc.DecodeParams[AccountParams](params)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := decodePublicKey(p.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return e.committedAccountState(pubKey)
+}
+
+func (e *ExecuterNode) rpcSendTransaction(
+	params json.RawMessage,
+) (interface{}, error) {
+	p, err := rpc.DecodeParams[SendTransactionParams](params)
+	if err != nil {
+		return nil, err
+	}
+	reason, err := e.acceptTransaction(&p.Transaction, rpcClientId)
+	if err != nil {
+		// broken transaction is not an error of node
+		return nil, rpc.NewError(rpc.REJECTED, err.Error())
+	}
+	if reason != "" {
+		return nil, rpc.NewError(rpc.REJECTED, reason)
+	}
+	return &HashParams{Hash: hex.EncodeToString(p.Transaction.Hash[:])}, nil
+}
+
+// mined transactions are found only when indexing is enabled
+func (e *ExecuterNode) rpcGetTransaction(
+	params json.RawMessage,
+) (interface{}, error) {
+	p, err := rpc.DecodeParams[HashParams](params)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := decodeHash(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx, ok := e.txPool.GetByHash(hash); ok {
+		return &TransactionResult{Transaction: *tx, Pending: true}, nil
+	}
+	if !e.Indexing() {
+		return nil, rpc.NewError(rpc.NOT_FOUND, "transaction is not pending and indexing is disabled")
+	}
+	chain := e.Committed()
+	ref, err := chain.GetTxRef(hash)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, rpc.NewError(rpc.NOT_FOUND, "transaction is not found")
+	}
+
+	block, err := chain.GetBlockByHeight(ref.Height)
+	if err != nil {
+		return nil, err
+	}
+	// index might be stale when block of its height is overwritten
+	txs := block.Bundle.Transactions
+	if int(ref.Index) >= len(txs) ||
+		!bytes.Equal(txs[ref.Index].Hash[:], hash) {
+		return nil, rpc.NewError(rpc.NOT_FOUND, "transaction is not found")
+	}
+	result := &TransactionResult{
+		Transaction: txs[ref.Index],
+		BlockHash:   hex.EncodeToString(block.Hash),
+		Height:      ref.Height,
+		Index:       ref.Index,
+	}
+	receipts, err := chain.GetReceipts(block.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if int(ref.Index) < len(receipts) {
+		result.Receipt = &receipts[ref.Index]
+	}
+	return result, nil
+}
+
+func (e *ExecuterNode) rpcGetMempool(json.RawMessage) (interface{}, error) {
+	txs := e.txPool.GetAll()
+	return &MempoolResult{Size: len(txs), Transactions: txs}, nil
+}
+
+func (e *ExecuterNode) rpcGetPeers(json.RawMessage) (interface{}, error) {
+	e.KnownNodes.Lock()
+	defer e.KnownNodes.Unlock()
+	peers := make([]PeerResult, 0, len(e.peers))
+	for _, id := range e.peers {
+		peers = append(peers, PeerResult{Ip: id.Ip, Kind: id.Kind.ToString()})
+	}
+	return peers, nil
+}
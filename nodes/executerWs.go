@@ -0,0 +1,98 @@
+package nodes
+
+import (
+	"encoding/hex"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/transactions"
+	"simple-blockchain-go/ws"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+const (
+	WS_PATH = "/ws"
+)
+
+type HeadResult struct {
+	blocks.BlockHeader
+	Hash    string `json:"hash"`
+	TxCount int    `json:"txCount"`
+}
+
+type PendingTxResult struct {
+	Hash        string                   `json:"hash"`
+	Transaction transactions.Transaction `json:"transaction"`
+}
+
+type AccountChangeResult struct {
+	PublicKey string `json:"publicKey"`
+	Height    uint64 `json:"height"`
+	Nonce     uint64 `json:"nonce"`
+	Balance   uint64 `json:"balance"`
+	Locked    uint64 `json:"locked"`
+}
+
+// subscriptions are served on rpc server
+func (e *ExecuterNode) enableWs() {
+	e.hub = ws.NewHub()
+	e.rpc.HandleWebSocket(WS_PATH, e.hub)
+}
+
+// called after block, its receipts and rewards are applied
+func (e *ExecuterNode) publishBlock(
+	block *blocks.Block, receipts []blocks.Receipt,
+) {
+	if e.hub == nil {
+		return
+	}
+	e.hub.Publish(ws.TOPIC_NEW_HEADS, "", &HeadResult{
+		BlockHeader: block.BlockHeader,
+		Hash:        hex.EncodeToString(block.Hash),
+		TxCount:     len(block.Bundle.Transactions),
+	})
+
+	if !e.hub.HasSubscribers(ws.TOPIC_ACCOUNTS) {
+		return
+	}
+	// senders change nonce, rewards can change coinbase
+	touched := map[string][]byte{}
+	if len(block.Coinbase) > 0 {
+		touched[base58.Encode(block.Coinbase)] = block.Coinbase
+	}
+	for _, tx := range block.Bundle.Transactions {
+		touched[base58.Encode(tx.InnerData.PublicKey)] = tx.InnerData.PublicKey
+	}
+	for _, r := range receipts {
+		for _, c := range r.BalanceChanges {
+			touched[base58.Encode(c.PublicKey)] = c.PublicKey
+		}
+	}
+	for key, pubKey := range touched {
+		state, err := e.GetAccountState(pubKey)
+		if err != nil {
+			stateLog.Error("could not read changed account", logger.Err(err))
+			return
+		}
+		if state == nil {
+			continue
+		}
+		e.hub.Publish(ws.TOPIC_ACCOUNTS, key, &AccountChangeResult{
+			PublicKey: key,
+			Height:    block.Height,
+			Nonce:     state.Nonce,
+			Balance:   state.Balance,
+			Locked:    state.Locked,
+		})
+	}
+}
+
+func (e *ExecuterNode) publishPendingTx(tx *transactions.Transaction) {
+	if e.hub == nil {
+		return
+	}
+	e.hub.Publish(ws.TOPIC_PENDING_TXS, "", &PendingTxResult{
+		Hash:        hex.EncodeToString(tx.Hash[:]),
+		Transaction: *tx,
+	})
+}
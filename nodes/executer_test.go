@@ -0,0 +1,230 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/geneis"
+	"simple-blockchain-go/poa"
+	"simple-blockchain-go/transactions"
+	"simple-blockchain-go/wallets"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+const TEST_PORT = "3900"
+
+// poa executer in temporary directory which signs blocks itself
+func newTestExecuter(t *testing.T) *ExecuterNode {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	signer, err := wallets.NewWallet(TEST_PORT, poa.SIGNER_KEY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other fields keep default value
+	config, err := common.Encode(map[string]interface{}{
+		"Consensus": "poa",
+		"Signers":   []string{base58.Encode(signer.PublicKey())},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(geneis.GENESIS_CONFIG_FILE, config, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewExecuterNode(TEST_PORT, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func newTestWallet(t *testing.T, name string) *wallets.Wallet {
+	w, err := wallets.NewWallet(TEST_PORT, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func airdropTx(t *testing.T, w *wallets.Wallet, nonce uint64) transactions.Transaction {
+	cmd, err := common.Encode(transactions.Airdrop{PublicKey: w.PublicKey(), Amount: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transactions.Transaction{InnerData: transactions.TransactionData{
+		Data:      transactions.AIRDROP_CMD.MakePayload(cmd),
+		PublicKey: w.PublicKey(),
+		Nonce:     nonce,
+		Timestamp: time.Now().UnixMilli(),
+	}}
+	err = w.Sign(&tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// next block executed like producer does, then state is rolled back,
+// tamper changes it before sealing
+func buildBlock(
+	t *testing.T, e *ExecuterNode,
+	txs []transactions.Transaction, tamper func(*blocks.Block),
+) *blocks.Block {
+	block, err := blocks.NewBlock(transactions.TxBundle{Transactions: txs}, e.BlockInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Height++
+	minTimestamp, err := e.MinNextTimestamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Timestamp < minTimestamp {
+		block.Timestamp = minTimestamp
+	}
+	err = e.Engine.Prepare(e.Blockchain, &block.BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.BeginBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipts, err := e.executeBlock(block)
+	if err == nil {
+		block.ReceiptsRoot, err = blocks.HashReceipts(receipts)
+	}
+	if err == nil {
+		block.StateRoot, err = e.calcState()
+	}
+	rollbackErr := e.RollbackBlock()
+	if rollbackErr != nil {
+		t.Fatal(rollbackErr)
+	}
+	// invalid transactions have no roots
+	if err != nil && !errors.Is(err, ErrInvalidTransaction) {
+		t.Fatal(err)
+	}
+
+	if tamper != nil {
+		tamper(block)
+	}
+	err = e.Engine.Seal(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestSyncBlock(t *testing.T) {
+	e := newTestExecuter(t)
+	w := newTestWallet(t, "user")
+	txs := []transactions.Transaction{airdropTx(t, w, 0), airdropTx(t, w, 1)}
+
+	block := buildBlock(t, e, txs, nil)
+	err := e.syncBlockImpl(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Height != 1 {
+		t.Fatalf("height %d", e.Height)
+	}
+	state, err := e.committedAccountState(w.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Nonce != 2 || state.Balance != 10 {
+		t.Errorf("nonce %d, balance %d", state.Nonce, state.Balance)
+	}
+	receipts, err := e.GetReceipts(block.Hash)
+	if err != nil || len(receipts) != 2 {
+		t.Fatalf("receipts %v, %v", receipts, err)
+	}
+}
+
+// invalid block leaves neither block nor state behind
+func TestSyncBlockRefusesInvalidBlock(t *testing.T) {
+	e := newTestExecuter(t)
+	w := newTestWallet(t, "user")
+	valid := []transactions.Transaction{airdropTx(t, w, 0), airdropTx(t, w, 1)}
+
+	cases := []struct {
+		name   string
+		txs    []transactions.Transaction
+		tamper func(*blocks.Block)
+	}{
+		{"receipts root", valid, func(b *blocks.Block) { b.ReceiptsRoot = make([]byte, 32) }},
+		{"state root", valid, func(b *blocks.Block) { b.StateRoot = make([]byte, 32) }},
+		{"skipped nonce", []transactions.Transaction{airdropTx(t, w, 0), airdropTx(t, w, 2)}, nil},
+		{"replayed nonce", []transactions.Transaction{valid[0], valid[0]}, nil},
+	}
+	for _, c := range cases {
+		block := buildBlock(t, e, c.txs, c.tamper)
+		err := e.syncBlockImpl(block)
+		if classify(err) != PEER_FAULT || !errors.Is(err, ErrInvalidBlock) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+		if e.InBlock() {
+			t.Fatalf("%s: block is left pending", c.name)
+		}
+		if e.Height != 0 {
+			t.Fatalf("%s: height %d", c.name, e.Height)
+		}
+		state, err := e.GetAccountState(w.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != nil {
+			t.Errorf("%s: state is written %+v", c.name, state)
+		}
+	}
+}
+
+func TestAdmitterPicksNextNonces(t *testing.T) {
+	e := newTestExecuter(t)
+	w := newTestWallet(t, "user")
+	other := newTestWallet(t, "other")
+
+	broken := airdropTx(t, w, 5)
+	broken.InnerData.Fee = 1
+	cases := []struct {
+		name      string
+		tx        transactions.Transaction
+		ok, evict bool
+	}{
+		{"first", airdropTx(t, w, 0), true, false},
+		{"gap", airdropTx(t, w, 2), false, false},
+		{"next", airdropTx(t, w, 1), true, false},
+		{"used", airdropTx(t, w, 0), false, true},
+		{"other sender", airdropTx(t, other, 0), true, false},
+		{"broken signature", broken, false, true},
+	}
+	admit := e.admitter()
+	for _, c := range cases {
+		ok, evict, err := admit(&c.tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != c.ok || evict != c.evict {
+			t.Errorf("%s: ok %v, evict %v", c.name, ok, evict)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/database"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/p2p"
+)
+
+type FaultKind byte
+
+const (
+	// peer sent something broken, it is penalized
+	PEER_FAULT FaultKind = iota + 1
+	// this node failed, it is logged and node goes on
+	LOCAL_FAULT
+	// storage failed, node shuts down
+	FATAL_FAULT
+)
+
+var (
+	ErrEmptyMessage     = errors.New("message is empty")
+	ErrInvalidSignature = errors.New("signature in message is invalid")
+	ErrUnknownAccount   = errors.New("account is not in this wallet")
+	ErrInvalidBlock     = errors.New("block is invalid")
+	ErrUnknownNodeKind  = errors.New("node kind is unknown")
+)
+
+func (fk FaultKind) ToString() string {
+	switch fk {
+	case PEER_FAULT:
+		return "peer"
+	case LOCAL_FAULT:
+		return "local"
+	case FATAL_FAULT:
+		return "fatal"
+	default:
+		return "unknown"
+	}
+}
+
+// error which knows who caused it
+type Fault struct {
+	Kind FaultKind
+	Err  error
+}
+
+func (f *Fault) Error() string {
+	return f.Err.Error()
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+func peerFault(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Fault{Kind: PEER_FAULT, Err: err}
+}
+
+func fatalFault(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Fault{Kind: FATAL_FAULT, Err: err}
+}
+
+// errors which are not marked are local,
+// except storage errors which are always fatal
+func classify(err error) FaultKind {
+	if database.IsStorageError(err) {
+		return FATAL_FAULT
+	}
+	var f *Fault
+	if errors.As(err, &f) {
+		return f.Kind
+	}
+	return LOCAL_FAULT
+}
+
+// messages come from peers, so broken one is their fault
+func decodeMsg[T interface{}](raw []byte) (*T, error) {
+	msg, err := common.Decode[T](raw)
+	if err != nil {
+		return nil, peerFault(err)
+	}
+	return msg, nil
+}
+
+func readMessage(conn net.Conn) (p2p.MessageKind, []byte, error) {
+	request, err := io.ReadAll(conn)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(request) == 0 {
+		return 0, nil, peerFault(ErrEmptyMessage)
+	}
+	kind := p2p.MessageKind(request[0])
+	if !kind.IsValid() {
+		return kind, nil, peerFault(fmt.Errorf("unknown message kind %d", kind))
+	}
+	return kind, request[1:], nil
+}
+
+// handles one message of connection,
+// whatever happens while handling stays in this connection
+func (n *Node) serveConnection(
+	conn net.Conn, handle func(p2p.MessageKind, []byte) error,
+) {
+	defer conn.Close()
+	defer n.recoverPanic("connection from " + conn.RemoteAddr().String())
+
+	kind, body, err := readMessage(conn)
+	if err == nil {
+		p2pLog.Debug(
+			"received message",
+			logger.F("kind", kind.ToString()),
+			logger.Peer(conn.RemoteAddr().String()),
+		)
+		n.metrics.countIn(kind)
+		err = handle(kind, body)
+	}
+	if err != nil {
+		n.handleFault(conn, kind, err)
+	}
+}
+
+func (n *Node) handleFault(conn net.Conn, kind p2p.MessageKind, err error) {
+	fk := classify(err)
+	n.metrics.countFault(fk)
+	fields := []logger.Field{
+		logger.Peer(conn.RemoteAddr().String()),
+		logger.Err(err),
+	}
+	if kind.IsValid() {
+		fields = append(fields, logger.F("kind", kind.ToString()))
+	}
+
+	switch fk {
+	case PEER_FAULT:
+		p2pLog.Warn("peer fault, dropping connection", fields...)
+		n.penalize(remoteHost(conn))
+	case FATAL_FAULT:
+		p2pLog.Error("fatal fault, shutting down", fields...)
+		n.fail(err)
+	default:
+		p2pLog.Error("handling message failed", fields...)
+	}
+}
+
+// for errors which have no peer
+func (n *Node) handleLocalFault(msg string, err error) {
+	fk := classify(err)
+	n.metrics.countFault(fk)
+	if fk == FATAL_FAULT {
+		p2pLog.Error(msg+", shutting down", logger.Err(err))
+		n.fail(err)
+		return
+	}
+	p2pLog.Error(msg, logger.Err(err))
+}
+
+// deferred at top of goroutines,
+// panic is local fault unless it carries fatal error
+func (n *Node) recoverPanic(where string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	p2pLog.Error(
+		"recovered panic",
+		logger.F("goroutine", where),
+		logger.Err(err),
+		logger.F("stack", string(debug.Stack())),
+	)
+	fk := classify(err)
+	n.metrics.countFault(fk)
+	if fk == FATAL_FAULT {
+		n.fail(err)
+	}
+}
+
+// first fatal error cancels context of node,
+// Run returns it after shutdown
+func (n *Node) fail(err error) {
+	n.failOnce.Do(func() {
+		n.failure.Store(&err)
+		if n.cancel != nil {
+			n.cancel()
+		}
+	})
+}
+
+func (n *Node) fatalError() error {
+	err := n.failure.Load()
+	if err == nil {
+		return nil
+	}
+	return *err
+}
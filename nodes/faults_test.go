@@ -0,0 +1,101 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"simple-blockchain-go/p2p"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want FaultKind
+	}{
+		{"plain", errors.New("oops"), LOCAL_FAULT},
+		{"peer", peerFault(ErrInvalidBlock), PEER_FAULT},
+		{"wrapped peer", fmt.Errorf("handling: %w", peerFault(ErrInvalidBlock)), PEER_FAULT},
+		{"fatal", fatalFault(errors.New("oops")), FATAL_FAULT},
+		{"bolt", fmt.Errorf("put: %w", bolt.ErrTxClosed), FATAL_FAULT},
+		{"file", &fs.PathError{Op: "open", Path: "db", Err: fs.ErrPermission}, FATAL_FAULT},
+		// storage failure is not excused by blaming peer
+		{"storage under peer", peerFault(bolt.ErrDatabaseNotOpen), FATAL_FAULT},
+	}
+	for _, c := range cases {
+		if got := classify(c.err); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got.ToString(), c.want.ToString())
+		}
+	}
+	if peerFault(nil) != nil || fatalFault(nil) != nil {
+		t.Error("nil error is marked")
+	}
+}
+
+func TestBrokenMessageIsPeerFault(t *testing.T) {
+	_, err := decodeMsg[p2p.JoinMsg]([]byte("{"))
+	if classify(err) != PEER_FAULT {
+		t.Errorf("broken message: %v", err)
+	}
+
+	for _, raw := range [][]byte{{}, {255}} {
+		client, server := net.Pipe()
+		go func() {
+			client.Write(raw)
+			client.Close()
+		}()
+		_, _, err := readMessage(server)
+		server.Close()
+		if classify(err) != PEER_FAULT {
+			t.Errorf("message %v: %v", raw, err)
+		}
+	}
+}
+
+func TestUnknownNodeKindIsPeerFault(t *testing.T) {
+	known := p2p.NodeId{Ip: "localhost:3000", Kind: p2p.EXECUTER_NODE}
+	unknown := p2p.NodeId{Ip: "localhost:3001", Kind: p2p.POOL_NODE + 1}
+	cases := []struct {
+		name string
+		msg  p2p.AddressMsg
+		ok   bool
+	}{
+		{"known", p2p.AddressMsg{From: known, NodeList: []p2p.NodeId{known}}, true},
+		{"unknown sender", p2p.AddressMsg{From: unknown}, false},
+		{"unknown in list", p2p.AddressMsg{From: known, NodeList: []p2p.NodeId{known, unknown}}, false},
+	}
+	for _, c := range cases {
+		err := checkNodeKinds(&c.msg)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if err != nil && (classify(err) != PEER_FAULT || !errors.Is(err, ErrUnknownNodeKind)) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+	if unknown.Kind.ToString() != "unknown" {
+		t.Errorf("unknown kind is named %q", unknown.Kind.ToString())
+	}
+}
+
+func TestPeerIsBannedAfterRepeatedFaults(t *testing.T) {
+	var scores peerScores
+	faults := -BAN_SCORE / PEER_FAULT_PENALTY
+	for i := 1; i < faults; i++ {
+		_, banned := scores.penalize("10.0.0.1", PEER_FAULT_PENALTY)
+		if banned {
+			t.Fatalf("banned after %d faults", i)
+		}
+	}
+	_, banned := scores.penalize("10.0.0.1", PEER_FAULT_PENALTY)
+	if !banned || !scores.isBanned("10.0.0.1") {
+		t.Fatalf("not banned after %d faults", faults)
+	}
+	if scores.isBanned("10.0.0.2") {
+		t.Error("other host is banned")
+	}
+}
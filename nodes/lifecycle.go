@@ -0,0 +1,143 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/p2p"
+	"sync"
+	"time"
+)
+
+const (
+	// seconds which shutdown may take before it gives up waiting
+	SHUTDOWN_TIMEOUT = 10
+)
+
+// derives context which fatal fault cancels,
+// it is canceled also when Run returns
+func (n *Node) start(ctx context.Context) context.Context {
+	n.ctx, n.cancel = context.WithCancel(ctx)
+	return n.ctx
+}
+
+func (n *Node) listen() (net.Listener, error) {
+	listener, err := net.Listen(p2p.TCP, string(n.id.Ip))
+	if err != nil {
+		return nil, err
+	}
+	n.listener = listener
+	return listener, nil
+}
+
+// waits for connection from host which is not banned
+func (n *Node) accept() (net.Conn, error) {
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		host := remoteHost(conn)
+		if !n.scores.isBanned(host) {
+			return conn, nil
+		}
+		p2pLog.Debug("dropping connection of banned host", logger.Peer(host))
+		conn.Close()
+	}
+}
+
+// handles connections until context of node is done,
+// returns fatal error which stopped node if any
+func (n *Node) serve(handle func(p2p.MessageKind, []byte) error) error {
+	go func() {
+		<-n.ctx.Done()
+		n.listener.Close()
+	}()
+
+	for {
+		conn, err := n.accept()
+		if err != nil {
+			if n.ctx.Err() != nil {
+				return n.fatalError()
+			}
+			n.cancel()
+			return err
+		}
+
+		n.handlers.Add(1)
+		go func() {
+			defer n.handlers.Done()
+			n.serveConnection(conn, handle)
+		}()
+	}
+}
+
+// runs f in background, shutdown waits for it
+func (n *Node) spawn(name string, f func()) {
+	n.workers.Add(1)
+	go func() {
+		defer n.workers.Done()
+		defer n.recoverPanic(name)
+		f()
+	}()
+}
+
+// false when deadline came first
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waits for handlers and background routines, then stop
+// releases what node holds, whole shutdown is bounded by SHUTDOWN_TIMEOUT
+func (n *Node) shutdown(stop func(context.Context)) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), time.Second*SHUTDOWN_TIMEOUT,
+	)
+	defer cancel()
+	p2pLog.Info("shutting down, waiting for running handlers")
+	if !waitGroup(ctx, &n.handlers) {
+		p2pLog.Warn("handlers did not finish in time")
+	}
+	if !waitGroup(ctx, &n.workers) {
+		p2pLog.Warn("background routines did not finish in time")
+	}
+	if stop != nil {
+		stop(ctx)
+	}
+	n.stopMetrics(ctx)
+	p2pLog.Info("node is stopped")
+}
+
+func shutdownServer(
+	ctx context.Context, name string,
+	server interface{ Shutdown(context.Context) error },
+) {
+	err := server.Shutdown(ctx)
+	if err != nil {
+		p2pLog.Warn("server did not shut down cleanly", logger.F("server", name), logger.Err(err))
+	}
+}
+
+// ListenAndServe always returns error, closed server is not a failure,
+// but server which can not listen stops node
+func (n *Node) serveHttp(name string, listenAndServe func() error) {
+	go func() {
+		err := listenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p2pLog.Error(name+" server failed", logger.Err(err))
+			n.fail(err)
+		}
+	}()
+}
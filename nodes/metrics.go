@@ -0,0 +1,264 @@
+package nodes
+
+import (
+	"context"
+	"net/http"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/metrics"
+	"simple-blockchain-go/p2p"
+	"time"
+)
+
+// metrics which every kind of node has
+type nodeMetrics struct {
+	registry     *metrics.Registry
+	server       *http.Server
+	messagesIn   *metrics.CounterVec
+	messagesOut  *metrics.CounterVec
+	unavailables *metrics.Counter
+	faults       *metrics.CounterVec
+}
+
+// metrics are served with Run, empty addr disables them
+func (n *Node) EnableMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	r := metrics.NewRegistry()
+	n.metrics = &nodeMetrics{
+		registry: r,
+		server:   r.NewServer(addr),
+		messagesIn: r.NewCounterVec(
+			"messages_in_total", "received messages by kind", "kind",
+		),
+		messagesOut: r.NewCounterVec(
+			"messages_out_total", "sent messages by kind", "kind",
+		),
+		unavailables: r.NewCounter(
+			"peer_unavailable_total", "peers which could not be dialed and were removed",
+		),
+		faults: r.NewCounterVec(
+			"faults_total", "failed message handling and recovered panics by fault", "fault",
+		),
+	}
+	r.NewGaugeVecFunc(
+		"peers", "known peers by node kind", "kind",
+		func() map[string]float64 {
+			n.KnownNodes.Lock()
+			defer n.KnownNodes.Unlock()
+			counts := map[string]float64{}
+			for _, id := range n.peers {
+				// scraping must never fail on a broken peer
+				if !id.Kind.IsValid() {
+					counts["unknown"]++
+					continue
+				}
+				counts[id.Kind.ToString()]++
+			}
+			return counts
+		},
+	)
+}
+
+func (n *Node) startMetrics() {
+	if n.metrics == nil {
+		return
+	}
+	p2pLog.Info("metrics are served", logger.F("addr", n.metrics.server.Addr+"/metrics"))
+	n.serveHttp("metrics", n.metrics.server.ListenAndServe)
+}
+
+func (n *Node) stopMetrics(ctx context.Context) {
+	if n.metrics == nil {
+		return
+	}
+	shutdownServer(ctx, "metrics", n.metrics.server)
+}
+
+// nil when metrics are disabled
+func (n *Node) registry() *metrics.Registry {
+	if n.metrics == nil {
+		return nil
+	}
+	return n.metrics.registry
+}
+
+func (m *nodeMetrics) countIn(kind p2p.MessageKind) {
+	if m == nil {
+		return
+	}
+	m.messagesIn.Inc(kind.ToString())
+}
+
+// first byte of payload is kind of message
+func (m *nodeMetrics) countOut(payload []byte) {
+	if m == nil || len(payload) == 0 {
+		return
+	}
+	m.messagesOut.Inc(p2p.MessageKind(payload[0]).ToString())
+}
+
+func (m *nodeMetrics) countUnavailable() {
+	if m == nil {
+		return
+	}
+	m.unavailables.Inc()
+}
+
+func (m *nodeMetrics) countFault(kind FaultKind) {
+	if m == nil {
+		return
+	}
+	m.faults.Inc(kind.ToString())
+}
+
+const (
+	BLOCK_SOURCE_LOCAL = "local"
+	BLOCK_SOURCE_WORK  = "work"
+	BLOCK_SOURCE_SYNC  = "sync"
+)
+
+type executerMetrics struct {
+	blocks     *metrics.CounterVec
+	processing *metrics.Histogram
+	syncTarget *metrics.Gauge
+}
+
+func (e *ExecuterNode) EnableMetrics(addr string) {
+	e.Node.EnableMetrics(addr)
+	r := e.registry()
+	if r == nil {
+		return
+	}
+	e.stats = &executerMetrics{
+		blocks: r.NewCounterVec(
+			"blocks_applied_total", "blocks stored by where they came from", "source",
+		),
+		processing: r.NewHistogram(
+			"block_processing_seconds", "execution and state calculation of a block",
+			metrics.DefaultBuckets,
+		),
+		syncTarget: r.NewGauge(
+			"sync_target_height", "height of peer which is being synced with",
+		),
+	}
+	r.NewGaugeFunc("chain_height", "height of local chain", func() float64 {
+		return float64(e.Height)
+	})
+	r.NewGaugeFunc("chain_difficulty", "difficulty of next block", func() float64 {
+		return float64(e.Difficulty)
+	})
+	r.NewGaugeFunc("finalized_height", "height which is never rewritten", func() float64 {
+		return float64(e.FinalizedHeight())
+	})
+	r.NewGaugeFunc("mempool_size", "transactions in pool", func() float64 {
+		return float64(e.txPool.Len())
+	})
+	r.NewCounterFunc(
+		"mempool_evictions_total", "transactions dropped from pool without inclusion",
+		e.txPool.Evictions,
+	)
+	r.NewGaugeFunc("syncing", "1 while downloading blocks", func() float64 {
+		if e.isSyncing {
+			return 1
+		}
+		return 0
+	})
+}
+
+func (m *executerMetrics) countBlock(source string) {
+	if m == nil {
+		return
+	}
+	m.blocks.Inc(source)
+}
+
+func (m *executerMetrics) observeProcessing(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.processing.ObserveSince(start)
+}
+
+func (m *executerMetrics) setSyncTarget(height uint64) {
+	if m == nil {
+		return
+	}
+	m.syncTarget.Set(float64(height))
+}
+
+type minerMetrics struct {
+	submitted *metrics.Counter
+	results   *metrics.CounterVec
+}
+
+func (m *MinerNode) EnableMetrics(addr string) {
+	m.Node.EnableMetrics(addr)
+	r := m.registry()
+	if r == nil {
+		return
+	}
+	m.stats = &minerMetrics{
+		submitted: r.NewCounter(
+			"work_submitted_total", "solutions and shares sent upstream",
+		),
+		results: r.NewCounterVec(
+			"work_results_total", "results of submitted work", "result",
+		),
+	}
+	r.NewGaugeFunc("mining_hashrate", "hashes per second of running jobs", m.Hashrate)
+	r.NewGaugeFunc("mining_jobs", "templates being mined", func() float64 {
+		m.jobLock.Lock()
+		defer m.jobLock.Unlock()
+		return float64(len(m.jobs))
+	})
+	r.NewGaugeFunc("chain_height", "latest height told by upstream", func() float64 {
+		return float64(m.latest().Height)
+	})
+	r.NewGaugeFunc("chain_difficulty", "difficulty told by upstream", func() float64 {
+		return float64(m.latest().Difficulty)
+	})
+}
+
+func (s *minerMetrics) countSubmitted() {
+	if s == nil {
+		return
+	}
+	s.submitted.Inc()
+}
+
+func (s *minerMetrics) countResult(accepted bool) {
+	if s == nil {
+		return
+	}
+	if accepted {
+		s.results.Inc("accepted")
+	} else {
+		s.results.Inc("rejected")
+	}
+}
+
+type walletMetrics struct {
+	txsSent *metrics.Counter
+}
+
+func (w *WalletNode) EnableMetrics(addr string) {
+	w.Node.EnableMetrics(addr)
+	r := w.registry()
+	if r == nil {
+		return
+	}
+	w.stats = &walletMetrics{
+		txsSent: r.NewCounter("wallet_txs_sent_total", "transactions sent to executers"),
+	}
+	r.NewGaugeFunc("wallet_accounts", "accounts held by wallet", func() float64 {
+		return float64(len(w.accounts))
+	})
+}
+
+func (s *walletMetrics) countTxSent() {
+	if s == nil {
+		return
+	}
+	s.txsSent.Inc()
+}
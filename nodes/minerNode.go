@@ -1,114 +1,318 @@
 package nodes
 
 import (
-	"io"
-	"log"
-	"net"
+	"context"
+	"errors"
 	"simple-blockchain-go/blocks"
 	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/p2p"
 	"simple-blockchain-go/pow"
+	"sync"
+	"time"
 )
 
+const (
+	// milli seconds
+	WORK_POLL_INTERVAL = 1000
+)
+
+// template being mined for one executer
+type miningJob struct {
+	templateId      uint64
+	height          uint64
+	shareDifficulty byte
+	nonceFrom       uint64
+	nonceTo         uint64
+	engine          *pow.MiningEngine
+	ctx             context.Context
+	cancel          context.CancelFunc
+}
+
 type MinerNode struct {
 	Node
+	// handlers and metrics read it concurrently
+	infoLock   sync.Mutex
 	latestInfo blocks.BlockInfo
-	offerer    p2p.NodeId
+	workers    int
+	// executers, or a pool when mining for pool
+	upstreamKind p2p.NodeKind
+	jobLock      sync.Mutex
+	// by upstream's ip
+	jobs map[string]*miningJob
+	// nil when metrics are disabled
+	stats *minerMetrics
 }
 
-func NewMinerNode(port string) *MinerNode {
+// workers <= 0 means all cores,
+// empty poolPort means solo mining
+func NewMinerNode(port string, workers int, poolPort string) *MinerNode {
 	m := MinerNode{
 		Node: Node{
 			id:      p2p.NewNodeId(port, p2p.MINER_NODE),
 			version: 1,
 		},
-		latestInfo: blocks.BlockInfo{},
+		latestInfo:   blocks.BlockInfo{},
+		workers:      workers,
+		upstreamKind: p2p.EXECUTER_NODE,
+		jobs:         map[string]*miningJob{},
+	}
+	if poolPort == "" {
+		m.AppendPeer(p2p.DefaultKnownNode(port, p2p.MINER_NODE))
+	} else {
+		m.upstreamKind = p2p.POOL_NODE
+		m.AppendPeer(p2p.NewNodeId(poolPort, p2p.POOL_NODE))
 	}
-	m.AppendPeer(p2p.DefaultKnownNode(port, p2p.MINER_NODE))
 	return &m
 }
 
-func (m *MinerNode) Run() error {
-	listener, err := net.Listen(p2p.TCP, string(m.id.Ip))
+func (m *MinerNode) Run(ctx context.Context) error {
+	m.start(ctx)
+	defer m.cancel()
+
+	listener, err := m.listen()
 	if err != nil {
 		return err
 	}
 	defer listener.Close()
-	log.Printf("miner node is listening at %s", m.id.Ip)
+	p2pLog.Info("miner node is listening", logger.Peer(m.id.Ip))
+
+	m.startMetrics()
 
 	err = m.broadcastJoin()
 	if err != nil {
 		return err
 	}
 
+	m.spawn("work polling", m.startPollingWork)
+
+	err = m.serve(m.handleMessage)
+	m.shutdown(nil)
+	return err
+}
+
+// hashes per second of all running jobs
+func (m *MinerNode) Hashrate() float64 {
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+	var rate float64
+	for _, j := range m.jobs {
+		rate += j.engine.Hashrate()
+	}
+	return rate
+}
+
+func (m *MinerNode) startPollingWork() {
+	ticker := time.NewTicker(time.Millisecond * WORK_POLL_INTERVAL)
+	defer ticker.Stop()
 	for {
-		conn, err := listener.Accept()
+		select {
+		case <-ticker.C:
+		case <-m.ctx.Done():
+			return
+		}
+		err := m.broadcastGetWork()
 		if err != nil {
-			return err
+			m.handleLocalFault("polling work failed", err)
 		}
-
-		go m.handleConnection(conn)
 	}
 }
 
-func (m *MinerNode) mine(block *blocks.Block) error {
-	miner := pow.NewProofOfWork(block)
-	nonce, hash, err := miner.Run()
-	if err != nil {
-		return err
+// starts mining in background,
+// a job for the same executer which is running is abandoned
+func (m *MinerNode) startMining(
+	executer p2p.NodeId, templateId uint64, block *blocks.Block,
+	expiry int64, shareDifficulty byte, nonceFrom, nonceTo uint64,
+) {
+	m.jobLock.Lock()
+	old, ok := m.jobs[executer.Ip]
+	if ok && old.templateId == templateId {
+		// already mining
+		m.jobLock.Unlock()
+		return
+	}
+	if ok {
+		powLog.Debug(
+			"abandoning template",
+			logger.F("template", old.templateId),
+			logger.Peer(executer.Ip),
+		)
+		old.cancel()
 	}
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+	// shutdown of node cancels job as well
+	ctx, cancel := context.WithDeadline(
+		m.ctx, time.UnixMilli(expiry),
+	)
+	job := &miningJob{
+		templateId:      templateId,
+		height:          block.Height,
+		shareDifficulty: shareDifficulty,
+		nonceFrom:       nonceFrom,
+		nonceTo:         nonceTo,
+		engine:          pow.NewMiningEngine(m.workers),
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+	m.jobs[executer.Ip] = job
+	m.jobLock.Unlock()
+
+	m.spawn("mining job", func() {
+		defer m.finishJob(executer, job)
+
+		err := m.mine(job, block, executer)
+		if errors.Is(err, pow.ErrMiningCanceled) {
+			powLog.Info(
+				"template is canceled",
+				logger.F("template", templateId),
+				logger.Peer(executer.Ip),
+				logger.F("hashrate", job.engine.Hashrate()),
+			)
+			return
+		}
+		if err != nil {
+			m.handleLocalFault("mining failed", err)
+		}
+	})
+}
 
-	log.Printf("broadcasting new block...")
-	return m.sendRegisterBlock(block)
+// stops jobs which are not newer than height
+func (m *MinerNode) abandonStaleJobs(height uint64) {
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+	for ip, j := range m.jobs {
+		if j.height <= height {
+			powLog.Debug(
+				"template is stale",
+				logger.F("template", j.templateId),
+				logger.Peer(ip),
+			)
+			j.cancel()
+		}
+	}
 }
 
-func (m *MinerNode) handleConnection(conn net.Conn) {
-	request, err := io.ReadAll(conn)
+func (m *MinerNode) finishJob(executer p2p.NodeId, job *miningJob) {
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+	job.cancel()
+	// job might be already replaced with new one
+	if m.jobs[executer.Ip] == job {
+		delete(m.jobs, executer.Ip)
+	}
+}
+
+func (m *MinerNode) mine(
+	job *miningJob, block *blocks.Block, executer p2p.NodeId,
+) error {
+	if job.shareDifficulty > 0 && job.shareDifficulty < block.Difficulty {
+		return m.mineShares(job, block, executer)
+	}
+
+	miner := pow.NewProofOfWork(block)
+	nonce, hash, err := miner.RunContext(job.ctx, job.engine)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	defer conn.Close()
 
-	msgKind := p2p.MessageKind(request[0])
-	log.Printf("received msg '%s'\n", msgKind.ToString())
+	powLog.Info(
+		"submitting template",
+		logger.F("template", job.templateId),
+		logger.Peer(executer.Ip),
+		logger.F("nonce", nonce),
+		logger.Hash(hash),
+	)
+	return m.sendSubmitWork(executer, job.templateId, nonce, hash)
+}
 
+// submits every hash under share target until job is done,
+// pool tells which of them is a block
+func (m *MinerNode) mineShares(
+	job *miningJob, block *blocks.Block, pool p2p.NodeId,
+) error {
+	powLog.Info(
+		"mining shares",
+		logger.F("template", job.templateId),
+		logger.F("shareDifficulty", job.shareDifficulty),
+		logger.F("workers", job.engine.Workers()),
+	)
+	return job.engine.MineShares(
+		job.ctx, block, job.shareDifficulty, job.nonceFrom, job.nonceTo,
+		func(nonce uint64, hash []byte) {
+			powLog.Debug("submitting share", logger.Hash(hash), logger.Peer(pool.Ip))
+			err := m.sendSubmitWork(pool, job.templateId, nonce, hash)
+			if err != nil {
+				powLog.Error("could not submit share", logger.Peer(pool.Ip), logger.Err(err))
+			}
+		},
+	)
+}
+
+func (m *MinerNode) handleMessage(msgKind p2p.MessageKind, body []byte) error {
 	switch msgKind {
 	case p2p.ADDRESS_MSG:
-		err = m.handleAddress(request[1:])
+		return m.handleAddress(body)
 	case p2p.BLOCKCHAIN_INFO_MSG:
-		err = m.handleBlockchainInfo(request[1:])
-	case p2p.OFFER_BLOCK_MSG:
-		err = m.handleOfferBlock(request[1:])
+		return m.handleBlockchainInfo(body)
+	case p2p.WORK_MSG:
+		return m.handleWork(body)
+	case p2p.WORK_RESULT_MSG:
+		return m.handleWorkResult(body)
 	case p2p.ACCEPTED_BLOCK_MSG:
-		err = m.handleAcceptedBlock(request[1:])
+		return m.handleAcceptedBlock(body)
 	case p2p.REWARD_MSG:
-		log.Printf("\n\n    this is the miner (^_^)    \n\n")
+		powLog.Info("received reward")
 	default:
-		log.Println("unknown message skipping...")
-	}
-	if err != nil {
-		log.Panic(err)
+		p2pLog.Debug("unexpected message, skipping", logger.F("kind", msgKind.ToString()))
 	}
+	return nil
 }
 
-func (m *MinerNode) handleOfferBlock(raw []byte) error {
-	msg, err := common.Decode[p2p.OfferBlockMsg](raw)
+func (m *MinerNode) handleWork(raw []byte) error {
+	msg, err := decodeMsg[p2p.WorkMsg](raw)
 	if err != nil {
 		return err
 	}
-	if msg.From.Kind != p2p.EXECUTER_NODE {
+	if msg.From.Kind != m.upstreamKind {
+		return nil
+	}
+	if msg.Expiry < time.Now().UnixMilli() {
+		powLog.Debug("template is already expired", logger.F("template", msg.TemplateId), logger.Peer(msg.From.Ip))
 		return nil
 	}
 
-	m.offerer = msg.From
-	return m.mine(&msg.Block)
+	m.startMining(
+		msg.From, msg.TemplateId, &msg.Block,
+		msg.Expiry, msg.ShareDifficulty, msg.NonceFrom, msg.NonceTo,
+	)
+	return nil
+}
+
+func (m *MinerNode) handleWorkResult(raw []byte) error {
+	msg, err := decodeMsg[p2p.WorkResultMsg](raw)
+	if err != nil {
+		return err
+	}
+	m.stats.countResult(msg.Accepted)
+	if msg.Accepted {
+		powLog.Info(
+			"template is accepted",
+			logger.F("template", msg.TemplateId),
+			logger.Peer(msg.From.Ip),
+		)
+	} else {
+		powLog.Warn(
+			"template is rejected",
+			logger.F("template", msg.TemplateId),
+			logger.Peer(msg.From.Ip),
+			logger.F("reason", msg.Reason),
+		)
+	}
+	return nil
 }
 
 func (m *MinerNode) handleBlockchainInfo(raw []byte) error {
-	msg, err := common.Decode[p2p.BlockchainInfoMsg](raw)
+	msg, err := decodeMsg[p2p.BlockchainInfoMsg](raw)
 	if err != nil {
 		return err
 	}
@@ -116,45 +320,92 @@ func (m *MinerNode) handleBlockchainInfo(raw []byte) error {
 		return nil
 	}
 
-	m.latestInfo.Height = msg.Height + 1
-	m.latestInfo.Difficulty = msg.Difficulty
-	m.latestInfo.PreviousBlockHash = msg.PreviousBlockHash
-	log.Printf(
-		"received blockchain info\n next height: %d\n difficulty: %d\n latest: %x\n",
-		m.latestInfo.Height, m.latestInfo.Difficulty, m.latestInfo.PreviousBlockHash,
+	info := m.setLatestInfo(msg.Height+1, msg.Difficulty, msg.PreviousBlockHash)
+	syncLog.Info(
+		"received blockchain info",
+		logger.Peer(msg.From.Ip),
+		logger.Height(info.Height),
+		logger.F("difficulty", info.Difficulty),
+		logger.Hash(info.PreviousBlockHash),
 	)
 	return nil
 }
 
+func (m *MinerNode) setLatestInfo(
+	height uint64, difficulty byte, previous []byte,
+) blocks.BlockInfo {
+	m.infoLock.Lock()
+	defer m.infoLock.Unlock()
+	m.latestInfo.Height = height
+	m.latestInfo.Difficulty = difficulty
+	m.latestInfo.PreviousBlockHash = previous
+	return m.latestInfo
+}
+
+func (m *MinerNode) latest() blocks.BlockInfo {
+	m.infoLock.Lock()
+	defer m.infoLock.Unlock()
+	return m.latestInfo
+}
+
 func (m *MinerNode) handleAcceptedBlock(raw []byte) error {
-	msg, err := common.Decode[p2p.AcceptedBlockMsg](raw)
+	msg, err := decodeMsg[p2p.AcceptedBlockMsg](raw)
 	if err != nil {
 		return err
 	}
-	if msg.From.Kind != p2p.EXECUTER_NODE {
+	if msg.From.Kind != m.upstreamKind {
 		return nil
 	}
 
-	m.latestInfo.Height = msg.Block.Height + 1
-	m.latestInfo.Difficulty = msg.Difficulty
-	m.latestInfo.PreviousBlockHash = msg.Block.PreviousBlockHash
-	log.Printf(
-		"received accepted block\n next height: %d\n difficulty: %d\n latest: %x\n",
-		m.latestInfo.Height, m.latestInfo.Difficulty, m.latestInfo.PreviousBlockHash,
+	m.abandonStaleJobs(msg.Block.Height)
+
+	info := m.setLatestInfo(
+		msg.Block.Height+1, msg.Difficulty, msg.Block.PreviousBlockHash,
+	)
+	syncLog.Info(
+		"received accepted block",
+		logger.Peer(msg.From.Ip),
+		logger.Height(info.Height),
+		logger.F("difficulty", info.Difficulty),
+		logger.Hash(info.PreviousBlockHash),
 	)
 	return nil
 }
 
-func (m *MinerNode) sendRegisterBlock(block *blocks.Block) error {
-	msg := p2p.RegisterBlockMsg{
-		From:  m.id,
-		Block: *block,
+func (m *MinerNode) broadcastGetWork() error {
+	msg := p2p.GetWorkMsg{From: m.id}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	payload := p2p.GET_WORK_MSG.MakePayload(enc)
+	upstreams := common.FindAll(m.peers, func(id p2p.NodeId) bool {
+		return id.Kind == m.upstreamKind
+	})
+	m.sendEach(upstreams, payload)
+	return nil
+}
+
+func (m *MinerNode) sendSubmitWork(
+	to p2p.NodeId, templateId uint64, nonce uint64, hash []byte,
+) error {
+	msg := p2p.SubmitWorkMsg{
+		From:       m.id,
+		TemplateId: templateId,
+		Nonce:      nonce,
+		Hash:       hash,
 	}
 	enc, err := common.Encode(msg)
 	if err != nil {
 		return err
 	}
 
-	payload := p2p.REGISTER_BLOCK_MSG.MakePayload(enc)
-	return m.send(m.offerer, payload)
+	payload := p2p.SUBMIT_WORK_MSG.MakePayload(enc)
+	err = m.send(to, payload)
+	if err != nil {
+		return err
+	}
+	m.stats.countSubmitted()
+	return nil
 }
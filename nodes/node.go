@@ -2,19 +2,42 @@ package nodes
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
-	"log"
 	"net"
 	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/p2p"
 	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	p2pLog     = logger.New(logger.P2P)
+	syncLog    = logger.New(logger.SYNC)
+	mempoolLog = logger.New(logger.MEMPOOL)
+	powLog     = logger.New(logger.POW)
+	stateLog   = logger.New(logger.STATE)
 )
 
 type Node struct {
 	id      p2p.NodeId
 	version byte
 	KnownNodes
+	// nil when metrics are disabled
+	metrics *nodeMetrics
+	scores  peerScores
+	// set by Run
+	listener net.Listener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	failOnce sync.Once
+	failure  atomic.Pointer[error]
+	// running message handlers and background routines
+	handlers sync.WaitGroup
+	workers  sync.WaitGroup
 }
 
 func isRendezvous(node p2p.NodeId) bool {
@@ -27,7 +50,7 @@ func (n *Node) isSelf(node p2p.NodeId) bool {
 
 func (n *Node) broadcastJoin() error {
 	if isRendezvous(n.id) {
-		log.Println("listening rendezvous point...")
+		p2pLog.Info("listening as rendezvous point", logger.Peer(n.id.Ip))
 		return nil
 	}
 
@@ -41,7 +64,7 @@ func (n *Node) broadcastJoin() error {
 		return err
 	}
 
-	log.Println("broadcasting join...")
+	p2pLog.Info("broadcasting join", logger.F("peers", len(n.peers)))
 	payload := p2p.JOIN_MSG.MakePayload(ser)
 	return n.broadcast(payload)
 }
@@ -54,14 +77,42 @@ func (n *Node) send(to p2p.NodeId, data []byte) error {
 
 	conn, err := net.Dial(p2p.TCP, string(to.Ip))
 	if err != nil {
-		log.Printf("%s is not available\n", to.Ip)
+		p2pLog.Warn("peer is not available, removing", logger.Peer(to.Ip), logger.Err(err))
 		n.RemovePeer(idx)
+		n.metrics.countUnavailable()
 		return nil
 	}
+	return n.write(conn, data)
+}
+
+// answers node which does not have to be known,
+// it is not added to peers, so claimed address gets no broadcasts
+func (n *Node) reply(to p2p.NodeId, data []byte) error {
+	conn, err := net.Dial(p2p.TCP, string(to.Ip))
+	if err != nil {
+		return err
+	}
+	return n.write(conn, data)
+}
+
+func (n *Node) write(conn net.Conn, data []byte) error {
 	defer conn.Close()
+	_, err := io.Copy(conn, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	n.metrics.countOut(data)
+	return nil
+}
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	return err
+// one failing peer does not stop the rest
+func (n *Node) sendEach(targets []p2p.NodeId, data []byte) {
+	for _, to := range targets {
+		err := n.send(to, data)
+		if err != nil {
+			p2pLog.Warn("sending failed, skipping peer", logger.Peer(to.Ip), logger.Err(err))
+		}
+	}
 }
 
 func (n *Node) broadcast(data []byte) error {
@@ -78,8 +129,25 @@ func (n *Node) broadcast(data []byte) error {
 	return nil
 }
 
+// peers must never be stored with kind which this node does not know
+func checkNodeKinds(msg *p2p.AddressMsg) error {
+	if !msg.From.Kind.IsValid() {
+		return peerFault(ErrUnknownNodeKind)
+	}
+	for _, node := range msg.NodeList {
+		if !node.Kind.IsValid() {
+			return peerFault(ErrUnknownNodeKind)
+		}
+	}
+	return nil
+}
+
 func (n *Node) handleAddress(raw []byte) error {
-	msg, err := common.Decode[p2p.AddressMsg](raw)
+	msg, err := decodeMsg[p2p.AddressMsg](raw)
+	if err != nil {
+		return err
+	}
+	err = checkNodeKinds(msg)
 	if err != nil {
 		return err
 	}
@@ -91,10 +159,11 @@ func (n *Node) handleAddress(raw []byte) error {
 	peer := common.FindAll(msg.NodeList, func(node p2p.NodeId) bool {
 		return !isRendezvous(node) && !n.isSelf(node) &&
 			(node.Kind == p2p.EXECUTER_NODE ||
-				node.Kind == p2p.MINER_NODE)
+				node.Kind == p2p.MINER_NODE ||
+				node.Kind == p2p.POOL_NODE)
 
 	})
-	log.Printf("recieved %d peer\n", len(peer))
+	p2pLog.Debug("received peers", logger.Peer(msg.From.Ip), logger.F("count", len(peer)))
 	n.AppendPeer(peer...)
 	return nil
 }
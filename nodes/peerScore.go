@@ -0,0 +1,82 @@
+package nodes
+
+import (
+	"net"
+	"simple-blockchain-go/logger"
+	"sync"
+	"time"
+)
+
+const (
+	// every host starts at 0 and is banned at BAN_SCORE
+	PEER_FAULT_PENALTY = 20
+	BAN_SCORE          = -100
+	// seconds
+	BAN_DURATION = 600
+	// score of host without faults for this long is forgotten
+	SCORE_RESET_INTERVAL = 600
+)
+
+type peerScore struct {
+	score       int
+	updated     time.Time
+	bannedUntil time.Time
+}
+
+// scores by host, port is not part of key
+// because it changes with every connection
+type peerScores struct {
+	lock   sync.Mutex
+	scores map[string]*peerScore
+}
+
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// returns score after penalty and whether host got banned
+func (ps *peerScores) penalize(host string, penalty int) (int, bool) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if ps.scores == nil {
+		ps.scores = map[string]*peerScore{}
+	}
+	now := time.Now()
+	s, ok := ps.scores[host]
+	if !ok || now.Sub(s.updated) > time.Second*SCORE_RESET_INTERVAL {
+		s = &peerScore{}
+		ps.scores[host] = s
+	}
+	s.score -= penalty
+	s.updated = now
+	if s.score > BAN_SCORE {
+		return s.score, false
+	}
+	s.bannedUntil = now.Add(time.Second * BAN_DURATION)
+	s.score = 0
+	return BAN_SCORE, true
+}
+
+func (ps *peerScores) isBanned(host string) bool {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	s, ok := ps.scores[host]
+	return ok && time.Now().Before(s.bannedUntil)
+}
+
+func (n *Node) penalize(host string) {
+	score, banned := n.scores.penalize(host, PEER_FAULT_PENALTY)
+	if banned {
+		p2pLog.Warn(
+			"host is banned",
+			logger.Peer(host),
+			logger.F("seconds", BAN_DURATION),
+		)
+		return
+	}
+	p2pLog.Debug("host is penalized", logger.Peer(host), logger.F("score", score))
+}
@@ -0,0 +1,548 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/p2p"
+	"simple-blockchain-go/pool"
+	"simple-blockchain-go/pow"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// share target is this much easier than block's
+	SHARE_DIFFICULTY_GAP = 6
+	// each miner searches its own 2^48 nonces
+	// so that miners do not submit the same shares
+	NONCE_SLOT_BITS = 48
+)
+
+// upstream template served to miners
+type poolJob struct {
+	upstream        p2p.NodeId
+	templateId      uint64
+	block           blocks.Block
+	expiry          int64
+	shareDifficulty byte
+	// nonces already submitted
+	seen map[uint64]bool
+}
+
+// full solution waiting for upstream's result
+type poolSubmission struct {
+	jobId  uint64
+	finder p2p.NodeId
+	block  blocks.Block
+}
+
+// coordinates miners in front of executers,
+// miners submit shares and pool submits full solutions
+type PoolNode struct {
+	Node
+	store     *pool.Store
+	jobLock   sync.Mutex
+	jobs      map[uint64]*poolJob
+	nextJobId uint64
+	// nonce slot by miner's ip
+	slots map[string]uint64
+	// by upstream's ip and template id
+	submissions map[string]*poolSubmission
+}
+
+func NewPoolNode(port string) (*PoolNode, error) {
+	store, err := pool.OpenStore(port)
+	p := PoolNode{
+		Node: Node{
+			id:      p2p.NewNodeId(port, p2p.POOL_NODE),
+			version: 1,
+		},
+		store:       store,
+		jobs:        map[uint64]*poolJob{},
+		slots:       map[string]uint64{},
+		submissions: map[string]*poolSubmission{},
+	}
+	p.AppendPeer(p2p.DefaultKnownNode(port, p2p.POOL_NODE))
+	return &p, err
+}
+
+func (p *PoolNode) Run(ctx context.Context) error {
+	p.start(ctx)
+	defer p.cancel()
+	defer p.store.Close()
+
+	listener, err := p.listen()
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	p2pLog.Info("pool node is listening", logger.Peer(p.id.Ip))
+
+	p.startMetrics()
+
+	err = p.broadcastJoin()
+	if err != nil {
+		return err
+	}
+
+	p.spawn("work polling", p.startPollingWork)
+
+	err = p.serve(p.handleMessage)
+	p.shutdown(nil)
+	return err
+}
+
+func shareDifficultyOf(blockDifficulty byte) byte {
+	if blockDifficulty <= SHARE_DIFFICULTY_GAP {
+		return pow.MIN_DIFFICULTY
+	}
+	return blockDifficulty - SHARE_DIFFICULTY_GAP
+}
+
+func submissionKey(upstream p2p.NodeId, templateId uint64) string {
+	return fmt.Sprintf("%s/%d", upstream.Ip, templateId)
+}
+
+func (p *PoolNode) startPollingWork() {
+	ticker := time.NewTicker(time.Millisecond * WORK_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+		err := p.broadcastGetWork()
+		if err != nil {
+			p.handleLocalFault("polling work failed", err)
+		}
+	}
+}
+
+// jobs of previous height are dropped
+func (p *PoolNode) addJob(msg *p2p.WorkMsg) {
+	p.jobLock.Lock()
+	defer p.jobLock.Unlock()
+
+	for id, j := range p.jobs {
+		if p2p.IsSameIp(j.upstream, msg.From) &&
+			j.templateId == msg.TemplateId {
+			// already serving
+			return
+		}
+		if j.block.Height < msg.Block.Height {
+			delete(p.jobs, id)
+		}
+	}
+
+	p.nextJobId++
+	p.jobs[p.nextJobId] = &poolJob{
+		upstream:        msg.From,
+		templateId:      msg.TemplateId,
+		block:           msg.Block,
+		expiry:          msg.Expiry,
+		shareDifficulty: shareDifficultyOf(msg.Block.Difficulty),
+		seen:            map[uint64]bool{},
+	}
+	powLog.Info(
+		"pool job is ready",
+		logger.F("job", p.nextJobId),
+		logger.Height(msg.Block.Height),
+		logger.F("shareDifficulty", p.jobs[p.nextJobId].shareDifficulty),
+	)
+}
+
+// latest job which is not expired
+func (p *PoolNode) currentJob() (uint64, *poolJob) {
+	p.jobLock.Lock()
+	defer p.jobLock.Unlock()
+
+	j, ok := p.jobs[p.nextJobId]
+	if !ok || j.expiry < time.Now().UnixMilli() {
+		return 0, nil
+	}
+	return p.nextJobId, j
+}
+
+func (p *PoolNode) dropStaleJobs(height uint64) {
+	p.jobLock.Lock()
+	defer p.jobLock.Unlock()
+
+	for id, j := range p.jobs {
+		if j.block.Height <= height {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+func (p *PoolNode) handleMessage(msgKind p2p.MessageKind, body []byte) error {
+	switch msgKind {
+	case p2p.JOIN_MSG:
+		return p.handleJoin(body)
+	case p2p.ADDRESS_MSG:
+		return p.handleAddress(body)
+	case p2p.BLOCKCHAIN_INFO_MSG:
+		// pool waits for templates
+	case p2p.WORK_MSG:
+		return p.handleWork(body)
+	case p2p.GET_WORK_MSG:
+		return p.handleGetWork(body)
+	case p2p.SUBMIT_WORK_MSG:
+		return p.handleSubmitWork(body)
+	case p2p.WORK_RESULT_MSG:
+		return p.handleWorkResult(body)
+	case p2p.ACCEPTED_BLOCK_MSG:
+		return p.handleAcceptedBlock(body)
+	case p2p.REWARD_MSG:
+		powLog.Info("received reward")
+	default:
+		p2pLog.Debug("unexpected message, skipping", logger.F("kind", msgKind.ToString()))
+	}
+	return nil
+}
+
+// only miners join pool
+func (p *PoolNode) handleJoin(raw []byte) error {
+	msg, err := decodeMsg[p2p.JoinMsg](raw)
+	if err != nil {
+		return err
+	}
+	if msg.Kind != p2p.MINER_NODE {
+		return nil
+	}
+
+	if !slices.ContainsFunc(p.peers, func(node p2p.NodeId) bool {
+		return strings.Compare(node.Ip, msg.From) == 0
+	}) {
+		p.AppendPeer(p2p.NodeId{Ip: msg.From, Kind: msg.Kind})
+		p2pLog.Info("miner joined the pool", logger.Peer(msg.From))
+	}
+
+	p.jobLock.Lock()
+	defer p.jobLock.Unlock()
+	if _, ok := p.slots[msg.From]; !ok {
+		p.slots[msg.From] = uint64(len(p.slots))
+	}
+	return nil
+}
+
+// pool takes work from executers only
+func (p *PoolNode) handleAddress(raw []byte) error {
+	msg, err := decodeMsg[p2p.AddressMsg](raw)
+	if err != nil {
+		return err
+	}
+	err = checkNodeKinds(msg)
+	if err != nil {
+		return err
+	}
+
+	peer := common.FindAll(msg.NodeList, func(node p2p.NodeId) bool {
+		return !isRendezvous(node) && !p.isSelf(node) &&
+			node.Kind == p2p.EXECUTER_NODE &&
+			p.PeerIndex(node) < 0
+	})
+	p2pLog.Debug("received peers", logger.Peer(msg.From.Ip), logger.F("count", len(peer)))
+	p.AppendPeer(peer...)
+	return nil
+}
+
+func (p *PoolNode) handleWork(raw []byte) error {
+	msg, err := decodeMsg[p2p.WorkMsg](raw)
+	if err != nil {
+		return err
+	}
+	if msg.From.Kind != p2p.EXECUTER_NODE {
+		return nil
+	}
+	if msg.Expiry < time.Now().UnixMilli() {
+		powLog.Debug("template is already expired", logger.F("template", msg.TemplateId), logger.Peer(msg.From.Ip))
+		return nil
+	}
+
+	p.addJob(msg)
+	return nil
+}
+
+func (p *PoolNode) handleGetWork(raw []byte) error {
+	msg, err := decodeMsg[p2p.GetWorkMsg](raw)
+	if err != nil {
+		return err
+	}
+	if msg.From.Kind != p2p.MINER_NODE || p.PeerIndex(msg.From) < 0 {
+		return nil
+	}
+
+	id, j := p.currentJob()
+	if j == nil {
+		// miner keeps asking
+		return nil
+	}
+	return p.sendWork(msg.From, id, j)
+}
+
+func (p *PoolNode) handleSubmitWork(raw []byte) error {
+	msg, err := decodeMsg[p2p.SubmitWorkMsg](raw)
+	if err != nil {
+		return err
+	}
+	if msg.From.Kind != p2p.MINER_NODE || p.PeerIndex(msg.From) < 0 {
+		return nil
+	}
+
+	p.jobLock.Lock()
+	j, ok := p.jobs[msg.TemplateId]
+	if !ok || j.expiry < time.Now().UnixMilli() {
+		p.jobLock.Unlock()
+		return p.sendWorkResult(
+			msg.From, msg.TemplateId, false, "unknown or stale job",
+		)
+	}
+	if msg.Nonce>>NONCE_SLOT_BITS != p.slots[msg.From.Ip] {
+		p.jobLock.Unlock()
+		return p.sendWorkResult(
+			msg.From, msg.TemplateId, false, "nonce is out of range",
+		)
+	}
+	if j.seen[msg.Nonce] {
+		p.jobLock.Unlock()
+		return p.sendWorkResult(
+			msg.From, msg.TemplateId, false, "duplicate share",
+		)
+	}
+	j.seen[msg.Nonce] = true
+	// miner can change only nonce
+	block := j.block
+	upstream := j.upstream
+	templateId := j.templateId
+	shareDifficulty := j.shareDifficulty
+	p.jobLock.Unlock()
+
+	block.Nonce = msg.Nonce
+	block.Hash = msg.Hash
+
+	ok, err = pow.NewProofOfWorkWithDifficulty(&block, shareDifficulty).Validate()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		powLog.Warn("invalid share", logger.Peer(msg.From.Ip), logger.F("job", msg.TemplateId))
+		return p.sendWorkResult(
+			msg.From, msg.TemplateId, false, "invalid share",
+		)
+	}
+
+	err = p.store.PutShare(&pool.Share{
+		Worker:     msg.From.Ip,
+		Height:     block.Height,
+		Difficulty: shareDifficulty,
+		Nonce:      block.Nonce,
+		Hash:       block.Hash,
+		Timestamp:  time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	powLog.Debug("accepted share", logger.Peer(msg.From.Ip), logger.F("job", msg.TemplateId))
+
+	ok, err = pow.NewProofOfWork(&block).Validate()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	powLog.Info(
+		"share solves block, submitting",
+		logger.Peer(msg.From.Ip),
+		logger.Height(block.Height),
+		logger.F("upstream", upstream.Ip),
+	)
+	p.jobLock.Lock()
+	p.submissions[submissionKey(upstream, templateId)] = &poolSubmission{
+		jobId:  msg.TemplateId,
+		finder: msg.From,
+		block:  block,
+	}
+	p.jobLock.Unlock()
+	return p.sendSubmitWork(upstream, templateId, block.Nonce, block.Hash)
+}
+
+func (p *PoolNode) handleWorkResult(raw []byte) error {
+	msg, err := decodeMsg[p2p.WorkResultMsg](raw)
+	if err != nil {
+		return err
+	}
+	if msg.From.Kind != p2p.EXECUTER_NODE {
+		return nil
+	}
+
+	key := submissionKey(msg.From, msg.TemplateId)
+	p.jobLock.Lock()
+	sub, ok := p.submissions[key]
+	delete(p.submissions, key)
+	p.jobLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if !msg.Accepted {
+		powLog.Warn(
+			"pool block is rejected",
+			logger.Height(sub.block.Height),
+			logger.Peer(msg.From.Ip),
+			logger.F("reason", msg.Reason),
+		)
+		return p.sendWorkResult(sub.finder, sub.jobId, false, msg.Reason)
+	}
+
+	powLog.Info(
+		"pool block is accepted",
+		logger.Height(sub.block.Height),
+		logger.Peer(msg.From.Ip),
+	)
+	err = p.recordBlock(sub)
+	if err != nil {
+		return err
+	}
+	return p.sendWorkResult(sub.finder, sub.jobId, true, "")
+}
+
+// stores found block and its payout report
+func (p *PoolNode) recordBlock(sub *poolSubmission) error {
+	found := pool.FoundBlock{
+		Height:    sub.block.Height,
+		Hash:      sub.block.Hash,
+		Finder:    sub.finder.Ip,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	err := p.store.PutFoundBlock(&found)
+	if err != nil {
+		return err
+	}
+
+	shares, err := p.store.GetRecentShares(pool.PPLNS_WINDOW)
+	if err != nil {
+		return err
+	}
+	report := pool.CalcPayouts(&found, shares)
+	err = p.store.PutPayoutReport(report)
+	if err != nil {
+		return err
+	}
+
+	for _, payout := range report.Payouts {
+		powLog.Info(
+			"payout",
+			logger.Height(report.Height),
+			logger.F("shares", report.Shares),
+			logger.F("worker", payout.Worker),
+			logger.F("ratio", payout.Ratio),
+		)
+	}
+	return nil
+}
+
+// relays to miners so that they stop stale jobs
+func (p *PoolNode) handleAcceptedBlock(raw []byte) error {
+	msg, err := decodeMsg[p2p.AcceptedBlockMsg](raw)
+	if err != nil {
+		return err
+	}
+	if msg.From.Kind != p2p.EXECUTER_NODE {
+		return nil
+	}
+
+	p.dropStaleJobs(msg.Block.Height)
+
+	msg.From = p.id
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := p2p.ACCEPTED_BLOCK_MSG.MakePayload(enc)
+	miners := common.FindAll(p.peers, func(id p2p.NodeId) bool {
+		return id.Kind == p2p.MINER_NODE
+	})
+	p.sendEach(miners, payload)
+	return nil
+}
+
+func (p *PoolNode) broadcastGetWork() error {
+	msg := p2p.GetWorkMsg{From: p.id}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	payload := p2p.GET_WORK_MSG.MakePayload(enc)
+	executers := common.FindAll(p.peers, func(id p2p.NodeId) bool {
+		return id.Kind == p2p.EXECUTER_NODE
+	})
+	p.sendEach(executers, payload)
+	return nil
+}
+
+func (p *PoolNode) sendWork(to p2p.NodeId, id uint64, j *poolJob) error {
+	p.jobLock.Lock()
+	slot := p.slots[to.Ip]
+	p.jobLock.Unlock()
+
+	from := slot << NONCE_SLOT_BITS
+	msg := p2p.WorkMsg{
+		From:            p.id,
+		TemplateId:      id,
+		Block:           j.block,
+		Expiry:          j.expiry,
+		ShareDifficulty: j.shareDifficulty,
+		NonceFrom:       from,
+		NonceTo:         from + 1<<NONCE_SLOT_BITS,
+	}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := p2p.WORK_MSG.MakePayload(enc)
+	return p.send(to, payload)
+}
+
+func (p *PoolNode) sendSubmitWork(
+	to p2p.NodeId, templateId uint64, nonce uint64, hash []byte,
+) error {
+	msg := p2p.SubmitWorkMsg{
+		From:       p.id,
+		TemplateId: templateId,
+		Nonce:      nonce,
+		Hash:       hash,
+	}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := p2p.SUBMIT_WORK_MSG.MakePayload(enc)
+	return p.send(to, payload)
+}
+
+func (p *PoolNode) sendWorkResult(
+	to p2p.NodeId, id uint64, accepted bool, reason string,
+) error {
+	msg := p2p.WorkResultMsg{
+		From:       p.id,
+		TemplateId: id,
+		Accepted:   accepted,
+		Reason:     reason,
+	}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := p2p.WORK_RESULT_MSG.MakePayload(enc)
+	return p.send(to, payload)
+}
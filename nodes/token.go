@@ -0,0 +1,197 @@
+package nodes
+
+import (
+	"bytes"
+	"errors"
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/blockchain"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/transactions"
+)
+
+func (e *ExecuterNode) executeToken(
+	cmdKind transactions.CommandKind,
+	raw []byte, sender []byte, nonce uint64,
+) error {
+	err := e.consumeNonce(sender, nonce)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Debug("executing", logger.F("command", cmdKind.ToString()))
+	switch cmdKind {
+	case transactions.TOKEN_CREATE_CMD:
+		cmd, err := common.Decode[transactions.TokenCreate](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeTokenCreate(cmd, sender, nonce)
+	case transactions.TOKEN_MINT_CMD:
+		cmd, err := common.Decode[transactions.TokenMint](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeTokenMint(cmd, sender)
+	case transactions.TOKEN_TRANSFER_CMD:
+		cmd, err := common.Decode[transactions.TokenTransfer](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeTokenTransfer(cmd, sender)
+	case transactions.TOKEN_BURN_CMD:
+		cmd, err := common.Decode[transactions.TokenBurn](raw)
+		if err != nil {
+			return err
+		}
+		return e.executeTokenBurn(cmd, sender)
+	default:
+		return errors.New("not a token command")
+	}
+}
+
+func (e *ExecuterNode) executeTokenCreate(
+	cmd *transactions.TokenCreate, sender []byte, nonce uint64,
+) error {
+	l := len(cmd.Symbol)
+	if l == 0 || l > transactions.MAX_SYMBOL_LENGTH {
+		return errors.New("invalid token symbol")
+	}
+	if cmd.Decimals > transactions.MAX_TOKEN_DECIMALS {
+		return errors.New("invalid token decimals")
+	}
+	if cmd.SupplyCap == 0 {
+		return errors.New("supply cap is zero")
+	}
+
+	id, err := blockchain.TokenId(sender, nonce)
+	if err != nil {
+		return err
+	}
+	authority := cmd.MintAuthority
+	if len(authority) == 0 {
+		authority = sender
+	}
+
+	stateLog.Debug("creating token", logger.F("symbol", cmd.Symbol), logger.HashOf("token", id))
+	e.emit(blocks.EVENT_TOKEN_CREATED, sender, id)
+	return e.PutToken(&blockchain.Token{
+		Id:            id,
+		Symbol:        cmd.Symbol,
+		Decimals:      cmd.Decimals,
+		SupplyCap:     cmd.SupplyCap,
+		Supply:        0,
+		MintAuthority: authority,
+	})
+}
+
+func (e *ExecuterNode) executeTokenMint(
+	cmd *transactions.TokenMint, sender []byte,
+) error {
+	token, err := e.getTokenSafe(cmd.TokenId)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sender, token.MintAuthority) {
+		return errors.New("sender is not mint authority")
+	}
+	if cmd.Amount > token.SupplyCap-token.Supply {
+		return errors.New("supply cap is exceeded")
+	}
+
+	err = e.addTokenBalance(cmd.TokenId, cmd.To, cmd.Amount)
+	if err != nil {
+		return err
+	}
+	token.Supply += cmd.Amount
+	stateLog.Debug(
+		"minted token",
+		logger.F("symbol", token.Symbol),
+		logger.F("amount", cmd.Amount),
+		logger.F("supply", token.Supply),
+	)
+	return e.PutToken(token)
+}
+
+func (e *ExecuterNode) executeTokenTransfer(
+	cmd *transactions.TokenTransfer, sender []byte,
+) error {
+	token, err := e.getTokenSafe(cmd.TokenId)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(sender, cmd.To) {
+		return errors.New("invalid public keys")
+	}
+
+	err = e.subtractTokenBalance(cmd.TokenId, sender, cmd.Amount)
+	if err != nil {
+		return err
+	}
+	stateLog.Debug("transfering token", logger.F("symbol", token.Symbol), logger.F("amount", cmd.Amount))
+	return e.addTokenBalance(cmd.TokenId, cmd.To, cmd.Amount)
+}
+
+func (e *ExecuterNode) executeTokenBurn(
+	cmd *transactions.TokenBurn, sender []byte,
+) error {
+	token, err := e.getTokenSafe(cmd.TokenId)
+	if err != nil {
+		return err
+	}
+
+	err = e.subtractTokenBalance(cmd.TokenId, sender, cmd.Amount)
+	if err != nil {
+		return err
+	}
+	token.Supply -= cmd.Amount
+	stateLog.Debug(
+		"burned token",
+		logger.F("symbol", token.Symbol),
+		logger.F("amount", cmd.Amount),
+		logger.F("supply", token.Supply),
+	)
+	return e.PutToken(token)
+}
+
+func (e *ExecuterNode) getTokenSafe(id []byte) (*blockchain.Token, error) {
+	token, err := e.GetToken(id)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, errors.New("token does not exist")
+	}
+	return token, nil
+}
+
+// balance can not exceed supply cap, so this is only a guard
+func (e *ExecuterNode) addTokenBalance(
+	id []byte, pubKey []byte, amount uint64,
+) error {
+	if !accounts.IsAccountKey(pubKey) {
+		return ErrInvalidAccountKey
+	}
+	balance, err := e.GetTokenBalance(id, pubKey)
+	if err != nil {
+		return err
+	}
+	if amount > ^uint64(0)-balance {
+		return errors.New("overflow")
+	}
+	return e.PutTokenBalance(id, pubKey, balance+amount)
+}
+
+func (e *ExecuterNode) subtractTokenBalance(
+	id []byte, pubKey []byte, amount uint64,
+) error {
+	balance, err := e.GetTokenBalance(id, pubKey)
+	if err != nil {
+		return err
+	}
+	if amount > balance {
+		return errors.New("underflow")
+	}
+	return e.PutTokenBalance(id, pubKey, balance-amount)
+}
@@ -1,10 +1,10 @@
 package nodes
 
 import (
-	"io"
+	"context"
 	"log"
-	"net"
 	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/p2p"
 	"simple-blockchain-go/transactions"
 	"simple-blockchain-go/wallets"
@@ -21,15 +21,20 @@ const (
 type WalletNode struct {
 	Node
 	accounts map[string]*wallets.Wallet
+	// token ids to query balances of
+	tokens [][]byte
+	// nil when metrics are disabled
+	stats *walletMetrics
 }
 
-func NewWalletNode(port string) (*WalletNode, error) {
+func NewWalletNode(port string, tokens [][]byte) (*WalletNode, error) {
 	w := WalletNode{
 		Node: Node{
 			id:      p2p.NewNodeId(port, p2p.WALLET_NODE),
 			version: 1,
 		},
 		accounts: make(map[string]*wallets.Wallet),
+		tokens:   tokens,
 	}
 	for i := 0; i < NUM_ACCOUNTS; i++ {
 		wallet, err := wallets.NewWallet(port, strconv.Itoa(i))
@@ -43,13 +48,18 @@ func NewWalletNode(port string) (*WalletNode, error) {
 	return &w, nil
 }
 
-func (w *WalletNode) Run() error {
-	listener, err := net.Listen(p2p.TCP, string(w.id.Ip))
+func (w *WalletNode) Run(ctx context.Context) error {
+	w.start(ctx)
+	defer w.cancel()
+
+	listener, err := w.listen()
 	if err != nil {
 		return err
 	}
 	defer listener.Close()
-	log.Printf("wallet node is listening at %s", w.id.Ip)
+	p2pLog.Info("wallet node is listening", logger.Peer(w.id.Ip))
+
+	w.startMetrics()
 
 	err = w.broadcastJoin()
 	if err != nil {
@@ -61,61 +71,87 @@ func (w *WalletNode) Run() error {
 	if err != nil {
 		return err
 	}
-
-	go w.startSendingAirdropTransactions()
-
-	for {
-		conn, err := listener.Accept()
+	for _, token := range w.tokens {
+		err = w.sendTokenAccount(p, token)
 		if err != nil {
 			return err
 		}
-
-		go w.handleConnection(conn)
 	}
-}
 
-func (w *WalletNode) handleConnection(conn net.Conn) {
-	request, err := io.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer conn.Close()
+	w.spawn("airdrop", w.startSendingAirdropTransactions)
 
-	msgKind := p2p.MessageKind(request[0])
-	log.Printf("received msg '%s'\n", msgKind.ToString())
+	err = w.serve(w.handleMessage)
+	w.shutdown(nil)
+	return err
+}
 
+func (w *WalletNode) handleMessage(msgKind p2p.MessageKind, body []byte) error {
 	switch msgKind {
 	case p2p.ADDRESS_MSG:
-		err = w.handleAddress(request[1:])
+		return w.handleAddress(body)
 	case p2p.ACCOUNT_INFO_MSG:
-		err = w.handleAccountInfo(request[1:])
+		return w.handleAccountInfo(body)
+	case p2p.TOKEN_ACCOUNT_INFO_MSG:
+		return w.handleTokenAccountInfo(body)
 	default:
-		log.Println("unknown message skipping...")
+		p2pLog.Debug("unexpected message, skipping", logger.F("kind", msgKind.ToString()))
 	}
+	return nil
+}
+
+func (w *WalletNode) handleAccountInfo(raw []byte) error {
+	msg, err := decodeMsg[p2p.AccountInfoMsg](raw)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+	key := base58.Encode(msg.PublicKey)
+	account, ok := w.accounts[key]
+	if !ok {
+		return peerFault(ErrUnknownAccount)
+	}
+	stateLog.Info(
+		"account info",
+		logger.F("account", key),
+		logger.F("balance", msg.Balance),
+		logger.F("nonce", msg.Nance),
+	)
+	account.Nonce = msg.Nance
+	account.Balance = msg.Balance
+	return nil
 }
 
-func (w *WalletNode) handleAccountInfo(raw []byte) error {
-	msg, err := common.Decode[p2p.AccountInfoMsg](raw)
+func (w *WalletNode) handleTokenAccountInfo(raw []byte) error {
+	msg, err := decodeMsg[p2p.TokenAccountInfoMsg](raw)
 	if err != nil {
 		return err
 	}
 	key := base58.Encode(msg.PublicKey)
-	log.Printf(
-		"account %s balance: %d, nonce:%d\n",
-		key, msg.Balance, msg.Nance,
+	tokenId := base58.Encode(msg.TokenId)
+	account, ok := w.accounts[key]
+	if !ok {
+		return peerFault(ErrUnknownAccount)
+	}
+	stateLog.Info(
+		"token account info",
+		logger.F("account", key),
+		logger.F("token", tokenId),
+		logger.F("symbol", msg.Symbol),
+		logger.F("balance", msg.Balance),
+		logger.F("decimals", msg.Decimals),
 	)
-	w.accounts[key].Nonce = msg.Nance
-	w.accounts[key].Balance = msg.Balance
+	account.Tokens[tokenId] = msg.Balance
 	return nil
 }
 
 func (w *WalletNode) startSendingAirdropTransactions() {
 	ticker := time.NewTicker(time.Millisecond * 1000)
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.ctx.Done():
+			return
+		}
 		if w.PeerLen() <= 1 {
 			continue
 		}
@@ -144,10 +180,11 @@ func (w *WalletNode) startSendingAirdropTransactions() {
 			}
 
 			p, _ := w.GetPeer(0)
-			log.Printf("sending airdrop transaction to %s\n", p.Ip)
+			mempoolLog.Debug("sending airdrop transaction", logger.Peer(p.Ip), logger.Hash(tx.Hash[:]))
 			err = w.sendTxMessage(p, &tx)
 			if err != nil {
-				log.Panic(err)
+				w.handleLocalFault("sending airdrop transaction failed", err)
+				continue
 			}
 			a.Nonce++
 		}
@@ -171,6 +208,7 @@ func (w *WalletNode) sendTxMessage(
 	if err != nil {
 		return err
 	}
+	w.stats.countTxSent()
 	return nil
 }
 
@@ -198,3 +236,29 @@ func (w *WalletNode) sendAccount(to p2p.NodeId) error {
 	}
 	return nil
 }
+
+func (w *WalletNode) sendTokenAccount(to p2p.NodeId, tokenId []byte) error {
+	for _, a := range w.accounts {
+		msg := p2p.TokenAccountMsg{
+			From:      w.id,
+			PublicKey: a.PublicKey(),
+			TokenId:   tokenId,
+			Signature: nil,
+		}
+		content, err := common.Encode(msg.From)
+		if err != nil {
+			return err
+		}
+		msg.Signature = a.QuickSign(content)
+		enc, err := common.Encode(msg)
+		if err != nil {
+			return err
+		}
+		payload := p2p.TOKEN_ACCOUNT_MSG.MakePayload(enc)
+		err = w.send(to, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package nodes
+
+import (
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/p2p"
+	"time"
+)
+
+const (
+	// milli seconds
+	TEMPLATE_TTL = 60_000
+)
+
+type workTemplate struct {
+	block    blocks.Block
+	receipts []blocks.Receipt
+	expiry   int64
+}
+
+// templates of previous height are dropped,
+// they can never be valid again
+func (e *ExecuterNode) addWorkTemplate(
+	block *blocks.Block, receipts []blocks.Receipt,
+) {
+	e.workLock.Lock()
+	defer e.workLock.Unlock()
+
+	for id, t := range e.templates {
+		if t.block.Height < block.Height {
+			delete(e.templates, id)
+		}
+	}
+	e.nextTemplateId++
+	e.templates[e.nextTemplateId] = &workTemplate{
+		block:    *block,
+		receipts: receipts,
+		expiry:   time.Now().UnixMilli() + TEMPLATE_TTL,
+	}
+	powLog.Info(
+		"work template is ready",
+		logger.F("template", e.nextTemplateId),
+		logger.Height(block.Height),
+	)
+}
+
+func (t *workTemplate) isExpired() bool {
+	return t.expiry < time.Now().UnixMilli()
+}
+
+// latest template, which might be expired
+func (e *ExecuterNode) currentWorkTemplate() (uint64, *workTemplate) {
+	e.workLock.Lock()
+	defer e.workLock.Unlock()
+
+	t, ok := e.templates[e.nextTemplateId]
+	if !ok {
+		return 0, nil
+	}
+	return e.nextTemplateId, t
+}
+
+func (e *ExecuterNode) takeWorkTemplate(id uint64) (*workTemplate, string) {
+	e.workLock.Lock()
+	defer e.workLock.Unlock()
+
+	t, ok := e.templates[id]
+	if !ok {
+		return nil, "unknown or stale template"
+	}
+	if t.isExpired() {
+		// state of its block is rolled back on next get work
+		return nil, "template is expired"
+	}
+	return t, ""
+}
+
+func (e *ExecuterNode) clearWorkTemplates() {
+	e.workLock.Lock()
+	defer e.workLock.Unlock()
+	e.templates = map[uint64]*workTemplate{}
+}
+
+// state of pending template's block is rolled back and its transactions
+// go back to pool, caller holds chain lock,
+// false when there was no pending template
+func (e *ExecuterNode) abandonWorkTemplate() (bool, error) {
+	id, t := e.currentWorkTemplate()
+	if t == nil {
+		return false, nil
+	}
+	e.clearWorkTemplates()
+	if !e.InBlock() {
+		return true, nil
+	}
+	err := e.RollbackBlock()
+	if err != nil {
+		return true, err
+	}
+	for _, tx := range t.block.Bundle.Transactions {
+		e.txPool.Append(&tx)
+	}
+	powLog.Info(
+		"work template is abandoned",
+		logger.F("template", id),
+		logger.Height(t.block.Height),
+		logger.F("txs", len(t.block.Bundle.Transactions)),
+	)
+	return true, nil
+}
+
+// nobody solved template in time, next epoch builds new one
+func (e *ExecuterNode) expireWorkTemplate(id uint64) error {
+	abandoned, err := func() (bool, error) {
+		e.Lock()
+		defer e.Unlock()
+		// other handler might have done it already
+		current, t := e.currentWorkTemplate()
+		if t == nil || current != id {
+			return false, nil
+		}
+		return e.abandonWorkTemplate()
+	}()
+	if err != nil || !abandoned {
+		return err
+	}
+	e.epoch.Trigger()
+	return nil
+}
+
+// miners and pools in front of miners
+func isWorker(node p2p.NodeId) bool {
+	return node.Kind == p2p.MINER_NODE || node.Kind == p2p.POOL_NODE
+}
+
+func (e *ExecuterNode) handleGetWork(raw []byte) error {
+	msg, err := decodeMsg[p2p.GetWorkMsg](raw)
+	if err != nil {
+		return err
+	}
+	if !isWorker(msg.From) {
+		return nil
+	}
+
+	id, t := e.currentWorkTemplate()
+	if t == nil {
+		// miner keeps asking
+		return nil
+	}
+	if t.isExpired() {
+		return e.expireWorkTemplate(id)
+	}
+	return e.sendWork(msg.From, id, t)
+}
+
+func (e *ExecuterNode) handleSubmitWork(raw []byte) error {
+	e.Lock()
+	defer e.Unlock()
+
+	msg, err := decodeMsg[p2p.SubmitWorkMsg](raw)
+	if err != nil {
+		return err
+	}
+	if !isWorker(msg.From) {
+		return nil
+	}
+
+	t, reason := e.takeWorkTemplate(msg.TemplateId)
+	if t == nil {
+		return e.sendWorkResult(msg.From, msg.TemplateId, false, reason)
+	}
+
+	// miner can change only nonce
+	block := t.block
+	block.Nonce = msg.Nonce
+	block.Hash = msg.Hash
+
+	ok, err := e.VerifyBlock(&block)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		powLog.Warn("submitted solution is invalid", logger.Peer(msg.From.Ip), logger.F("template", msg.TemplateId))
+		return e.sendWorkResult(
+			msg.From, msg.TemplateId, false, "invalid solution",
+		)
+	}
+
+	err = e.commitBlock(&block, t.receipts, BLOCK_SOURCE_WORK)
+	if err != nil {
+		return err
+	}
+	e.clearWorkTemplates()
+
+	err = e.sendWorkResult(msg.From, msg.TemplateId, true, "")
+	if err != nil {
+		return err
+	}
+	// send reward only to accepted miner
+	err = e.sendReward(msg.From)
+	if err != nil {
+		return err
+	}
+
+	// start new epoch routine,
+	// not waited for because epoch routine may be waiting for the lock
+	go e.epoch.Trigger()
+
+	return e.broadcastAcceptedBlock(&block)
+}
+
+func (e *ExecuterNode) sendWork(
+	to p2p.NodeId, id uint64, t *workTemplate,
+) error {
+	msg := p2p.WorkMsg{
+		From:       e.id,
+		TemplateId: id,
+		Block:      t.block,
+		Expiry:     t.expiry,
+	}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := p2p.WORK_MSG.MakePayload(enc)
+	return e.send(to, payload)
+}
+
+func (e *ExecuterNode) sendWorkResult(
+	to p2p.NodeId, id uint64, accepted bool, reason string,
+) error {
+	msg := p2p.WorkResultMsg{
+		From:       e.id,
+		TemplateId: id,
+		Accepted:   accepted,
+		Reason:     reason,
+	}
+	enc, err := common.Encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := p2p.WORK_RESULT_MSG.MakePayload(enc)
+	return e.send(to, payload)
+}
@@ -10,8 +10,8 @@ type MessageKind byte
 
 const (
 	ADDRESS_MSG MessageKind = iota + 1
-	OFFER_BLOCK_MSG
-	REGISTER_BLOCK_MSG
+	WORK_MSG
+	SUBMIT_WORK_MSG
 	ACCEPTED_BLOCK_MSG
 	REWARD_MSG
 	SYNC_BLOCK_REQUEST_MSG
@@ -23,6 +23,12 @@ const (
 	TX_MSG
 	TX_POOL_MSG
 	JOIN_MSG
+	FINALITY_MSG
+	FINALITY_INFO_MSG
+	GET_WORK_MSG
+	WORK_RESULT_MSG
+	TOKEN_ACCOUNT_MSG
+	TOKEN_ACCOUNT_INFO_MSG
 )
 
 func (mk MessageKind) MakePayload(data []byte) []byte {
@@ -32,14 +38,18 @@ func (mk MessageKind) MakePayload(data []byte) []byte {
 	return bs
 }
 
+func (mk MessageKind) IsValid() bool {
+	return mk >= ADDRESS_MSG && mk <= TOKEN_ACCOUNT_INFO_MSG
+}
+
 func (mk MessageKind) ToString() string {
 	switch mk {
 	case ADDRESS_MSG:
 		return "address message"
-	case OFFER_BLOCK_MSG:
-		return "offer block message"
-	case REGISTER_BLOCK_MSG:
-		return "register block message"
+	case WORK_MSG:
+		return "work message"
+	case SUBMIT_WORK_MSG:
+		return "submit work message"
 	case ACCEPTED_BLOCK_MSG:
 		return "accepted block message"
 	case SYNC_BLOCK_REQUEST_MSG:
@@ -62,6 +72,18 @@ func (mk MessageKind) ToString() string {
 		return "tx pool message"
 	case JOIN_MSG:
 		return "join message"
+	case FINALITY_MSG:
+		return "finality message"
+	case FINALITY_INFO_MSG:
+		return "finality info message"
+	case GET_WORK_MSG:
+		return "get work message"
+	case WORK_RESULT_MSG:
+		return "work result message"
+	case TOKEN_ACCOUNT_MSG:
+		return "token account message"
+	case TOKEN_ACCOUNT_INFO_MSG:
+		return "token account info message"
 	default:
 		log.Panicf("unknown value %d", mk)
 	}
@@ -80,14 +102,37 @@ type BlockchainInfoMsg struct {
 	PreviousBlockHash []byte
 }
 
-type OfferBlockMsg struct {
-	From  NodeId
-	Block blocks.Block
+// miner asks executer for block template
+type GetWorkMsg struct {
+	From NodeId
 }
 
-type RegisterBlockMsg struct {
-	From  NodeId
-	Block blocks.Block
+// block template to be mined,
+// Expiry is unix milli after which solution is not accepted,
+// ShareDifficulty and nonce range are set by pools,
+// 0 difficulty means only full solution over whole nonce space
+type WorkMsg struct {
+	From            NodeId
+	TemplateId      uint64
+	Block           blocks.Block
+	Expiry          int64
+	ShareDifficulty byte
+	NonceFrom       uint64
+	NonceTo         uint64
+}
+
+type SubmitWorkMsg struct {
+	From       NodeId
+	TemplateId uint64
+	Nonce      uint64
+	Hash       []byte
+}
+
+type WorkResultMsg struct {
+	From       NodeId
+	TemplateId uint64
+	Accepted   bool
+	Reason     string
 }
 
 type AcceptedBlockMsg struct {
@@ -126,6 +171,23 @@ type AccountInfoMsg struct {
 	Nance     uint64
 }
 
+// account message for balance of a token
+type TokenAccountMsg struct {
+	From      NodeId
+	PublicKey []byte
+	TokenId   []byte
+	Signature []byte
+}
+
+type TokenAccountInfoMsg struct {
+	From      NodeId
+	PublicKey []byte
+	TokenId   []byte
+	Symbol    string
+	Decimals  byte
+	Balance   uint64
+}
+
 type TransactionMsg struct {
 	From        NodeId
 	Transaction transactions.Transaction
@@ -141,3 +203,17 @@ type JoinMsg struct {
 	Version byte
 	Kind    NodeKind
 }
+
+// asks whether block at height is irreversible
+type FinalityMsg struct {
+	From   NodeId
+	Height uint64
+}
+
+type FinalityInfoMsg struct {
+	From            NodeId
+	Height          uint64
+	Hash            []byte
+	IsFinal         bool
+	FinalizedHeight uint64
+}
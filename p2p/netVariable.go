@@ -2,7 +2,6 @@ package p2p
 
 import (
 	"fmt"
-	"log"
 	"strings"
 )
 
@@ -18,8 +17,14 @@ const (
 	EXECUTER_NODE NodeKind = iota + 1
 	MINER_NODE
 	WALLET_NODE
+	POOL_NODE
 )
 
+func (nk NodeKind) IsValid() bool {
+	return nk >= EXECUTER_NODE && nk <= POOL_NODE
+}
+
+// kinds come from peers, so unknown one is not a bug of this node
 func (nk NodeKind) ToString() string {
 	switch nk {
 	case EXECUTER_NODE:
@@ -28,9 +33,10 @@ func (nk NodeKind) ToString() string {
 		return "miner node"
 	case WALLET_NODE:
 		return "wallet node"
+	case POOL_NODE:
+		return "pool node"
 	default:
-		log.Panicf("unknown value %d", nk)
-		return ""
+		return "unknown"
 	}
 }
 
@@ -0,0 +1,269 @@
+package poa
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/consensus"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/wallets"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+var consensusLog = logger.New(logger.CONSENSUS)
+
+const (
+	DIFF_IN_TURN byte = 2
+	DIFF_NO_TURN byte = 1
+	// seconds, minimum interval of blocks
+	BLOCK_PERIOD int64 = 5
+	// seconds, out of turn signer waits in turn signer this long
+	NO_TURN_TIMEOUT int64 = 10
+	SIGNER_KEY            = "signer"
+)
+
+var (
+	ErrNotSigner        = errors.New("local key is not authorized signer")
+	ErrRecentlySigned   = errors.New("local signer signed recently")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// proof of authority,
+// authorized signers take turns to sign blocks
+type Engine struct {
+	genesisSigners [][]byte
+	// nil when this node does not sign
+	signer *wallets.Wallet
+}
+
+func NewEngine(genesisSigners []string, signer *wallets.Wallet) (*Engine, error) {
+	signers := make([][]byte, 0, len(genesisSigners))
+	for _, s := range genesisSigners {
+		pubKey := base58.Decode(s)
+		if len(pubKey) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid signer public key in genesis config")
+		}
+		signers = append(signers, pubKey)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("genesis config has no signer")
+	}
+
+	if signer != nil {
+		consensusLog.Info("local signer", logger.F("signer", base58.Encode(signer.PublicKey())))
+	}
+	return &Engine{
+		genesisSigners: signers,
+		signer:         signer,
+	}, nil
+}
+
+func (e *Engine) Name() string {
+	return consensus.POA_ENGINE
+}
+
+func inTurnSigner(signers [][]byte, height uint64) []byte {
+	return signers[height%uint64(len(signers))]
+}
+
+func calcDifficulty(signers [][]byte, height uint64, signer []byte) byte {
+	if bytes.Equal(inTurnSigner(signers, height), signer) {
+		return DIFF_IN_TURN
+	}
+	return DIFF_NO_TURN
+}
+
+// a signer can sign only one of consecutive floor(n/2)+1 blocks
+func signedRecently(
+	chain consensus.ChainReader, signers [][]byte, signer []byte,
+) (bool, error) {
+	window, err := chain.GetRecentHeaders(len(signers) / 2)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range window {
+		if bytes.Equal(h.Coinbase, signer) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (e *Engine) VerifyHeader(
+	chain consensus.ChainReader, header *blocks.BlockHeader, hash []byte,
+) (bool, error) {
+	if header.Nonce != 0 {
+		consensusLog.Warn("poa header has nonce", logger.Height(header.Height))
+		return false, nil
+	}
+
+	signers, err := e.GetSigners(chain)
+	if err != nil {
+		return false, err
+	}
+	if !containsKey(signers, header.Coinbase) {
+		consensusLog.Warn(
+			"block is signed by unauthorized key",
+			logger.Height(header.Height),
+			logger.F("signer", base58.Encode(header.Coinbase)),
+		)
+		return false, nil
+	}
+
+	sealHash, err := header.SealHash()
+	if err != nil {
+		return false, err
+	}
+	if !ed25519.Verify(header.Coinbase, sealHash, header.Signature) {
+		consensusLog.Warn("block signature is invalid", logger.Height(header.Height), logger.Err(ErrInvalidSignature))
+		return false, nil
+	}
+
+	expected := calcDifficulty(signers, header.Height, header.Coinbase)
+	if header.Difficulty != expected {
+		consensusLog.Warn(
+			"block difficulty is invalid",
+			logger.Height(header.Height),
+			logger.F("difficulty", header.Difficulty),
+			logger.F("expected", expected),
+		)
+		return false, nil
+	}
+
+	recent, err := signedRecently(chain, signers, header.Coinbase)
+	if err != nil {
+		return false, err
+	}
+	if recent {
+		consensusLog.Warn(
+			"block is signed by recently signed signer",
+			logger.Height(header.Height),
+			logger.F("signer", base58.Encode(header.Coinbase)),
+		)
+		return false, nil
+	}
+	return true, nil
+}
+
+// difficulty of block signed by local signer
+func (e *Engine) CalcDifficulty(chain consensus.ChainReader) (byte, error) {
+	if e.signer == nil {
+		return DIFF_NO_TURN, nil
+	}
+	signers, err := e.GetSigners(chain)
+	if err != nil {
+		return 0, err
+	}
+	height, err := nextHeight(chain)
+	if err != nil {
+		return 0, err
+	}
+	return calcDifficulty(signers, height, e.signer.PublicKey()), nil
+}
+
+func nextHeight(chain consensus.ChainReader) (uint64, error) {
+	window, err := chain.GetRecentHeaders(1)
+	if err != nil {
+		return 0, err
+	}
+	if len(window) == 0 {
+		// next of genesis
+		return 1, nil
+	}
+	return window[0].Height + 1, nil
+}
+
+func (e *Engine) CanSeal(chain consensus.ChainReader) (bool, error) {
+	if e.signer == nil {
+		return false, nil
+	}
+	signers, err := e.GetSigners(chain)
+	if err != nil {
+		return false, err
+	}
+	if !containsKey(signers, e.signer.PublicKey()) {
+		consensusLog.Debug("can not seal", logger.Err(ErrNotSigner))
+		return false, nil
+	}
+	recent, err := signedRecently(chain, signers, e.signer.PublicKey())
+	if err != nil {
+		return false, err
+	}
+	if recent {
+		consensusLog.Debug("can not seal", logger.Err(ErrRecentlySigned))
+		return false, nil
+	}
+
+	window, err := chain.GetRecentHeaders(1)
+	if err != nil {
+		return false, err
+	}
+	if len(window) == 0 {
+		// anyone can start
+		return true, nil
+	}
+	head := window[0]
+	elapsed := time.Now().Unix() - head.Timestamp
+	if elapsed < BLOCK_PERIOD {
+		return false, nil
+	}
+	if bytes.Equal(inTurnSigner(signers, head.Height+1), e.signer.PublicKey()) {
+		return true, nil
+	}
+	// in turn signer seems to be gone
+	return elapsed > NO_TURN_TIMEOUT, nil
+}
+
+func (e *Engine) Prepare(
+	chain consensus.ChainReader, header *blocks.BlockHeader,
+) error {
+	if e.signer == nil {
+		return consensus.ErrNotSealer
+	}
+	signers, err := e.GetSigners(chain)
+	if err != nil {
+		return err
+	}
+	header.Coinbase = e.signer.PublicKey()
+	header.Difficulty = calcDifficulty(signers, header.Height, header.Coinbase)
+	header.Nonce = 0
+	return nil
+}
+
+func (e *Engine) IsRemoteSealing() bool {
+	return false
+}
+
+func (e *Engine) Seal(ctx context.Context, block *blocks.Block) error {
+	if e.signer == nil {
+		return ErrNotSigner
+	}
+	if !bytes.Equal(block.Coinbase, e.signer.PublicKey()) {
+		return errors.New("block is not prepared by local signer")
+	}
+
+	sealHash, err := block.SealHash()
+	if err != nil {
+		return err
+	}
+	block.Signature = e.signer.QuickSign(sealHash)
+	hash, err := block.CalcHash()
+	if err != nil {
+		return err
+	}
+	block.Hash = hash
+	return nil
+}
+
+// signers are not rewarded
+func (e *Engine) Finalize(
+	chain consensus.ChainReader,
+	state consensus.StateWriter,
+	block *blocks.Block,
+) error {
+	return nil
+}
@@ -0,0 +1,137 @@
+package poa
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/consensus"
+	"simple-blockchain-go/logger"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// state keys, prefixed not to collide with account keys
+	SIGNERS_KEY      = "poa:signers"
+	VOTES_KEY_PREFIX = "poa:votes:"
+)
+
+// votes for adding or removing a candidate
+type Tally struct {
+	Authorize bool
+	Voters    [][]byte
+}
+
+func votesKey(candidate []byte) []byte {
+	return append([]byte(VOTES_KEY_PREFIX), candidate...)
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	return slices.ContainsFunc(keys, func(k []byte) bool {
+		return bytes.Equal(k, key)
+	})
+}
+
+// current signers, genesis signers until first vote passes
+func (e *Engine) GetSigners(chain consensus.ChainReader) ([][]byte, error) {
+	enc, err := chain.GetStateValue([]byte(SIGNERS_KEY))
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return e.genesisSigners, nil
+	}
+	signers, err := common.Decode[[][]byte](enc)
+	if err != nil {
+		return nil, err
+	}
+	return *signers, nil
+}
+
+// applied when transaction is executed.
+// candidate is added or removed when more than half of signers vote
+func (e *Engine) Vote(
+	chain consensus.ChainReader,
+	state consensus.StateWriter,
+	voter []byte, candidate []byte, authorize bool,
+) error {
+	signers, err := e.GetSigners(chain)
+	if err != nil {
+		return err
+	}
+	if !containsKey(signers, voter) {
+		return errors.New("voter is not a signer")
+	}
+	if len(candidate) != ed25519.PublicKeySize {
+		return errors.New("candidate is not a public key")
+	}
+	isSigner := containsKey(signers, candidate)
+	if authorize == isSigner {
+		return errors.New("vote does not change signers")
+	}
+
+	tally := &Tally{Authorize: authorize}
+	enc, err := chain.GetStateValue(votesKey(candidate))
+	if err != nil {
+		return err
+	}
+	if enc != nil {
+		tally, err = common.Decode[Tally](enc)
+		if err != nil {
+			return err
+		}
+		// votes for opposite direction are discarded
+		if tally.Authorize != authorize {
+			tally = &Tally{Authorize: authorize}
+		}
+	}
+	// voters which are no longer signers do not count
+	tally.Voters = common.FindAll(tally.Voters, func(v []byte) bool {
+		return containsKey(signers, v)
+	})
+	if !containsKey(tally.Voters, voter) {
+		tally.Voters = append(tally.Voters, voter)
+	}
+
+	if len(tally.Voters) <= len(signers)/2 {
+		enc, err = common.Encode(tally)
+		if err != nil {
+			return err
+		}
+		return state.PutStateValue(votesKey(candidate), enc)
+	}
+
+	// passed
+	if authorize {
+		signers = append(append([][]byte{}, signers...), candidate)
+	} else {
+		signers = common.FindAll(signers, func(s []byte) bool {
+			return !bytes.Equal(s, candidate)
+		})
+		if len(signers) == 0 {
+			return errors.New("can not remove last signer")
+		}
+	}
+
+	enc, err = common.Encode(signers)
+	if err != nil {
+		return err
+	}
+	err = state.PutStateValue([]byte(SIGNERS_KEY), enc)
+	if err != nil {
+		return err
+	}
+	if authorize {
+		consensusLog.Info("signer is authorized", logger.F("signer", base58.Encode(candidate)))
+	} else {
+		consensusLog.Info("signer is removed", logger.F("signer", base58.Encode(candidate)))
+	}
+	// empty tally is kept, deleting is not supported by state writer
+	enc, err = common.Encode(Tally{Authorize: authorize})
+	if err != nil {
+		return err
+	}
+	return state.PutStateValue(votesKey(candidate), enc)
+}
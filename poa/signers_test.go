@@ -0,0 +1,175 @@
+package poa
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"simple-blockchain-go/blocks"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// chain without blocks, state only
+type memState map[string][]byte
+
+func (s memState) GetRecentHeaders(n int) ([]blocks.BlockHeader, error) {
+	return nil, nil
+}
+
+func (s memState) GetBlockByHeight(height uint64) (*blocks.Block, error) {
+	return nil, nil
+}
+
+func (s memState) GetStateValue(key []byte) ([]byte, error) {
+	return s[string(key)], nil
+}
+
+func (s memState) AddBalance(pubKey []byte, amount uint64) error {
+	return nil
+}
+
+func (s memState) PutStateValue(key []byte, value []byte) error {
+	s[string(key)] = value
+	return nil
+}
+
+func newKeys(t *testing.T, n int) [][]byte {
+	var keys [][]byte
+	for i := 0; i < n; i++ {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, pub)
+	}
+	return keys
+}
+
+func newTestEngine(t *testing.T, signers [][]byte) *Engine {
+	var encoded []string
+	for _, s := range signers {
+		encoded = append(encoded, base58.Encode(s))
+	}
+	e, err := NewEngine(encoded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func signerCount(t *testing.T, e *Engine, state memState) int {
+	signers, err := e.GetSigners(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(signers)
+}
+
+func TestVotePassesWithMajority(t *testing.T) {
+	keys := newKeys(t, 4)
+	signers, candidate := keys[:3], keys[3]
+	e := newTestEngine(t, signers)
+	state := memState{}
+
+	err := e.Vote(state, state, signers[0], candidate, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// same voter counts once
+	err = e.Vote(state, state, signers[0], candidate, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := signerCount(t, e, state); n != 3 {
+		t.Fatalf("signers after one vote: %d", n)
+	}
+
+	err = e.Vote(state, state, signers[1], candidate, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := signerCount(t, e, state); n != 4 {
+		t.Fatalf("signers after majority: %d", n)
+	}
+}
+
+// votes which added candidate do not count for removing it
+func TestAddedSignerIsRemovedWithFreshVotes(t *testing.T) {
+	signers := newKeys(t, 3)
+	e := newTestEngine(t, signers)
+	state := memState{}
+
+	candidate := newKeys(t, 1)[0]
+	err := e.Vote(state, state, signers[0], candidate, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Vote(state, state, signers[1], candidate, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := signerCount(t, e, state); n != 4 {
+		t.Fatalf("signers: %d", n)
+	}
+
+	err = e.Vote(state, state, signers[0], candidate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Vote(state, state, signers[1], candidate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := signerCount(t, e, state); n != 4 {
+		t.Fatalf("removed with 2 of 4 votes, signers: %d", n)
+	}
+	err = e.Vote(state, state, signers[2], candidate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := signerCount(t, e, state); n != 3 {
+		t.Fatalf("signers after removal: %d", n)
+	}
+}
+
+func TestVoteIsRefused(t *testing.T) {
+	keys := newKeys(t, 3)
+	signers, outsider := keys[:2], keys[2]
+	e := newTestEngine(t, signers)
+	state := memState{}
+
+	cases := []struct {
+		name      string
+		voter     []byte
+		candidate []byte
+		authorize bool
+	}{
+		{"voter is not signer", outsider, outsider, true},
+		{"candidate is not public key", signers[0], []byte("short"), true},
+		{"candidate is already signer", signers[0], signers[1], true},
+		{"candidate is not signer", signers[0], outsider, false},
+	}
+	for _, c := range cases {
+		err := e.Vote(state, state, c.voter, c.candidate, c.authorize)
+		if err == nil {
+			t.Errorf("%s: vote is accepted", c.name)
+		}
+	}
+	if len(state) != 0 {
+		t.Error("refused vote wrote state")
+	}
+}
+
+func TestLastSignerIsNotRemoved(t *testing.T) {
+	signers := newKeys(t, 1)
+	e := newTestEngine(t, signers)
+	state := memState{}
+
+	err := e.Vote(state, state, signers[0], signers[0], false)
+	if err == nil {
+		t.Fatal("last signer is removed")
+	}
+	if n := signerCount(t, e, state); n != 1 {
+		t.Fatalf("signers: %d", n)
+	}
+}
@@ -0,0 +1,59 @@
+package pool
+
+import (
+	"math"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// number of last shares paid for a block
+	PPLNS_WINDOW = 1_000
+)
+
+type Payout struct {
+	Worker string
+	// sum of weight of the worker's shares in window
+	Weight float64
+	// part of block reward, all ratios sum to 1
+	Ratio float64
+}
+
+type PayoutReport struct {
+	Height  uint64
+	Hash    []byte
+	Shares  int
+	Payouts []Payout
+}
+
+// pay per last n shares,
+// a share is weighted by expected hashes for its difficulty
+// so that workers with different share difficulty are paid fairly
+func CalcPayouts(block *FoundBlock, shares []Share) *PayoutReport {
+	weights := map[string]float64{}
+	var total float64
+	for _, s := range shares {
+		w := math.Exp2(float64(s.Difficulty))
+		weights[s.Worker] += w
+		total += w
+	}
+
+	workers := maps.Keys(weights)
+	slices.Sort(workers)
+	payouts := make([]Payout, 0, len(workers))
+	for _, worker := range workers {
+		payouts = append(payouts, Payout{
+			Worker: worker,
+			Weight: weights[worker],
+			Ratio:  weights[worker] / total,
+		})
+	}
+
+	return &PayoutReport{
+		Height:  block.Height,
+		Hash:    block.Hash,
+		Shares:  len(shares),
+		Payouts: payouts,
+	}
+}
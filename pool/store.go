@@ -0,0 +1,159 @@
+package pool
+
+import (
+	"fmt"
+	"simple-blockchain-go/common"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	POOL_DATABASE_FILE = "%s_pool.db"
+	SHARES_BUCKET      = "shares"
+	BLOCKS_BUCKET      = "blocks"
+	PAYOUTS_BUCKET     = "payouts"
+)
+
+// hash under share target submitted by a worker
+type Share struct {
+	Worker     string
+	Height     uint64
+	Difficulty byte
+	Nonce      uint64
+	Hash       []byte
+	Timestamp  int64
+}
+
+// block found by pool and accepted by upstream
+type FoundBlock struct {
+	Height    uint64
+	Hash      []byte
+	Finder    string
+	Timestamp int64
+}
+
+// local store of pool, this is not a part of chain state
+type Store struct {
+	innerDb *bolt.DB
+}
+
+func StoreFileName(id string) string {
+	return fmt.Sprintf(POOL_DATABASE_FILE, id)
+}
+
+func OpenStore(id string) (*Store, error) {
+	db, err := bolt.Open(StoreFileName(id), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{
+			SHARES_BUCKET,
+			BLOCKS_BUCKET,
+			PAYOUTS_BUCKET,
+		} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.innerDb.Close()
+}
+
+// shares are keyed by sequence so that order is kept
+func (s *Store) PutShare(share *Share) error {
+	enc, err := common.Encode(share)
+	if err != nil {
+		return err
+	}
+
+	return s.innerDb.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(SHARES_BUCKET))
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key, err := common.ToHex(seq)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, enc)
+	})
+}
+
+// newest first
+func (s *Store) GetRecentShares(n int) ([]Share, error) {
+	shares := make([]Share, 0, n)
+	err := s.innerDb.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(SHARES_BUCKET)).Cursor()
+		for k, v := c.Last(); k != nil && len(shares) < n; k, v = c.Prev() {
+			share, err := common.Decode[Share](v)
+			if err != nil {
+				return err
+			}
+			shares = append(shares, *share)
+		}
+		return nil
+	})
+	return shares, err
+}
+
+func (s *Store) PutFoundBlock(block *FoundBlock) error {
+	enc, err := common.Encode(block)
+	if err != nil {
+		return err
+	}
+	key, err := common.ToHex(block.Height)
+	if err != nil {
+		return err
+	}
+
+	return s.innerDb.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BLOCKS_BUCKET)).Put(key, enc)
+	})
+}
+
+// keyed by height of the block paid out
+func (s *Store) PutPayoutReport(report *PayoutReport) error {
+	enc, err := common.Encode(report)
+	if err != nil {
+		return err
+	}
+	key, err := common.ToHex(report.Height)
+	if err != nil {
+		return err
+	}
+
+	return s.innerDb.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(PAYOUTS_BUCKET)).Put(key, enc)
+	})
+}
+
+func (s *Store) GetPayoutReport(height uint64) (*PayoutReport, error) {
+	key, err := common.ToHex(height)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *PayoutReport
+	err = s.innerDb.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(PAYOUTS_BUCKET)).Get(key)
+		if v == nil {
+			return fmt.Errorf("no payout report for height %d", height)
+		}
+		report, err = common.Decode[PayoutReport](v)
+		return err
+	})
+	return report, err
+}
@@ -0,0 +1,296 @@
+package pos
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/consensus"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/wallets"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/sha3"
+)
+
+var consensusLog = logger.New(logger.CONSENSUS)
+
+const (
+	VALIDATOR_WALLET = "validator"
+	// pos blocks are not mined
+	DIFFICULTY byte = 1
+	// seconds, minimum interval of blocks
+	BLOCK_PERIOD int64 = 5
+	// seconds, next proposer is selected when proposer misses this long
+	ROUND_TIMEOUT int64 = 10
+	// seconds, how much earlier than its round a block may arrive
+	ROUND_CLOCK_DRIFT int64 = 1
+)
+
+// proof of stake,
+// proposer of each block is selected with weight of stake
+type Engine struct {
+	genesisValidators [][]byte
+	unbondingPeriod   uint64
+	minStake          uint64
+	// nil when this node does not propose
+	validator *wallets.Wallet
+}
+
+type weighted struct {
+	pubKey []byte
+	power  uint64
+}
+
+func NewEngine(
+	genesisValidators []string,
+	unbondingPeriod uint64,
+	minStake uint64,
+	validator *wallets.Wallet,
+) (*Engine, error) {
+	keys := make([][]byte, 0, len(genesisValidators))
+	for _, s := range genesisValidators {
+		pubKey := base58.Decode(s)
+		if len(pubKey) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid validator public key in genesis config")
+		}
+		keys = append(keys, pubKey)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("genesis config has no validator")
+	}
+
+	if validator != nil {
+		consensusLog.Info("local validator", logger.F("validator", base58.Encode(validator.PublicKey())))
+	}
+	return &Engine{
+		genesisValidators: keys,
+		unbondingPeriod:   unbondingPeriod,
+		minStake:          minStake,
+		validator:         validator,
+	}, nil
+}
+
+func (e *Engine) Name() string {
+	return consensus.POS_ENGINE
+}
+
+// validators with power, genesis validators have equal power
+// until someone stakes enough
+func (e *Engine) activeSet(chain consensus.ChainReader) ([]weighted, error) {
+	validators, err := GetValidators(chain)
+	if err != nil {
+		return nil, err
+	}
+	set := []weighted{}
+	for _, v := range validators {
+		if v.Power() == 0 || v.SelfStake < e.minStake {
+			continue
+		}
+		set = append(set, weighted{v.PublicKey, v.Power()})
+	}
+	if len(set) > 0 {
+		return set, nil
+	}
+
+	for _, k := range e.genesisValidators {
+		set = append(set, weighted{k, 1})
+	}
+	return set, nil
+}
+
+// deterministic weighted selection seeded by parent
+func selectProposer(
+	set []weighted, parentHash []byte, height uint64, round uint64,
+) []byte {
+	var total uint64
+	for _, w := range set {
+		total += w.power
+	}
+
+	seed := make([]byte, 0, len(parentHash)+16)
+	seed = append(seed, parentHash...)
+	seed = binary.BigEndian.AppendUint64(seed, height)
+	seed = binary.BigEndian.AppendUint64(seed, round)
+	hash := sha3.Sum256(seed)
+	point := binary.BigEndian.Uint64(hash[:8]) % total
+
+	for _, w := range set {
+		if point < w.power {
+			return w.pubKey
+		}
+		point -= w.power
+	}
+	return set[len(set)-1].pubKey
+}
+
+// round increases every timeout after block period
+func calcRound(parentTimestamp int64, timestamp int64) uint64 {
+	elapsed := timestamp - parentTimestamp - BLOCK_PERIOD
+	if elapsed < 0 {
+		return 0
+	}
+	return uint64(elapsed / ROUND_TIMEOUT)
+}
+
+func roundStart(parentTimestamp int64, round uint64) int64 {
+	return parentTimestamp + BLOCK_PERIOD + int64(round)*ROUND_TIMEOUT
+}
+
+// proposer of header's round and when the round starts,
+// nil before block period
+func (e *Engine) proposerOf(
+	chain consensus.ChainReader, header *blocks.BlockHeader,
+) ([]byte, int64, error) {
+	parent, err := chain.GetBlockByHeight(header.Height - 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header.Timestamp < parent.Timestamp+BLOCK_PERIOD {
+		return nil, 0, nil
+	}
+	set, err := e.activeSet(chain)
+	if err != nil {
+		return nil, 0, err
+	}
+	round := calcRound(parent.Timestamp, header.Timestamp)
+	proposer := selectProposer(set, parent.Hash, header.Height, round)
+	return proposer, roundStart(parent.Timestamp, round), nil
+}
+
+func (e *Engine) VerifyHeader(
+	chain consensus.ChainReader, header *blocks.BlockHeader, hash []byte,
+) (bool, error) {
+	if header.Nonce != 0 || header.Difficulty != DIFFICULTY {
+		consensusLog.Warn("pos header has invalid nonce or difficulty", logger.Height(header.Height))
+		return false, nil
+	}
+
+	proposer, start, err := e.proposerOf(chain, header)
+	if err != nil {
+		return false, err
+	}
+	if proposer == nil {
+		consensusLog.Warn("block is proposed before block period", logger.Height(header.Height))
+		return false, nil
+	}
+	// timestamp is chosen by proposer, so future one would skip
+	// proposers of earlier rounds unless the round has begun here too
+	if start > time.Now().Unix()+ROUND_CLOCK_DRIFT {
+		consensusLog.Warn(
+			"block arrived before its round",
+			logger.Height(header.Height),
+			logger.F("roundStart", start),
+		)
+		return false, nil
+	}
+	if !bytes.Equal(proposer, header.Coinbase) {
+		consensusLog.Warn(
+			"block is proposed by unexpected validator",
+			logger.Height(header.Height),
+			logger.F("proposer", base58.Encode(header.Coinbase)),
+			logger.F("expected", base58.Encode(proposer)),
+		)
+		return false, nil
+	}
+
+	sealHash, err := header.SealHash()
+	if err != nil {
+		return false, err
+	}
+	if !ed25519.Verify(header.Coinbase, sealHash, header.Signature) {
+		consensusLog.Warn("block signature is invalid", logger.Height(header.Height))
+		return false, nil
+	}
+	return true, nil
+}
+
+func (e *Engine) CalcDifficulty(chain consensus.ChainReader) (byte, error) {
+	return DIFFICULTY, nil
+}
+
+func (e *Engine) CanSeal(chain consensus.ChainReader) (bool, error) {
+	if e.validator == nil {
+		return false, nil
+	}
+
+	window, err := chain.GetRecentHeaders(1)
+	if err != nil {
+		return false, err
+	}
+	// next of genesis when empty
+	var headHeight uint64
+	if len(window) > 0 {
+		headHeight = window[0].Height
+	}
+
+	proposer, _, err := e.proposerOf(chain, &blocks.BlockHeader{
+		Height:    headHeight + 1,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(proposer, e.validator.PublicKey()), nil
+}
+
+func (e *Engine) Prepare(
+	chain consensus.ChainReader, header *blocks.BlockHeader,
+) error {
+	if e.validator == nil {
+		return consensus.ErrNotSealer
+	}
+	proposer, _, err := e.proposerOf(chain, header)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(proposer, e.validator.PublicKey()) {
+		return consensus.ErrNotSealer
+	}
+
+	header.Coinbase = e.validator.PublicKey()
+	header.Difficulty = DIFFICULTY
+	header.Nonce = 0
+	return nil
+}
+
+func (e *Engine) IsRemoteSealing() bool {
+	return false
+}
+
+func (e *Engine) Seal(ctx context.Context, block *blocks.Block) error {
+	if e.validator == nil {
+		return consensus.ErrNotSealer
+	}
+	if !bytes.Equal(block.Coinbase, e.validator.PublicKey()) {
+		return errors.New("block is not prepared by local validator")
+	}
+
+	sealHash, err := block.SealHash()
+	if err != nil {
+		return err
+	}
+	block.Signature = e.validator.QuickSign(sealHash)
+	hash, err := block.CalcHash()
+	if err != nil {
+		return err
+	}
+	block.Hash = hash
+	return nil
+}
+
+// releases unbonded stake, proposers are not rewarded
+func (e *Engine) Finalize(
+	chain consensus.ChainReader,
+	state consensus.StateWriter,
+	block *blocks.Block,
+) error {
+	db, ok := state.(StateDB)
+	if !ok {
+		return errors.New("pos needs account access for finalization")
+	}
+	return e.releaseUnbondings(db, block.Height)
+}
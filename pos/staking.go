@@ -0,0 +1,386 @@
+package pos
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/consensus"
+	"simple-blockchain-go/logger"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+const (
+	// state keys, prefixed not to collide with account keys
+	VALIDATORS_KEY        = "pos:validators"
+	VALIDATOR_KEY_PREFIX  = "pos:validator:"
+	DELEGATION_KEY_PREFIX = "pos:delegation:"
+	UNBONDING_KEY         = "pos:unbonding"
+
+	// percent of self stake burned on double signing
+	SLASH_PERCENT = 50
+)
+
+// state which staking needs in addition to consensus access
+type StateDB interface {
+	consensus.ChainReader
+	consensus.StateWriter
+	GetAccountStateSafe(pubKey []byte) (*accounts.AccountState, error)
+	PutAccountState(pubKey []byte, state *accounts.AccountState) error
+}
+
+type Validator struct {
+	PublicKey []byte
+	SelfStake uint64
+	Delegated uint64
+	// jailed validator never proposes again
+	Jailed bool
+}
+
+type Delegation struct {
+	Amount uint64
+}
+
+type Unbonding struct {
+	Account []byte
+	// validator which the stake was bonded to
+	Validator     []byte
+	Amount        uint64
+	ReleaseHeight uint64
+}
+
+func validatorKey(pubKey []byte) []byte {
+	return append([]byte(VALIDATOR_KEY_PREFIX), pubKey...)
+}
+
+func delegationKey(validator []byte, delegator []byte) []byte {
+	key := append([]byte(DELEGATION_KEY_PREFIX), validator...)
+	return append(key, delegator...)
+}
+
+// nil when key does not exist
+func getObject[T interface{}](
+	chain consensus.ChainReader, key []byte,
+) (*T, error) {
+	enc, err := chain.GetStateValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, nil
+	}
+	return common.Decode[T](enc)
+}
+
+func putObject(state consensus.StateWriter, key []byte, obj interface{}) error {
+	enc, err := common.Encode(obj)
+	if err != nil {
+		return err
+	}
+	return state.PutStateValue(key, enc)
+}
+
+// registered validators in registration order
+func GetValidators(chain consensus.ChainReader) ([]Validator, error) {
+	list, err := getObject[[][]byte](chain, []byte(VALIDATORS_KEY))
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		return []Validator{}, nil
+	}
+
+	validators := make([]Validator, 0, len(*list))
+	for _, pubKey := range *list {
+		v, err := getObject[Validator](chain, validatorKey(pubKey))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, errors.New("validator in list is not found")
+		}
+		validators = append(validators, *v)
+	}
+	return validators, nil
+}
+
+func (v *Validator) Power() uint64 {
+	if v.Jailed {
+		return 0
+	}
+	return v.SelfStake + v.Delegated
+}
+
+func lockBalance(db StateDB, pubKey []byte, amount uint64) error {
+	state, err := db.GetAccountStateSafe(pubKey)
+	if err != nil {
+		return err
+	}
+	if !state.Lock(amount) {
+		return errors.New("underflow")
+	}
+	return db.PutAccountState(pubKey, state)
+}
+
+func (e *Engine) Stake(db StateDB, staker []byte, amount uint64) error {
+	if amount == 0 {
+		return errors.New("amount is zero")
+	}
+	v, err := getObject[Validator](db, validatorKey(staker))
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		list, err := getObject[[][]byte](db, []byte(VALIDATORS_KEY))
+		if err != nil {
+			return err
+		}
+		if list == nil {
+			list = &[][]byte{}
+		}
+		*list = append(*list, staker)
+		err = putObject(db, []byte(VALIDATORS_KEY), list)
+		if err != nil {
+			return err
+		}
+		v = &Validator{PublicKey: staker}
+		consensusLog.Info("new validator", logger.F("validator", base58.Encode(staker)))
+	}
+	if v.Jailed {
+		return errors.New("validator is jailed")
+	}
+
+	err = lockBalance(db, staker, amount)
+	if err != nil {
+		return err
+	}
+	v.SelfStake += amount
+	return putObject(db, validatorKey(staker), v)
+}
+
+func (e *Engine) Delegate(
+	db StateDB, delegator []byte, validator []byte, amount uint64,
+) error {
+	if amount == 0 {
+		return errors.New("amount is zero")
+	}
+	if bytes.Equal(delegator, validator) {
+		return errors.New("validator should stake instead of delegate")
+	}
+	v, err := getObject[Validator](db, validatorKey(validator))
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return errors.New("validator is not found")
+	}
+	if v.Jailed {
+		return errors.New("validator is jailed")
+	}
+
+	err = lockBalance(db, delegator, amount)
+	if err != nil {
+		return err
+	}
+	d, err := getObject[Delegation](db, delegationKey(validator, delegator))
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		d = &Delegation{}
+	}
+	d.Amount += amount
+	err = putObject(db, delegationKey(validator, delegator), d)
+	if err != nil {
+		return err
+	}
+	v.Delegated += amount
+	return putObject(db, validatorKey(validator), v)
+}
+
+// amount stays locked until unbonding period passes
+func (e *Engine) Unstake(
+	db StateDB, sender []byte, validator []byte, amount uint64, height uint64,
+) error {
+	if amount == 0 {
+		return errors.New("amount is zero")
+	}
+	v, err := getObject[Validator](db, validatorKey(validator))
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return errors.New("validator is not found")
+	}
+
+	if bytes.Equal(sender, validator) {
+		if amount > v.SelfStake {
+			return errors.New("underflow")
+		}
+		v.SelfStake -= amount
+	} else {
+		d, err := getObject[Delegation](db, delegationKey(validator, sender))
+		if err != nil {
+			return err
+		}
+		if d == nil || amount > d.Amount {
+			return errors.New("underflow")
+		}
+		d.Amount -= amount
+		err = putObject(db, delegationKey(validator, sender), d)
+		if err != nil {
+			return err
+		}
+		v.Delegated -= amount
+	}
+	err = putObject(db, validatorKey(validator), v)
+	if err != nil {
+		return err
+	}
+
+	queue, err := getObject[[]Unbonding](db, []byte(UNBONDING_KEY))
+	if err != nil {
+		return err
+	}
+	if queue == nil {
+		queue = &[]Unbonding{}
+	}
+	*queue = append(*queue, Unbonding{
+		Account:       sender,
+		Validator:     validator,
+		Amount:        amount,
+		ReleaseHeight: height + e.unbondingPeriod,
+	})
+	return putObject(db, []byte(UNBONDING_KEY), queue)
+}
+
+// unlocks matured unbondings, called on finalization
+func (e *Engine) releaseUnbondings(db StateDB, height uint64) error {
+	queue, err := getObject[[]Unbonding](db, []byte(UNBONDING_KEY))
+	if err != nil {
+		return err
+	}
+	if queue == nil {
+		return nil
+	}
+
+	pending := []Unbonding{}
+	for _, u := range *queue {
+		if u.ReleaseHeight > height {
+			pending = append(pending, u)
+			continue
+		}
+
+		state, err := db.GetAccountStateSafe(u.Account)
+		if err != nil {
+			return err
+		}
+		// might be partially burned by slashing
+		amount := u.Amount
+		if amount > state.Locked {
+			amount = state.Locked
+		}
+		state.Unlock(amount)
+		err = db.PutAccountState(u.Account, state)
+		if err != nil {
+			return err
+		}
+	}
+	if len(pending) == len(*queue) {
+		return nil
+	}
+	return putObject(db, []byte(UNBONDING_KEY), pending)
+}
+
+func verifySignedHeader(enc []byte) (*blocks.BlockHeader, []byte, error) {
+	header, err := common.Decode[blocks.BlockHeader](enc)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealHash, err := header.SealHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ed25519.Verify(header.Coinbase, sealHash, header.Signature) {
+		return nil, nil, errors.New("invalid signature in evidence")
+	}
+	return header, sealHash, nil
+}
+
+// burns part of validator's self stake and jails it
+// when it signed two different blocks at the same height
+func (e *Engine) Slash(db StateDB, headerA []byte, headerB []byte) error {
+	a, sealHashA, err := verifySignedHeader(headerA)
+	if err != nil {
+		return err
+	}
+	b, sealHashB, err := verifySignedHeader(headerB)
+	if err != nil {
+		return err
+	}
+	if a.Height != b.Height || !bytes.Equal(a.Coinbase, b.Coinbase) {
+		return errors.New("evidence headers are not conflicting")
+	}
+	if bytes.Equal(sealHashA, sealHashB) {
+		return errors.New("evidence headers are the same")
+	}
+
+	v, err := getObject[Validator](db, validatorKey(a.Coinbase))
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return errors.New("validator is not found")
+	}
+	if v.Jailed {
+		return errors.New("validator is already jailed")
+	}
+
+	stakeCut := v.SelfStake * SLASH_PERCENT / 100
+	slashed := stakeCut
+	// self stake which is unbonding was at stake when it double signed
+	queue, err := getObject[[]Unbonding](db, []byte(UNBONDING_KEY))
+	if err != nil {
+		return err
+	}
+	if queue != nil {
+		for i, u := range *queue {
+			if !bytes.Equal(u.Validator, a.Coinbase) ||
+				!bytes.Equal(u.Account, a.Coinbase) {
+				continue
+			}
+			cut := u.Amount * SLASH_PERCENT / 100
+			(*queue)[i].Amount -= cut
+			slashed += cut
+		}
+		err = putObject(db, []byte(UNBONDING_KEY), queue)
+		if err != nil {
+			return err
+		}
+	}
+
+	state, err := db.GetAccountStateSafe(a.Coinbase)
+	if err != nil {
+		return err
+	}
+	if !state.Burn(slashed) {
+		return errors.New("underflow")
+	}
+	err = db.PutAccountState(a.Coinbase, state)
+	if err != nil {
+		return err
+	}
+
+	v.SelfStake -= stakeCut
+	v.Jailed = true
+	consensusLog.Warn(
+		"validator is slashed for double signing",
+		logger.F("validator", base58.Encode(a.Coinbase)),
+		logger.F("slashed", slashed),
+		logger.Height(a.Height),
+	)
+	return putObject(db, validatorKey(a.Coinbase), v)
+}
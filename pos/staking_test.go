@@ -0,0 +1,239 @@
+package pos
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/common"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// chain without blocks, accounts and objects only
+type memDB struct {
+	values   map[string][]byte
+	accounts map[string]*accounts.AccountState
+}
+
+func newMemDB() *memDB {
+	return &memDB{
+		values:   map[string][]byte{},
+		accounts: map[string]*accounts.AccountState{},
+	}
+}
+
+func (db *memDB) GetRecentHeaders(n int) ([]blocks.BlockHeader, error) {
+	return nil, nil
+}
+
+func (db *memDB) GetBlockByHeight(height uint64) (*blocks.Block, error) {
+	return nil, nil
+}
+
+func (db *memDB) GetStateValue(key []byte) ([]byte, error) {
+	return db.values[string(key)], nil
+}
+
+func (db *memDB) PutStateValue(key []byte, value []byte) error {
+	db.values[string(key)] = value
+	return nil
+}
+
+func (db *memDB) AddBalance(pubKey []byte, amount uint64) error {
+	state, _ := db.GetAccountStateSafe(pubKey)
+	state.Balance += amount
+	return db.PutAccountState(pubKey, state)
+}
+
+func (db *memDB) GetAccountStateSafe(pubKey []byte) (*accounts.AccountState, error) {
+	state, ok := db.accounts[string(pubKey)]
+	if !ok {
+		state = &accounts.AccountState{}
+		db.accounts[string(pubKey)] = state
+	}
+	copied := *state
+	return &copied, nil
+}
+
+func (db *memDB) PutAccountState(pubKey []byte, state *accounts.AccountState) error {
+	copied := *state
+	db.accounts[string(pubKey)] = &copied
+	return nil
+}
+
+func (db *memDB) validator(t *testing.T, pubKey []byte) *Validator {
+	v, err := getObject[Validator](db, validatorKey(pubKey))
+	if err != nil || v == nil {
+		t.Fatalf("validator is not found: %v", err)
+	}
+	return v
+}
+
+const UNBONDING_PERIOD = 10
+
+func newTestEngine(t *testing.T) *Engine {
+	genesis, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(
+		[]string{base58.Encode(genesis)}, UNBONDING_PERIOD, 100, nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// validator with balance which has staked some of it
+func newStaker(
+	t *testing.T, e *Engine, db *memDB, balance uint64, stake uint64,
+) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.accounts[string(pub)] = &accounts.AccountState{Balance: balance}
+	err = e.Stake(db, pub, stake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func signedHeader(
+	t *testing.T, priv ed25519.PrivateKey, height uint64, timestamp int64,
+) []byte {
+	header := blocks.BlockHeader{
+		Height:    height,
+		Timestamp: timestamp,
+		Coinbase:  priv.Public().(ed25519.PublicKey),
+	}
+	sealHash, err := header.SealHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Signature = ed25519.Sign(priv, sealHash)
+	enc, err := common.Encode(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func TestStakeLocksBalance(t *testing.T) {
+	e := newTestEngine(t)
+	db := newMemDB()
+	pub, _ := newStaker(t, e, db, 1_000, 400)
+
+	state, _ := db.GetAccountStateSafe(pub)
+	if state.Balance != 600 || state.Locked != 400 {
+		t.Errorf("balance %d, locked %d", state.Balance, state.Locked)
+	}
+	if v := db.validator(t, pub); v.SelfStake != 400 {
+		t.Errorf("self stake %d", v.SelfStake)
+	}
+
+	if e.Stake(db, pub, 700) == nil {
+		t.Error("stake more than balance is accepted")
+	}
+}
+
+func TestUnstakeIsReleasedAfterPeriod(t *testing.T) {
+	e := newTestEngine(t)
+	db := newMemDB()
+	pub, _ := newStaker(t, e, db, 1_000, 400)
+
+	err := e.Unstake(db, pub, pub, 100, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.releaseUnbondings(db, 5+UNBONDING_PERIOD-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := db.GetAccountStateSafe(pub)
+	if state.Locked != 400 {
+		t.Fatalf("released early, locked %d", state.Locked)
+	}
+
+	err = e.releaseUnbondings(db, 5+UNBONDING_PERIOD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ = db.GetAccountStateSafe(pub)
+	if state.Balance != 700 || state.Locked != 300 {
+		t.Errorf("balance %d, locked %d", state.Balance, state.Locked)
+	}
+}
+
+// unbonding self stake was at stake when it double signed
+func TestSlashBurnsStakeAndJails(t *testing.T) {
+	e := newTestEngine(t)
+	db := newMemDB()
+	pub, priv := newStaker(t, e, db, 1_000, 400)
+	err := e.Unstake(db, pub, pub, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.Slash(db, signedHeader(t, priv, 7, 1), signedHeader(t, priv, 7, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := db.validator(t, pub)
+	if !v.Jailed || v.Power() != 0 {
+		t.Error("slashed validator is not jailed")
+	}
+	// half of 300 bonded and of 100 unbonding
+	if v.SelfStake != 150 {
+		t.Errorf("self stake %d", v.SelfStake)
+	}
+	state, _ := db.GetAccountStateSafe(pub)
+	if state.Locked != 200 {
+		t.Errorf("locked %d", state.Locked)
+	}
+
+	if e.Slash(db, signedHeader(t, priv, 7, 1), signedHeader(t, priv, 7, 3)) == nil {
+		t.Error("jailed validator is slashed twice")
+	}
+	if e.Stake(db, pub, 10) == nil {
+		t.Error("jailed validator can stake")
+	}
+}
+
+func TestSlashRefusesBadEvidence(t *testing.T) {
+	e := newTestEngine(t)
+	db := newMemDB()
+	_, priv := newStaker(t, e, db, 1_000, 400)
+	_, other := newStaker(t, e, db, 1_000, 400)
+
+	forged := signedHeader(t, priv, 7, 2)
+	header, err := common.Decode[blocks.BlockHeader](forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Timestamp = 3
+	forged, err = common.Encode(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		a, b []byte
+	}{
+		{"same header", signedHeader(t, priv, 7, 1), signedHeader(t, priv, 7, 1)},
+		{"different heights", signedHeader(t, priv, 7, 1), signedHeader(t, priv, 8, 2)},
+		{"different signers", signedHeader(t, priv, 7, 1), signedHeader(t, other, 7, 2)},
+		{"forged signature", signedHeader(t, priv, 7, 1), forged},
+	}
+	for _, c := range cases {
+		if e.Slash(db, c.a, c.b) == nil {
+			t.Errorf("%s: slashed", c.name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pow
+
+import (
+	"context"
+	"simple-blockchain-go/blocks"
+	"simple-blockchain-go/consensus"
+	"simple-blockchain-go/logger"
+)
+
+// proof of work as consensus engine,
+// blocks are sealed by miner nodes
+type Engine struct {
+	miner *MiningEngine
+}
+
+func NewEngine(workers int) *Engine {
+	return &Engine{
+		miner: NewMiningEngine(workers),
+	}
+}
+
+func (e *Engine) Name() string {
+	return consensus.POW_ENGINE
+}
+
+func (e *Engine) VerifyHeader(
+	chain consensus.ChainReader, header *blocks.BlockHeader, hash []byte,
+) (bool, error) {
+	expected, err := e.CalcDifficulty(chain)
+	if err != nil {
+		return false, err
+	}
+	if header.Difficulty != expected {
+		powLog.Warn(
+			"block difficulty is invalid",
+			logger.Height(header.Height),
+			logger.F("difficulty", header.Difficulty),
+			logger.F("expected", expected),
+		)
+		return false, nil
+	}
+
+	validator := NewProofOfWork(&blocks.Block{
+		BlockHeader: *header,
+		Hash:        hash,
+	})
+	ok, err := validator.Validate()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		powLog.Warn("pow block validation failed", logger.Height(header.Height))
+	}
+	return ok, nil
+}
+
+func (e *Engine) CalcDifficulty(chain consensus.ChainReader) (byte, error) {
+	window, err := chain.GetRecentHeaders(RETARGET_INTERVAL)
+	if err != nil {
+		return 0, err
+	}
+	return CalcNextDifficulty(window), nil
+}
+
+// miners are always there
+func (e *Engine) CanSeal(chain consensus.ChainReader) (bool, error) {
+	return true, nil
+}
+
+func (e *Engine) Prepare(
+	chain consensus.ChainReader, header *blocks.BlockHeader,
+) error {
+	difficulty, err := e.CalcDifficulty(chain)
+	if err != nil {
+		return err
+	}
+	header.Difficulty = difficulty
+	return nil
+}
+
+func (e *Engine) IsRemoteSealing() bool {
+	return true
+}
+
+func (e *Engine) Seal(ctx context.Context, block *blocks.Block) error {
+	nonce, hash, err := NewProofOfWork(block).RunContext(ctx, e.miner)
+	if err != nil {
+		return err
+	}
+	block.Nonce = nonce
+	block.Hash = hash
+	return nil
+}
+
+// miners have no account on chain,
+// reward is only notified with reward message
+func (e *Engine) Finalize(
+	chain consensus.ChainReader,
+	state consensus.StateWriter,
+	block *blocks.Block,
+) error {
+	return nil
+}
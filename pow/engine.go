@@ -0,0 +1,231 @@
+package pow
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"runtime"
+	"simple-blockchain-go/blocks"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// hashes between context checks
+	CHECK_INTERVAL = 1 << 12
+)
+
+var (
+	ErrMiningCanceled = errors.New("mining is canceled")
+	ErrNonceExhausted = errors.New("nonce space is exhausted")
+)
+
+type solution struct {
+	nonce uint64
+	hash  [32]byte
+}
+
+// multi-core mining engine
+// nonce space is split across workers
+type MiningEngine struct {
+	workers   int
+	hashes    atomic.Uint64
+	startedAt atomic.Int64
+	stoppedAt atomic.Int64
+}
+
+// workers <= 0 means all cores
+func NewMiningEngine(workers int) *MiningEngine {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &MiningEngine{workers: workers}
+}
+
+func (me *MiningEngine) Workers() int {
+	return me.workers
+}
+
+// hashes per second of current or last run
+func (me *MiningEngine) Hashrate() float64 {
+	started := me.startedAt.Load()
+	if started == 0 {
+		return 0
+	}
+	stopped := me.stoppedAt.Load()
+	if stopped == 0 {
+		stopped = time.Now().UnixNano()
+	}
+	elapsed := time.Duration(stopped - started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(me.hashes.Load()) / elapsed
+}
+
+func CalcTarget(difficulty byte) [32]byte {
+	var target [32]byte
+	t := big.NewInt(1)
+	t.Lsh(t, uint(math.MaxUint8-difficulty))
+	t.FillBytes(target[:])
+	return target
+}
+
+// searches nonce for block's header until found or ctx is done,
+// block itself is not modified
+func (me *MiningEngine) Mine(
+	ctx context.Context, block *blocks.Block,
+) (uint64, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	var result *solution
+	err := me.run(ctx, block, block.Difficulty, 0, MAX_NONCE, func(s solution) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		if result == nil {
+			result = &s
+			cancel()
+		}
+		return true
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if result != nil {
+		return result.nonce, result.hash[:], nil
+	}
+	if ctx.Err() != nil {
+		return 0, nil, ErrMiningCanceled
+	}
+	return 0, nil, ErrNonceExhausted
+}
+
+// keeps searching hashes under share difficulty in [from, to)
+// until ctx is done, onShare is called from multiple workers concurrently
+func (me *MiningEngine) MineShares(
+	ctx context.Context,
+	block *blocks.Block,
+	shareDifficulty byte,
+	from, to uint64,
+	onShare func(nonce uint64, hash []byte),
+) error {
+	err := me.run(ctx, block, shareDifficulty, from, to, func(s solution) bool {
+		onShare(s.nonce, s.hash[:])
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ErrMiningCanceled
+	}
+	return ErrNonceExhausted
+}
+
+// blocks until every worker returns
+func (me *MiningEngine) run(
+	ctx context.Context,
+	block *blocks.Block,
+	difficulty byte,
+	from, to uint64,
+	onFound func(s solution) bool,
+) error {
+	prefix, err := block.SerializePrefix()
+	if err != nil {
+		return err
+	}
+	target := CalcTarget(difficulty)
+
+	me.hashes.Store(0)
+	me.stoppedAt.Store(0)
+	me.startedAt.Store(time.Now().UnixNano())
+	defer func() {
+		me.stoppedAt.Store(time.Now().UnixNano())
+	}()
+
+	var wg sync.WaitGroup
+	span := (to - from) / uint64(me.workers)
+	for i := 0; i < me.workers; i++ {
+		start := from + span*uint64(i)
+		end := start + span
+		if i == me.workers-1 {
+			end = to
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			me.work(ctx, prefix, &target, start, end, onFound)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// onFound returns true to stop
+func (me *MiningEngine) work(
+	ctx context.Context,
+	prefix []byte,
+	target *[32]byte,
+	from, to uint64,
+	onFound func(s solution) bool,
+) {
+	buf := make([]byte, len(prefix)+8)
+	copy(buf, prefix)
+	nonceBuf := buf[len(prefix):]
+
+	// sha3 state can be squeezed directly,
+	// which avoids allocation of Sum
+	h := sha3.New256()
+	reader, squeezable := h.(io.Reader)
+	var out [32]byte
+	var count uint64
+
+	for nonce := from; nonce < to; nonce++ {
+		binary.BigEndian.PutUint64(nonceBuf, nonce)
+		if squeezable {
+			h.Reset()
+			h.Write(buf)
+			reader.Read(out[:])
+		} else {
+			out = sha3.Sum256(buf)
+		}
+
+		count++
+		if lessThan(&out, target) {
+			if onFound(solution{nonce, out}) {
+				me.hashes.Add(count)
+				return
+			}
+		}
+
+		if count == CHECK_INTERVAL {
+			me.hashes.Add(count)
+			count = 0
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}
+	me.hashes.Add(count)
+}
+
+func lessThan(a, b *[32]byte) bool {
+	for i := 0; i < 32; i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
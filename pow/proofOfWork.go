@@ -2,20 +2,20 @@ package pow
 
 import (
 	"bytes"
-	"log"
+	"context"
 	"math"
 	"math/big"
 	"simple-blockchain-go/blocks"
-	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
 	"simple-blockchain-go/transactions"
-
-	"golang.org/x/crypto/sha3"
 )
 
 const (
 	MAX_NONCE = math.MaxUint64
 )
 
+var powLog = logger.New(logger.POW)
+
 type ProofOfWork struct {
 	block      *blocks.Block
 	difficulty byte
@@ -26,7 +26,10 @@ func MineBlock(
 	transactions transactions.TxBundle,
 	info blocks.BlockInfo,
 ) (*blocks.Block, error) {
-	block := blocks.NewBlock(transactions, info)
+	block, err := blocks.NewBlock(transactions, info)
+	if err != nil {
+		return nil, err
+	}
 	pow := NewProofOfWork(block)
 	nonce, hash, err := pow.Run()
 	if err != nil {
@@ -39,95 +42,53 @@ func MineBlock(
 }
 
 func NewProofOfWork(b *blocks.Block) *ProofOfWork {
+	return NewProofOfWorkWithDifficulty(b, b.Difficulty)
+}
+
+// for validating shares which are easier than block's difficulty
+func NewProofOfWorkWithDifficulty(b *blocks.Block, difficulty byte) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(math.MaxUint8-b.Difficulty))
+	target.Lsh(target, uint(math.MaxUint8-difficulty))
 	pow := ProofOfWork{
 		block:      b,
-		difficulty: b.Difficulty,
+		difficulty: difficulty,
 		target:     target,
 	}
 	return &pow
 }
 
 func (pow *ProofOfWork) Run() (uint64, []byte, error) {
-	timestampHex, err := common.ToHex(pow.block.Timestamp)
-	if err != nil {
-		return 0, nil, err
-	}
-	targetBitsHex, err := common.ToHex(pow.difficulty)
-	if err != nil {
-		return 0, nil, err
-	}
+	return pow.RunContext(context.Background(), NewMiningEngine(0))
+}
 
-	transactionHash, err := pow.block.Bundle.HashTransactions()
+func (pow *ProofOfWork) RunContext(
+	ctx context.Context, engine *MiningEngine,
+) (uint64, []byte, error) {
+	powLog.Info("mining a new block", logger.Height(pow.block.Height), logger.F("workers", engine.Workers()))
+	nonce, hash, err := engine.Mine(ctx, pow.block)
 	if err != nil {
 		return 0, nil, err
 	}
-
-	var hashInt big.Int
-	var hash [32]byte
-	var nonce uint64 = 0
-
-	log.Println("mining a new block")
-	for nonce < MAX_NONCE {
-		nonceHex, err := common.ToHex(nonce)
-		if err != nil {
-			return 0, nil, err
-		}
-
-		data := bytes.Join(
-			[][]byte{
-				pow.block.PreviousBlockHash,
-				transactionHash,
-				timestampHex,
-				targetBitsHex,
-				nonceHex,
-			},
-			nil,
-		)
-		hash = sha3.Sum256(data)
-		hashInt.SetBytes(hash[:])
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
-		}
-	}
-	log.Printf("mined hash:\n%x\n", hash)
-	return nonce, hash[:], nil
+	powLog.Info(
+		"mined block",
+		logger.Height(pow.block.Height),
+		logger.Hash(hash),
+		logger.F("hashrate", engine.Hashrate()),
+	)
+	return nonce, hash, nil
 }
 
 func (pow *ProofOfWork) Validate() (bool, error) {
-	timestampHex, err := common.ToHex(pow.block.Timestamp)
-	if err != nil {
-		return false, err
-	}
-	targetBitsHex, err := common.ToHex(pow.difficulty)
+	hash, err := pow.block.CalcHash()
 	if err != nil {
 		return false, err
 	}
-	nonceHex, err := common.ToHex(pow.block.Nonce)
-	if err != nil {
-		return false, err
-	}
-	transactionHash, err := pow.block.Bundle.HashTransactions()
-	if err != nil {
-		return false, err
+	if !bytes.Equal(hash, pow.block.Hash) {
+		return false, nil
 	}
 
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PreviousBlockHash,
-			transactionHash,
-			timestampHex,
-			targetBitsHex,
-			nonceHex,
-		},
-		nil,
-	)
 	var hashInt big.Int
-	hash := sha3.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	hashInt.SetBytes(hash)
 
 	result := hashInt.Cmp(pow.target)
 	return result == -1, nil
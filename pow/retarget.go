@@ -0,0 +1,51 @@
+package pow
+
+import (
+	"simple-blockchain-go/blocks"
+)
+
+const (
+	// difficulty of first block after genesis
+	INITIAL_DIFFICULTY byte = 20
+	MIN_DIFFICULTY     byte = 1
+	MAX_DIFFICULTY     byte = 240
+	// difficulty is retargeted every this number of blocks
+	RETARGET_INTERVAL = 10
+	// seconds
+	TARGET_BLOCK_TIME int64 = 5
+)
+
+// window is headers of previous blocks ordered by height,
+// last one is parent of next block.
+// genesis is never included in window because
+// it's timestamp has nothing to do with mining time
+func CalcNextDifficulty(window []blocks.BlockHeader) byte {
+	if len(window) == 0 {
+		return INITIAL_DIFFICULTY
+	}
+	parent := window[len(window)-1]
+	if parent.Height == 0 {
+		return INITIAL_DIFFICULTY
+	}
+	if parent.Height%RETARGET_INTERVAL != 0 || len(window) < 2 {
+		return parent.Difficulty
+	}
+
+	first := window[0]
+	span := parent.Timestamp - first.Timestamp
+	expected := TARGET_BLOCK_TIME * int64(len(window)-1)
+
+	// one difficulty step doubles or halves the work,
+	// so adjust only when span is off more than that
+	difficulty := parent.Difficulty
+	if span*3 < expected*2 {
+		if difficulty < MAX_DIFFICULTY {
+			difficulty++
+		}
+	} else if span*2 > expected*3 {
+		if difficulty > MIN_DIFFICULTY {
+			difficulty--
+		}
+	}
+	return difficulty
+}
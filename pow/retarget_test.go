@@ -0,0 +1,42 @@
+package pow
+
+import (
+	"simple-blockchain-go/blocks"
+	"testing"
+)
+
+// headers up to parentHeight, spaced by interval seconds
+func window(parentHeight uint64, difficulty byte, interval int64) []blocks.BlockHeader {
+	var headers []blocks.BlockHeader
+	for h := parentHeight - RETARGET_INTERVAL + 1; h <= parentHeight; h++ {
+		headers = append(headers, blocks.BlockHeader{
+			Height:     h,
+			Timestamp:  int64(h) * interval,
+			Difficulty: difficulty,
+		})
+	}
+	return headers
+}
+
+func TestCalcNextDifficulty(t *testing.T) {
+	cases := []struct {
+		name   string
+		window []blocks.BlockHeader
+		want   byte
+	}{
+		{"empty window", nil, INITIAL_DIFFICULTY},
+		{"after genesis", []blocks.BlockHeader{{Height: 0, Difficulty: 3}}, INITIAL_DIFFICULTY},
+		{"not retarget height", window(11, 20, 1), 20},
+		{"too fast", window(20, 20, 1), 21},
+		{"on target", window(20, 20, TARGET_BLOCK_TIME), 20},
+		{"too slow", window(20, 20, TARGET_BLOCK_TIME*2), 19},
+		{"capped at max", window(20, MAX_DIFFICULTY, 1), MAX_DIFFICULTY},
+		{"capped at min", window(20, MIN_DIFFICULTY, TARGET_BLOCK_TIME*2), MIN_DIFFICULTY},
+	}
+	for _, c := range cases {
+		got := CalcNextDifficulty(c.window)
+		if got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
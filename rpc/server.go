@@ -0,0 +1,245 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"strings"
+	"time"
+)
+
+var rpcLog = logger.New(logger.RPC)
+
+const (
+	JSON_RPC_VERSION = "2.0"
+	MAX_REQUEST_SIZE = 1 << 20
+	// seconds
+	READ_TIMEOUT  = 10
+	WRITE_TIMEOUT = 10
+
+	PARSE_ERROR      = -32700
+	INVALID_REQUEST  = -32600
+	METHOD_NOT_FOUND = -32601
+	INVALID_PARAMS   = -32602
+	INTERNAL_ERROR   = -32603
+	// server defined
+	UNAUTHORIZED = -32001
+	NOT_FOUND    = -32004
+	REJECTED     = -32005
+)
+
+type Request struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Id      json.RawMessage `json:"id,omitempty"`
+}
+
+type Response struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// returned error which is not *Error is reported as internal error
+type Handler func(params json.RawMessage) (interface{}, error)
+
+// json-rpc 2.0 over http POST,
+// other handlers can share address and token
+type Server struct {
+	addr    string
+	token   string
+	methods map[string]Handler
+	mux     *http.ServeMux
+	server  *http.Server
+	// paths which take token in query, websockets only
+	wsPaths map[string]bool
+}
+
+// empty token means no auth
+func NewServer(addr string, token string) *Server {
+	s := &Server{
+		addr:    addr,
+		token:   token,
+		methods: map[string]Handler{},
+		mux:     http.NewServeMux(),
+		wsPaths: map[string]bool{},
+	}
+	s.mux.HandleFunc("/", s.serveRpc)
+	s.server = &http.Server{
+		Addr:         addr,
+		Handler:      s,
+		ReadTimeout:  time.Second * READ_TIMEOUT,
+		WriteTimeout: time.Second * WRITE_TIMEOUT,
+	}
+	return s
+}
+
+// handler is behind same auth as rpc
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.mux.Handle(path, handler)
+}
+
+// browsers can not set header on websocket,
+// so upgrade requests to path may send token in query
+func (s *Server) HandleWebSocket(path string, handler http.Handler) {
+	s.wsPaths[path] = true
+	s.mux.Handle(path, handler)
+}
+
+func (s *Server) Register(method string, handler Handler) {
+	s.methods[method] = handler
+}
+
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// returns http.ErrServerClosed after Shutdown
+func (s *Server) ListenAndServe() error {
+	rpcLog.Info("rpc server is listening", logger.F("addr", s.addr))
+	return s.server.ListenAndServe()
+}
+
+// hijacked connections such as websockets are not closed
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// params are decoded strictly into T
+func DecodeParams[T interface{}](params json.RawMessage) (*T, error) {
+	var p T
+	if len(params) == 0 {
+		return &p, nil
+	}
+	err := json.Unmarshal(params, &p)
+	if err != nil {
+		return nil, NewError(INVALID_PARAMS, err.Error())
+	}
+	return &p, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		s.reply(w, http.StatusUnauthorized, nil, NewError(UNAUTHORIZED, "unauthorized"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serveRpc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE+1))
+	if err != nil {
+		s.reply(w, http.StatusBadRequest, nil, NewError(PARSE_ERROR, err.Error()))
+		return
+	}
+	if len(body) > MAX_REQUEST_SIZE {
+		s.reply(w, http.StatusRequestEntityTooLarge, nil, NewError(INVALID_REQUEST, "request is too large"))
+		return
+	}
+
+	req, err := common.Decode[Request](body)
+	if err != nil {
+		s.reply(w, http.StatusOK, nil, NewError(PARSE_ERROR, err.Error()))
+		return
+	}
+	if req.JsonRpc != JSON_RPC_VERSION || req.Method == "" {
+		s.reply(w, http.StatusOK, req.Id, NewError(INVALID_REQUEST, "invalid request"))
+		return
+	}
+
+	handler, ok := s.methods[req.Method]
+	if !ok {
+		s.reply(w, http.StatusOK, req.Id, NewError(METHOD_NOT_FOUND, "method not found"))
+		return
+	}
+	result, err := handler(req.Params)
+	if err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			rpcLog.Error("rpc method failed", logger.F("method", req.Method), logger.Err(err))
+			rpcErr = NewError(INTERNAL_ERROR, "internal error")
+		}
+		s.reply(w, http.StatusOK, req.Id, rpcErr)
+		return
+	}
+	s.replyResult(w, req.Id, result)
+}
+
+// token in query ends up in access logs and history,
+// so it is accepted only for websocket upgrade
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	got := r.Header.Get("Authorization")
+	if got == "" && s.isWebSocketUpgrade(r) {
+		got = "Bearer " + r.URL.Query().Get("token")
+	}
+	expected := []byte("Bearer " + s.token)
+	return subtle.ConstantTimeCompare(expected, []byte(got)) == 1
+}
+
+func (s *Server) isWebSocketUpgrade(r *http.Request) bool {
+	return s.wsPaths[r.URL.Path] &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func (s *Server) replyResult(
+	w http.ResponseWriter, id json.RawMessage, result interface{},
+) {
+	s.write(w, http.StatusOK, &Response{
+		JsonRpc: JSON_RPC_VERSION,
+		Result:  result,
+		Id:      id,
+	})
+}
+
+func (s *Server) reply(
+	w http.ResponseWriter, status int, id json.RawMessage, rpcErr *Error,
+) {
+	s.write(w, status, &Response{
+		JsonRpc: JSON_RPC_VERSION,
+		Error:   rpcErr,
+		Id:      id,
+	})
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, res *Response) {
+	if res.Id == nil {
+		res.Id = json.RawMessage("null")
+	}
+	enc, err := common.Encode(res)
+	if err != nil {
+		rpcLog.Warn("writing rpc response failed", logger.Err(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(enc)
+}
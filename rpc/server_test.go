@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(token string) *Server {
+	s := NewServer("", token)
+	s.Register("echo", func(params json.RawMessage) (interface{}, error) {
+		p, err := DecodeParams[struct{ Value int }](params)
+		if err != nil {
+			return nil, err
+		}
+		return p.Value, nil
+	})
+	s.Register("broken", func(json.RawMessage) (interface{}, error) {
+		return nil, errors.New("disk is gone")
+	})
+	s.HandleWebSocket("/ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	return s
+}
+
+func call(t *testing.T, s *Server, header string, body string) (int, *Response) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if header != "" {
+		r.Header.Set("Authorization", header)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var res Response
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("response is not json: %s", w.Body.String())
+	}
+	return w.Code, &res
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer("secret")
+	body := `{"jsonrpc":"2.0","id":1,"method":"echo","params":{"Value":3}}`
+	cases := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		status, res := call(t, s, c.header, body)
+		if status != c.status {
+			t.Errorf("header %q: status %d, want %d", c.header, status, c.status)
+		}
+		if status == http.StatusUnauthorized && (res.Error == nil || res.Error.Code != UNAUTHORIZED) {
+			t.Errorf("header %q: error %v", c.header, res.Error)
+		}
+	}
+}
+
+// query token would leak into logs, so only websocket upgrade takes it
+func TestQueryTokenOnlyForWebSocket(t *testing.T) {
+	s := newTestServer("secret")
+
+	r := httptest.NewRequest(http.MethodPost, "/?token=secret", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("rpc with query token: status %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/ws?token=secret", nil)
+	r.Header.Set("Upgrade", "websocket")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusSwitchingProtocols {
+		t.Errorf("websocket with query token: status %d", w.Code)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := newTestServer("")
+	cases := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"not json", `{`, PARSE_ERROR},
+		{"wrong version", `{"jsonrpc":"1.0","id":1,"method":"echo"}`, INVALID_REQUEST},
+		{"unknown method", `{"jsonrpc":"2.0","id":1,"method":"nope"}`, METHOD_NOT_FOUND},
+		{"bad params", `{"jsonrpc":"2.0","id":1,"method":"echo","params":{"Value":"x"}}`, INVALID_PARAMS},
+		// details of internal failure are not told to client
+		{"internal", `{"jsonrpc":"2.0","id":1,"method":"broken"}`, INTERNAL_ERROR},
+	}
+	for _, c := range cases {
+		_, res := call(t, s, "", c.body)
+		if res.Error == nil || res.Error.Code != c.code {
+			t.Errorf("%s: error %v, want code %d", c.name, res.Error, c.code)
+			continue
+		}
+		if strings.Contains(res.Error.Message, "disk") {
+			t.Errorf("%s: internal error is leaked", c.name)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	s := newTestServer("")
+	_, res := call(t, s, "", `{"jsonrpc":"2.0","id":"a","method":"echo","params":{"Value":3}}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if string(res.Id) != `"a"` || res.Result != float64(3) {
+		t.Errorf("id %s, result %v", res.Id, res.Result)
+	}
+}
@@ -7,6 +7,31 @@ type CommandKind byte
 const (
 	AIRDROP_CMD CommandKind = iota + 1
 	TRANSFER_CMD
+	VOTE_SIGNER_CMD
+	STAKE_CMD
+	UNSTAKE_CMD
+	DELEGATE_CMD
+	DOUBLE_SIGN_EVIDENCE_CMD
+	BATCH_TRANSFER_CMD
+	CREATE_MULTISIG_CMD
+	LOCKED_TRANSFER_CMD
+	ESCROW_CLAIM_CMD
+	ESCROW_REFUND_CMD
+	ESCROW_RELEASE_CMD
+	TOKEN_CREATE_CMD
+	TOKEN_MINT_CMD
+	TOKEN_TRANSFER_CMD
+	TOKEN_BURN_CMD
+	CONTRACT_DEPLOY_CMD
+	CONTRACT_CALL_CMD
+)
+
+const (
+	MAX_BATCH_OUTPUTS  = 256
+	MAX_SYMBOL_LENGTH  = 12
+	MAX_TOKEN_DECIMALS = 18
+	MAX_CODE_SIZE      = 24_576
+	MAX_CALL_ARGS      = 16
 )
 
 func (ck CommandKind) MakePayload(data []byte) []byte {
@@ -22,6 +47,40 @@ func (ck CommandKind) ToString() string {
 		return "airdrop command"
 	case TRANSFER_CMD:
 		return "transfer command"
+	case VOTE_SIGNER_CMD:
+		return "vote signer command"
+	case STAKE_CMD:
+		return "stake command"
+	case UNSTAKE_CMD:
+		return "unstake command"
+	case DELEGATE_CMD:
+		return "delegate command"
+	case DOUBLE_SIGN_EVIDENCE_CMD:
+		return "double sign evidence command"
+	case BATCH_TRANSFER_CMD:
+		return "batch transfer command"
+	case CREATE_MULTISIG_CMD:
+		return "create multisig command"
+	case LOCKED_TRANSFER_CMD:
+		return "locked transfer command"
+	case ESCROW_CLAIM_CMD:
+		return "escrow claim command"
+	case ESCROW_REFUND_CMD:
+		return "escrow refund command"
+	case ESCROW_RELEASE_CMD:
+		return "escrow release command"
+	case TOKEN_CREATE_CMD:
+		return "token create command"
+	case TOKEN_MINT_CMD:
+		return "token mint command"
+	case TOKEN_TRANSFER_CMD:
+		return "token transfer command"
+	case TOKEN_BURN_CMD:
+		return "token burn command"
+	case CONTRACT_DEPLOY_CMD:
+		return "contract deploy command"
+	case CONTRACT_CALL_CMD:
+		return "contract call command"
 	default:
 		log.Panicf("unknown command %d", ck)
 	}
@@ -38,3 +97,117 @@ type Transfer struct {
 	To     []byte
 	Amount uint64
 }
+
+type TransferOutput struct {
+	To     []byte
+	Amount uint64
+}
+
+// pays all outputs or nothing
+type BatchTransfer struct {
+	From    []byte
+	Outputs []TransferOutput
+}
+
+// registers multisig account at spec's address
+type CreateMultisig struct {
+	Spec MultisigSpec
+}
+
+// amount goes into escrow,
+// To can claim it at UnlockHeight or later,
+// RefundKey can reclaim it from RefundHeight until UnlockHeight,
+// empty RefundKey means no refund
+type LockedTransfer struct {
+	To           []byte
+	Amount       uint64
+	UnlockHeight uint64
+	RefundKey    []byte
+	RefundHeight uint64
+}
+
+// sent by recipient or refund key
+type EscrowClaim struct {
+	EscrowId []byte
+}
+
+// sent by one party of escrow,
+// CounterSignature is the other party's over EscrowReleaseMessage
+type EscrowRelease struct {
+	EscrowId         []byte
+	CounterSignature []byte
+}
+
+func EscrowReleaseMessage(escrowId []byte) []byte {
+	return append([]byte("escrow release:"), escrowId...)
+}
+
+// token id is derived from sender and nonce,
+// empty MintAuthority means sender
+type TokenCreate struct {
+	Symbol        string
+	Decimals      byte
+	SupplyCap     uint64
+	MintAuthority []byte
+}
+
+// sent by mint authority
+type TokenMint struct {
+	TokenId []byte
+	To      []byte
+	Amount  uint64
+}
+
+type TokenTransfer struct {
+	TokenId []byte
+	To      []byte
+	Amount  uint64
+}
+
+// burns sender's own balance
+type TokenBurn struct {
+	TokenId []byte
+	Amount  uint64
+}
+
+// contract address is derived from sender and nonce
+type ContractDeploy struct {
+	Code []byte
+}
+
+// call fails without changing state when gas runs out
+type ContractCall struct {
+	Contract []byte
+	Args     [][]byte
+	GasLimit uint64
+}
+
+// sent by a poa signer,
+// Authorize false means voting for removal
+type VoteSigner struct {
+	Candidate []byte
+	Authorize bool
+}
+
+// sender becomes validator
+type Stake struct {
+	Amount uint64
+}
+
+// withdraws sender's stake or delegation from validator,
+// amount is unlocked after unbonding period
+type Unstake struct {
+	Validator []byte
+	Amount    uint64
+}
+
+type Delegate struct {
+	Validator []byte
+	Amount    uint64
+}
+
+// two encoded block headers at the same height by the same validator
+type DoubleSignEvidence struct {
+	HeaderA []byte
+	HeaderB []byte
+}
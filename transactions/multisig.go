@@ -0,0 +1,72 @@
+package transactions
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	MAX_MULTISIG_KEYS = 16
+)
+
+// M of N public keys which control one account,
+// account's public key is the address of the spec
+type MultisigSpec struct {
+	Threshold  byte
+	PublicKeys [][]byte
+}
+
+func (ms *MultisigSpec) Check() error {
+	n := len(ms.PublicKeys)
+	if n == 0 || n > MAX_MULTISIG_KEYS {
+		return errors.New("invalid number of multisig keys")
+	}
+	if ms.Threshold == 0 || int(ms.Threshold) > n {
+		return errors.New("invalid multisig threshold")
+	}
+	for i, k := range ms.PublicKeys {
+		if len(k) != ed25519.PublicKeySize {
+			return errors.New("invalid multisig key")
+		}
+		for _, other := range ms.PublicKeys[:i] {
+			if bytes.Equal(k, other) {
+				return errors.New("duplicated multisig key")
+			}
+		}
+	}
+	return nil
+}
+
+// hash of threshold and keys in order,
+// the same size as ed25519 public key
+func (ms *MultisigSpec) Address() []byte {
+	buff := new(bytes.Buffer)
+	buff.WriteByte(ms.Threshold)
+	for _, k := range ms.PublicKeys {
+		buff.Write(k)
+	}
+	hash := sha3.Sum256(buff.Bytes())
+	return hash[:]
+}
+
+// signatures are aligned with keys, empty for keys which did not sign,
+// digest is SigningDigest of transaction
+func (ms *MultisigSpec) Verify(digest []byte, signatures [][]byte) bool {
+	if len(signatures) != len(ms.PublicKeys) {
+		return false
+	}
+	var count int
+	for i, sig := range signatures {
+		if len(sig) == 0 {
+			continue
+		}
+		if !ed25519.Verify(ms.PublicKeys[i], digest, sig) {
+			return false
+		}
+		count++
+	}
+	return count >= int(ms.Threshold)
+}
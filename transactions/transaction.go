@@ -4,18 +4,26 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"errors"
-	"log"
 	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
 
 	"golang.org/x/crypto/sha3"
 )
 
+var stateLog = logger.New(logger.STATE)
+
 type TransactionData struct {
 	Data      []byte
 	PublicKey ed25519.PublicKey
 	Nonce     uint64
 	Signature []byte
 	Timestamp int64
+	// paid to block producer, burned when block has no coinbase
+	Fee uint64
+	// set when PublicKey is address of multisig account,
+	// Signature is not used then
+	Multisig   *MultisigSpec
+	Signatures [][]byte
 }
 
 type Transaction struct {
@@ -33,9 +41,37 @@ func (tx *Transaction) ContentsCheck() error {
 	if len(tx.InnerData.PublicKey) == 0 {
 		return errors.New("public key is empty")
 	}
+	// ed25519 panics on keys of other sizes
+	if len(tx.InnerData.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("public key is invalid")
+	}
+	if tx.InnerData.Multisig != nil {
+		err := tx.InnerData.Multisig.Check()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (tx *Transaction) IsMultisig() bool {
+	return tx.InnerData.Multisig != nil
+}
+
+// digest of everything but signatures, so that nonce and fee
+// can not be changed after signing
+func (tx *Transaction) SigningDigest() ([]byte, error) {
+	data := tx.InnerData
+	data.Signature = nil
+	data.Signatures = nil
+	enc, err := common.Encode(&data)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha3.Sum256(enc)
+	return hash[:], nil
+}
+
 func (tx *Transaction) Verify() (bool, error) {
 	err := tx.ContentsCheck()
 	if err != nil {
@@ -48,13 +84,26 @@ func (tx *Transaction) Verify() (bool, error) {
 	}
 	hash := sha3.Sum256(enc)
 	if !bytes.Equal(hash[:], tx.Hash[:]) {
-		log.Println("transaction hash is broken")
+		stateLog.Debug("transaction hash is broken", logger.Hash(tx.Hash[:]))
 		return false, nil
 	}
 
+	digest, err := tx.SigningDigest()
+	if err != nil {
+		return false, err
+	}
+	if tx.IsMultisig() {
+		spec := tx.InnerData.Multisig
+		if !bytes.Equal(spec.Address(), tx.InnerData.PublicKey) {
+			stateLog.Debug("multisig spec does not match address", logger.Hash(tx.Hash[:]))
+			return false, nil
+		}
+		return spec.Verify(digest, tx.InnerData.Signatures), nil
+	}
+
 	return ed25519.Verify(
 		tx.InnerData.PublicKey,
-		tx.InnerData.Data,
+		digest,
 		tx.InnerData.Signature,
 	), nil
 }
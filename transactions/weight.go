@@ -0,0 +1,91 @@
+package transactions
+
+import (
+	"errors"
+	"simple-blockchain-go/common"
+)
+
+const (
+	// every byte of command costs this much
+	WEIGHT_PER_BYTE uint64 = 1
+
+	WEIGHT_TRANSFER  uint64 = 100
+	WEIGHT_STATE_OBJ uint64 = 300
+	WEIGHT_STAKING   uint64 = 500
+	WEIGHT_EVIDENCE  uint64 = 2_000
+	WEIGHT_DEPLOY    uint64 = 5_000
+)
+
+// fixed cost of command kind,
+// contract call adds gas limit on top of this
+func (ck CommandKind) BaseWeight() uint64 {
+	switch ck {
+	case AIRDROP_CMD, TRANSFER_CMD, TOKEN_TRANSFER_CMD, TOKEN_BURN_CMD:
+		return WEIGHT_TRANSFER
+	case BATCH_TRANSFER_CMD:
+		return WEIGHT_TRANSFER * 2
+	case CREATE_MULTISIG_CMD,
+		LOCKED_TRANSFER_CMD,
+		ESCROW_CLAIM_CMD,
+		ESCROW_REFUND_CMD,
+		ESCROW_RELEASE_CMD,
+		TOKEN_CREATE_CMD,
+		TOKEN_MINT_CMD,
+		VOTE_SIGNER_CMD,
+		CONTRACT_CALL_CMD:
+		return WEIGHT_STATE_OBJ
+	case STAKE_CMD, UNSTAKE_CMD, DELEGATE_CMD:
+		return WEIGHT_STAKING
+	case DOUBLE_SIGN_EVIDENCE_CMD:
+		return WEIGHT_EVIDENCE
+	case CONTRACT_DEPLOY_CMD:
+		return WEIGHT_DEPLOY
+	default:
+		return WEIGHT_TRANSFER
+	}
+}
+
+func (tx *Transaction) Weight() (uint64, error) {
+	data := tx.InnerData.Data
+	if len(data) == 0 {
+		return 0, errors.New("data is empty")
+	}
+
+	cmdKind := CommandKind(data[0])
+	weight := cmdKind.BaseWeight() + uint64(len(data))*WEIGHT_PER_BYTE
+	if cmdKind == CONTRACT_CALL_CMD {
+		cmd, err := common.Decode[ContractCall](data[1:])
+		if err != nil {
+			return 0, err
+		}
+		if cmd.GasLimit > ^uint64(0)-weight {
+			return 0, errors.New("weight overflows")
+		}
+		weight += cmd.GasLimit
+	}
+	return weight, nil
+}
+
+// encoded size as it is in block
+func (tx *Transaction) Size() (int, error) {
+	enc, err := common.Encode(tx)
+	if err != nil {
+		return 0, err
+	}
+	return len(enc), nil
+}
+
+// whether fee covers weight at minimum price
+func (tx *Transaction) PaysMinFee(minFeePerWeight uint64) (bool, error) {
+	weight, err := tx.Weight()
+	if err != nil {
+		return false, err
+	}
+	if minFeePerWeight == 0 {
+		return true, nil
+	}
+	if weight > ^uint64(0)/minFeePerWeight {
+		return false, nil
+	}
+	return tx.InnerData.Fee >= weight*minFeePerWeight, nil
+}
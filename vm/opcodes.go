@@ -0,0 +1,95 @@
+package vm
+
+type OpCode byte
+
+const (
+	OP_STOP OpCode = iota
+	// followed by 1 byte length and data
+	OP_PUSH
+	OP_POP
+	OP_DUP
+	OP_SWAP
+
+	// values are read as big endian uint64
+	OP_ADD
+	OP_SUB
+	OP_MUL
+	OP_DIV
+	OP_MOD
+	// compares raw bytes
+	OP_EQ
+	OP_LT
+	OP_GT
+	OP_NOT
+	OP_CONCAT
+
+	// pops destination
+	OP_JUMP
+	// pops destination then condition
+	OP_JUMPI
+
+	// pops key
+	OP_SLOAD
+	// pops key then value
+	OP_SSTORE
+
+	OP_CALLER
+	OP_SELF
+	// pops index of call argument
+	OP_ARG
+	OP_HEIGHT
+	OP_TIMESTAMP
+	// pops public key
+	OP_BALANCE
+	// pops recipient then amount, pays from contract's balance
+	OP_TRANSFER
+
+	// pops return value
+	OP_RETURN
+	OP_REVERT
+	// pops data, recorded in receipt when call succeeds
+	OP_EMIT
+)
+
+const (
+	MAX_STACK_DEPTH = 1024
+	// max length of pushed and concatenated value
+	MAX_VALUE_SIZE = 255
+
+	GAS_BASE     uint64 = 1
+	GAS_JUMP     uint64 = 2
+	GAS_MATH     uint64 = 3
+	GAS_CONTEXT  uint64 = 2
+	GAS_BALANCE  uint64 = 20
+	GAS_SLOAD    uint64 = 50
+	GAS_SSTORE   uint64 = 200
+	GAS_TRANSFER uint64 = 500
+	GAS_EMIT     uint64 = 30
+)
+
+func (op OpCode) Gas() uint64 {
+	switch op {
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_CONCAT:
+		return GAS_MATH
+	case OP_JUMP, OP_JUMPI:
+		return GAS_JUMP
+	case OP_CALLER, OP_SELF, OP_ARG, OP_HEIGHT, OP_TIMESTAMP:
+		return GAS_CONTEXT
+	case OP_BALANCE:
+		return GAS_BALANCE
+	case OP_SLOAD:
+		return GAS_SLOAD
+	case OP_SSTORE:
+		return GAS_SSTORE
+	case OP_TRANSFER:
+		return GAS_TRANSFER
+	case OP_EMIT:
+		return GAS_EMIT
+	default:
+		return GAS_BASE
+	}
+}
+
+func (op OpCode) IsValid() bool {
+	return op <= OP_EMIT
+}
@@ -0,0 +1,152 @@
+package vm
+
+import (
+	"bytes"
+	"simple-blockchain-go/accounts"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	STORAGE_PREFIX = "contract-storage:"
+)
+
+type StateReader interface {
+	GetStateValue(key []byte) ([]byte, error)
+	GetAccountState(pubKey []byte) (*accounts.AccountState, error)
+}
+
+type StateWriter interface {
+	PutStateValue(key []byte, value []byte) error
+	PutAccountStates(pubKeys [][]byte, states []*accounts.AccountState) error
+}
+
+// contract address has fixed length,
+// so keys of different contracts never collide
+func StorageKey(contract []byte, key []byte) []byte {
+	k := append([]byte(STORAGE_PREFIX), contract...)
+	return append(k, key...)
+}
+
+// buffers writes of a call in memory,
+// nothing reaches database until Commit
+type Overlay struct {
+	reader   StateReader
+	storage  map[string][]byte
+	accounts map[string]*accounts.AccountState
+	// accounts which are modified, others are only read
+	dirty map[string]bool
+	// emitted data in order
+	events [][]byte
+}
+
+func NewOverlay(reader StateReader) *Overlay {
+	return &Overlay{
+		reader:   reader,
+		storage:  map[string][]byte{},
+		accounts: map[string]*accounts.AccountState{},
+		dirty:    map[string]bool{},
+	}
+}
+
+func (o *Overlay) GetStorage(contract []byte, key []byte) ([]byte, error) {
+	k := StorageKey(contract, key)
+	v, ok := o.storage[string(k)]
+	if ok {
+		return v, nil
+	}
+	return o.reader.GetStateValue(k)
+}
+
+func (o *Overlay) PutStorage(contract []byte, key []byte, value []byte) error {
+	k := StorageKey(contract, key)
+	o.storage[string(k)] = append([]byte{}, value...)
+	return nil
+}
+
+// returns a copy which can be modified,
+// missing account is empty one
+func (o *Overlay) account(pubKey []byte) (*accounts.AccountState, error) {
+	state, ok := o.accounts[string(pubKey)]
+	if ok {
+		return state, nil
+	}
+	state, err := o.reader.GetAccountState(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &accounts.AccountState{}
+	}
+	o.accounts[string(pubKey)] = state
+	return state, nil
+}
+
+func (o *Overlay) GetBalance(pubKey []byte) (uint64, error) {
+	state, err := o.account(pubKey)
+	if err != nil {
+		return 0, err
+	}
+	return state.Balance, nil
+}
+
+func (o *Overlay) Transfer(from []byte, to []byte, amount uint64) (bool, error) {
+	if !accounts.IsAccountKey(to) || bytes.Equal(from, to) {
+		return false, nil
+	}
+	fromState, err := o.account(from)
+	if err != nil {
+		return false, err
+	}
+	toState, err := o.account(to)
+	if err != nil {
+		return false, err
+	}
+
+	// check both before modifying
+	if amount > fromState.Balance {
+		return false, nil
+	}
+	if !toState.Add(amount) {
+		return false, nil
+	}
+	fromState.Subtract(amount)
+	o.dirty[string(from)] = true
+	o.dirty[string(to)] = true
+	return true, nil
+}
+
+func (o *Overlay) Emit(contract []byte, data []byte) error {
+	o.events = append(o.events, append([]byte{}, data...))
+	return nil
+}
+
+func (o *Overlay) Events() [][]byte {
+	return o.events
+}
+
+// writes in sorted order of keys
+func (o *Overlay) Commit(writer StateWriter) error {
+	keys := maps.Keys(o.storage)
+	slices.Sort(keys)
+	for _, k := range keys {
+		err := writer.PutStateValue([]byte(k), o.storage[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(o.dirty) == 0 {
+		return nil
+	}
+	pubKeys := maps.Keys(o.dirty)
+	slices.Sort(pubKeys)
+	rawKeys := make([][]byte, 0, len(pubKeys))
+	states := make([]*accounts.AccountState, 0, len(pubKeys))
+	for _, k := range pubKeys {
+		rawKeys = append(rawKeys, []byte(k))
+		states = append(states, o.accounts[k])
+	}
+	return writer.PutAccountStates(rawKeys, states)
+}
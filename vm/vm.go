@@ -0,0 +1,400 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"simple-blockchain-go/accounts"
+)
+
+var (
+	ErrOutOfGas        = errors.New("out of gas")
+	ErrStackUnderflow  = errors.New("stack underflow")
+	ErrStackOverflow   = errors.New("stack overflow")
+	ErrInvalidOpcode   = errors.New("invalid opcode")
+	ErrInvalidJump     = errors.New("invalid jump destination")
+	ErrValueTooLarge   = errors.New("value is too large")
+	ErrIntegerOverflow = errors.New("integer overflow")
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrInvalidArg      = errors.New("invalid argument index")
+	ErrTransferFailed  = errors.New("transfer failed")
+	ErrInvalidAccount  = errors.New("recipient is not an account")
+	ErrReverted        = errors.New("execution reverted")
+)
+
+// block and call context readable from contract
+type Context struct {
+	Caller    []byte
+	Contract  []byte
+	Height    uint64
+	Timestamp int64
+	Args      [][]byte
+}
+
+// state which contract can touch,
+// errors are internal failures, not failures of contract
+type Host interface {
+	GetStorage(contract []byte, key []byte) ([]byte, error)
+	PutStorage(contract []byte, key []byte, value []byte) error
+	GetBalance(pubKey []byte) (uint64, error)
+	// false when balance is not enough or overflows
+	Transfer(from []byte, to []byte, amount uint64) (bool, error)
+	Emit(contract []byte, data []byte) error
+}
+
+// Err is failure of contract such as out of gas,
+// state written through host must be discarded then
+type Result struct {
+	Return  []byte
+	GasUsed uint64
+	Err     error
+}
+
+type machine struct {
+	code      []byte
+	pc        uint64
+	stack     [][]byte
+	gasUsed   uint64
+	gasLimit  uint64
+	jumpdests map[uint64]bool
+	ctx       *Context
+	host      Host
+}
+
+// checks opcodes and push lengths,
+// returns positions where jumps can land
+func ValidateCode(code []byte) (map[uint64]bool, error) {
+	dests := map[uint64]bool{}
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		op := OpCode(code[pc])
+		if !op.IsValid() {
+			return nil, ErrInvalidOpcode
+		}
+		dests[pc] = true
+		if op == OP_PUSH {
+			if pc+1 >= uint64(len(code)) {
+				return nil, ErrInvalidOpcode
+			}
+			pc += 1 + uint64(code[pc+1])
+			if pc >= uint64(len(code)) {
+				return nil, ErrInvalidOpcode
+			}
+		}
+	}
+	return dests, nil
+}
+
+// runs code until stop, return, revert or failure,
+// same code, context and state always give the same result
+func Execute(
+	code []byte, ctx *Context, host Host, gasLimit uint64,
+) (*Result, error) {
+	dests, err := ValidateCode(code)
+	if err != nil {
+		return &Result{Err: err}, nil
+	}
+	m := machine{
+		code:      code,
+		stack:     make([][]byte, 0, 16),
+		gasLimit:  gasLimit,
+		jumpdests: dests,
+		ctx:       ctx,
+		host:      host,
+	}
+	ret, failure, err := m.run()
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Return:  ret,
+		GasUsed: m.gasUsed,
+		Err:     failure,
+	}, nil
+}
+
+func toUint(b []byte) (uint64, error) {
+	if len(b) > 8 {
+		return 0, ErrIntegerOverflow
+	}
+	var buf [8]byte
+	copy(buf[8-len(b):], b)
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func fromUint(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func fromBool(b bool) []byte {
+	if b {
+		return fromUint(1)
+	}
+	return fromUint(0)
+}
+
+func (m *machine) push(v []byte) error {
+	if len(v) > MAX_VALUE_SIZE {
+		return ErrValueTooLarge
+	}
+	if len(m.stack) >= MAX_STACK_DEPTH {
+		return ErrStackOverflow
+	}
+	m.stack = append(m.stack, v)
+	return nil
+}
+
+func (m *machine) pop() ([]byte, error) {
+	l := len(m.stack)
+	if l == 0 {
+		return nil, ErrStackUnderflow
+	}
+	v := m.stack[l-1]
+	m.stack = m.stack[:l-1]
+	return v, nil
+}
+
+func (m *machine) popUint() (uint64, error) {
+	v, err := m.pop()
+	if err != nil {
+		return 0, err
+	}
+	return toUint(v)
+}
+
+func (m *machine) useGas(gas uint64) error {
+	if gas > m.gasLimit-m.gasUsed {
+		m.gasUsed = m.gasLimit
+		return ErrOutOfGas
+	}
+	m.gasUsed += gas
+	return nil
+}
+
+// failure is contract's fault, err is internal
+func (m *machine) run() (ret []byte, failure error, err error) {
+	for m.pc < uint64(len(m.code)) {
+		op := OpCode(m.code[m.pc])
+		failure = m.useGas(op.Gas())
+		if failure != nil {
+			return nil, failure, nil
+		}
+
+		var done bool
+		ret, done, failure, err = m.step(op)
+		if err != nil || failure != nil || done {
+			return ret, failure, err
+		}
+	}
+	return nil, nil, nil
+}
+
+func (m *machine) step(op OpCode) ([]byte, bool, error, error) {
+	next := m.pc + 1
+	var failure error
+	switch op {
+	case OP_STOP:
+		return nil, true, nil, nil
+	case OP_PUSH:
+		l := uint64(m.code[m.pc+1])
+		failure = m.push(m.code[m.pc+2 : m.pc+2+l])
+		next = m.pc + 2 + l
+	case OP_POP:
+		_, failure = m.pop()
+	case OP_DUP:
+		l := len(m.stack)
+		if l == 0 {
+			failure = ErrStackUnderflow
+		} else {
+			failure = m.push(m.stack[l-1])
+		}
+	case OP_SWAP:
+		l := len(m.stack)
+		if l < 2 {
+			failure = ErrStackUnderflow
+		} else {
+			m.stack[l-1], m.stack[l-2] = m.stack[l-2], m.stack[l-1]
+		}
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_LT, OP_GT:
+		failure = m.arithmetic(op)
+	case OP_EQ:
+		var a, b []byte
+		b, failure = m.pop()
+		if failure == nil {
+			a, failure = m.pop()
+		}
+		if failure == nil {
+			failure = m.push(fromBool(bytes.Equal(a, b)))
+		}
+	case OP_NOT:
+		var a uint64
+		a, failure = m.popUint()
+		if failure == nil {
+			failure = m.push(fromBool(a == 0))
+		}
+	case OP_CONCAT:
+		var a, b []byte
+		b, failure = m.pop()
+		if failure == nil {
+			a, failure = m.pop()
+		}
+		if failure == nil {
+			failure = m.push(append(append([]byte{}, a...), b...))
+		}
+	case OP_JUMP, OP_JUMPI:
+		next, failure = m.jump(op, next)
+	case OP_SLOAD:
+		var key []byte
+		key, failure = m.pop()
+		if failure == nil {
+			value, err := m.host.GetStorage(m.ctx.Contract, key)
+			if err != nil {
+				return nil, false, nil, err
+			}
+			failure = m.push(value)
+		}
+	case OP_SSTORE:
+		var key, value []byte
+		key, failure = m.pop()
+		if failure == nil {
+			value, failure = m.pop()
+		}
+		if failure == nil {
+			err := m.host.PutStorage(m.ctx.Contract, key, value)
+			if err != nil {
+				return nil, false, nil, err
+			}
+		}
+	case OP_CALLER:
+		failure = m.push(m.ctx.Caller)
+	case OP_SELF:
+		failure = m.push(m.ctx.Contract)
+	case OP_ARG:
+		var idx uint64
+		idx, failure = m.popUint()
+		if failure == nil && idx >= uint64(len(m.ctx.Args)) {
+			failure = ErrInvalidArg
+		}
+		if failure == nil {
+			failure = m.push(m.ctx.Args[idx])
+		}
+	case OP_HEIGHT:
+		failure = m.push(fromUint(m.ctx.Height))
+	case OP_TIMESTAMP:
+		failure = m.push(fromUint(uint64(m.ctx.Timestamp)))
+	case OP_BALANCE:
+		var pubKey []byte
+		pubKey, failure = m.pop()
+		if failure == nil {
+			balance, err := m.host.GetBalance(pubKey)
+			if err != nil {
+				return nil, false, nil, err
+			}
+			failure = m.push(fromUint(balance))
+		}
+	case OP_TRANSFER:
+		var to []byte
+		var amount uint64
+		to, failure = m.pop()
+		if failure == nil {
+			amount, failure = m.popUint()
+		}
+		// overlay would write any bytes as account
+		if failure == nil && !accounts.IsAccountKey(to) {
+			failure = ErrInvalidAccount
+		}
+		if failure == nil {
+			ok, err := m.host.Transfer(m.ctx.Contract, to, amount)
+			if err != nil {
+				return nil, false, nil, err
+			}
+			if !ok {
+				failure = ErrTransferFailed
+			}
+		}
+	case OP_RETURN:
+		var v []byte
+		v, failure = m.pop()
+		return v, true, failure, nil
+	case OP_REVERT:
+		return nil, true, ErrReverted, nil
+	case OP_EMIT:
+		var data []byte
+		data, failure = m.pop()
+		if failure == nil {
+			err := m.host.Emit(m.ctx.Contract, data)
+			if err != nil {
+				return nil, false, nil, err
+			}
+		}
+	default:
+		failure = ErrInvalidOpcode
+	}
+	m.pc = next
+	return nil, false, failure, nil
+}
+
+func (m *machine) arithmetic(op OpCode) error {
+	b, err := m.popUint()
+	if err != nil {
+		return err
+	}
+	a, err := m.popUint()
+	if err != nil {
+		return err
+	}
+
+	var r uint64
+	switch op {
+	case OP_ADD:
+		if b > ^uint64(0)-a {
+			return ErrIntegerOverflow
+		}
+		r = a + b
+	case OP_SUB:
+		if b > a {
+			return ErrIntegerOverflow
+		}
+		r = a - b
+	case OP_MUL:
+		if a != 0 && b > ^uint64(0)/a {
+			return ErrIntegerOverflow
+		}
+		r = a * b
+	case OP_DIV:
+		if b == 0 {
+			return ErrDivisionByZero
+		}
+		r = a / b
+	case OP_MOD:
+		if b == 0 {
+			return ErrDivisionByZero
+		}
+		r = a % b
+	case OP_LT:
+		return m.push(fromBool(a < b))
+	case OP_GT:
+		return m.push(fromBool(a > b))
+	}
+	return m.push(fromUint(r))
+}
+
+func (m *machine) jump(op OpCode, next uint64) (uint64, error) {
+	dest, err := m.popUint()
+	if err != nil {
+		return 0, err
+	}
+	if op == OP_JUMPI {
+		cond, err := m.pop()
+		if err != nil {
+			return 0, err
+		}
+		// any non zero byte is true
+		if bytes.Count(cond, []byte{0}) == len(cond) {
+			return next, nil
+		}
+	}
+	if !m.jumpdests[dest] {
+		return 0, ErrInvalidJump
+	}
+	return dest, nil
+}
@@ -0,0 +1,190 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"simple-blockchain-go/accounts"
+	"testing"
+)
+
+// database of overlay, also takes its commit
+type memState struct {
+	values   map[string][]byte
+	accounts map[string]*accounts.AccountState
+}
+
+func newMemState() *memState {
+	return &memState{
+		values:   map[string][]byte{},
+		accounts: map[string]*accounts.AccountState{},
+	}
+}
+
+func (s *memState) GetStateValue(key []byte) ([]byte, error) {
+	return s.values[string(key)], nil
+}
+
+func (s *memState) GetAccountState(pubKey []byte) (*accounts.AccountState, error) {
+	state, ok := s.accounts[string(pubKey)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *state
+	return &copied, nil
+}
+
+func (s *memState) PutStateValue(key []byte, value []byte) error {
+	s.values[string(key)] = value
+	return nil
+}
+
+func (s *memState) PutAccountStates(
+	pubKeys [][]byte, states []*accounts.AccountState,
+) error {
+	for i, k := range pubKeys {
+		copied := *states[i]
+		s.accounts[string(k)] = &copied
+	}
+	return nil
+}
+
+func push(v ...byte) []byte {
+	return append([]byte{byte(OP_PUSH), byte(len(v))}, v...)
+}
+
+func program(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func op(ops ...OpCode) []byte {
+	code := make([]byte, len(ops))
+	for i, o := range ops {
+		code[i] = byte(o)
+	}
+	return code
+}
+
+var (
+	contract  = bytes.Repeat([]byte{1}, 32)
+	recipient = bytes.Repeat([]byte{2}, 32)
+)
+
+func run(t *testing.T, code []byte, host Host, gasLimit uint64) *Result {
+	ctx := &Context{Caller: recipient, Contract: contract, Height: 7}
+	result, err := Execute(code, ctx, host, gasLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExecute(t *testing.T) {
+	cases := []struct {
+		name string
+		code []byte
+		ret  []byte
+		err  error
+	}{
+		{"add", program(push(2), push(3), op(OP_ADD, OP_RETURN)), fromUint(5), nil},
+		{"sub order", program(push(5), push(3), op(OP_SUB, OP_RETURN)), fromUint(2), nil},
+		{"sub underflow", program(push(3), push(5), op(OP_SUB)), nil, ErrIntegerOverflow},
+		{"div by zero", program(push(3), push(0), op(OP_DIV)), nil, ErrDivisionByZero},
+		{"too long for integer", program(push(1, 2, 3, 4, 5, 6, 7, 8, 9), push(1), op(OP_ADD)), nil, ErrIntegerOverflow},
+		{"stack underflow", op(OP_POP), nil, ErrStackUnderflow},
+		{"height", op(OP_HEIGHT, OP_RETURN), fromUint(7), nil},
+		{"missing arg", program(push(0), op(OP_ARG)), nil, ErrInvalidArg},
+		{"revert", op(OP_REVERT), nil, ErrReverted},
+		{"stop", op(OP_STOP, OP_POP), nil, nil},
+		// skips the revert at 7
+		{"jumpi", program(push(1), push(8), op(OP_JUMPI, OP_REVERT, OP_HEIGHT, OP_RETURN)), fromUint(7), nil},
+		{"jump into push data", program(push(6), op(OP_JUMP), push(byte(OP_REVERT))), nil, ErrInvalidJump},
+		{"push out of code", []byte{byte(OP_PUSH), 4, 1}, nil, ErrInvalidOpcode},
+		{"unknown opcode", []byte{byte(OP_EMIT) + 1}, nil, ErrInvalidOpcode},
+	}
+	for _, c := range cases {
+		result := run(t, c.code, NewOverlay(newMemState()), 10_000)
+		if !errors.Is(result.Err, c.err) {
+			t.Errorf("%s: got error %v, want %v", c.name, result.Err, c.err)
+			continue
+		}
+		if !bytes.Equal(result.Return, c.ret) {
+			t.Errorf("%s: got %x, want %x", c.name, result.Return, c.ret)
+		}
+	}
+}
+
+func TestOutOfGas(t *testing.T) {
+	// endless loop
+	code := program(push(0), op(OP_JUMP))
+	result := run(t, code, NewOverlay(newMemState()), 1_000)
+	if !errors.Is(result.Err, ErrOutOfGas) {
+		t.Fatalf("got %v", result.Err)
+	}
+	if result.GasUsed != 1_000 {
+		t.Errorf("gas used %d", result.GasUsed)
+	}
+}
+
+func TestStorageIsBufferedUntilCommit(t *testing.T) {
+	state := newMemState()
+	overlay := NewOverlay(state)
+	// value then key
+	code := program(push(9), push(1), op(OP_SSTORE), push(1), op(OP_SLOAD, OP_RETURN))
+	result := run(t, code, overlay, 10_000)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if !bytes.Equal(result.Return, []byte{9}) {
+		t.Fatalf("loaded %x", result.Return)
+	}
+	if len(state.values) != 0 {
+		t.Fatal("storage is written before commit")
+	}
+
+	err := overlay.Commit(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := state.values[string(StorageKey(contract, []byte{1}))]
+	if !bytes.Equal(v, []byte{9}) {
+		t.Errorf("committed %x", v)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	transfer := func(to []byte, amount byte) []byte {
+		return program(push(amount), push(to...), op(OP_TRANSFER))
+	}
+	cases := []struct {
+		name string
+		code []byte
+		err  error
+		paid uint64
+	}{
+		{"to account", transfer(recipient, 40), nil, 40},
+		{"more than balance", transfer(recipient, 200), ErrTransferFailed, 0},
+		{"to short key", transfer(recipient[:20], 40), ErrInvalidAccount, 0},
+		{"to itself", transfer(contract, 40), ErrTransferFailed, 0},
+	}
+	for _, c := range cases {
+		state := newMemState()
+		state.accounts[string(contract)] = &accounts.AccountState{Balance: 100}
+		overlay := NewOverlay(state)
+		result := run(t, c.code, overlay, 10_000)
+		if !errors.Is(result.Err, c.err) {
+			t.Errorf("%s: got error %v, want %v", c.name, result.Err, c.err)
+			continue
+		}
+		err := overlay.Commit(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paid uint64
+		if s, ok := state.accounts[string(recipient)]; ok {
+			paid = s.Balance
+		}
+		if paid != c.paid || state.accounts[string(contract)].Balance != 100-c.paid {
+			t.Errorf("%s: paid %d, want %d", c.name, paid, c.paid)
+		}
+	}
+}
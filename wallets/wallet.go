@@ -1,7 +1,9 @@
 package wallets
 
 import (
+	"bytes"
 	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"os"
 	"simple-blockchain-go/common"
@@ -9,6 +11,7 @@ import (
 	"simple-blockchain-go/transactions"
 
 	"golang.org/x/crypto/sha3"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -18,6 +21,8 @@ const (
 type AccountInfo struct {
 	Nonce   uint64
 	Balance uint64
+	// balances by base58 token id
+	Tokens map[string]uint64
 }
 
 type Wallet struct {
@@ -56,8 +61,12 @@ func NewWallet(id string, name string) (*Wallet, error) {
 
 func fromKeyPair(kp *keys.KeyPair) *Wallet {
 	return &Wallet{
-		keyPair:     kp,
-		AccountInfo: AccountInfo{0, 0},
+		keyPair: kp,
+		AccountInfo: AccountInfo{
+			Nonce:   0,
+			Balance: 0,
+			Tokens:  map[string]uint64{},
+		},
 	}
 }
 
@@ -71,8 +80,11 @@ func (w *Wallet) Sign(tx *transactions.Transaction) error {
 		return err
 	}
 
-	sig := ed25519.Sign(w.keyPair.PrivateKey, tx.InnerData.Data)
-	tx.InnerData.Signature = sig
+	digest, err := tx.SigningDigest()
+	if err != nil {
+		return err
+	}
+	tx.InnerData.Signature = ed25519.Sign(w.keyPair.PrivateKey, digest)
 
 	enc, err := common.Encode(&tx.InnerData)
 	if err != nil {
@@ -83,6 +95,42 @@ func (w *Wallet) Sign(tx *transactions.Transaction) error {
 	return nil
 }
 
+// adds this wallet's signature to multisig transaction,
+// hash is updated so the last signer's hash is final
+func (w *Wallet) CoSign(tx *transactions.Transaction) error {
+	err := tx.ContentsCheck()
+	if err != nil {
+		return err
+	}
+	spec := tx.InnerData.Multisig
+	if spec == nil {
+		return errors.New("transaction is not multisig")
+	}
+	idx := slices.IndexFunc(spec.PublicKeys, func(k []byte) bool {
+		return bytes.Equal(k, w.PublicKey())
+	})
+	if idx < 0 {
+		return errors.New("wallet is not a member of multisig")
+	}
+
+	if len(tx.InnerData.Signatures) != len(spec.PublicKeys) {
+		tx.InnerData.Signatures = make([][]byte, len(spec.PublicKeys))
+	}
+	// signatures are excluded, so every co-signer signs the same digest
+	digest, err := tx.SigningDigest()
+	if err != nil {
+		return err
+	}
+	tx.InnerData.Signatures[idx] = ed25519.Sign(w.keyPair.PrivateKey, digest)
+
+	enc, err := common.Encode(&tx.InnerData)
+	if err != nil {
+		return err
+	}
+	tx.Hash = sha3.Sum256(enc)
+	return nil
+}
+
 func (w *Wallet) QuickSign(content []byte) []byte {
 	return ed25519.Sign(w.keyPair.PrivateKey, content)
 }
@@ -0,0 +1,229 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rfc 6455
+	ACCEPT_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	OP_CONTINUATION byte = 0x0
+	OP_TEXT         byte = 0x1
+	OP_BINARY       byte = 0x2
+	OP_CLOSE        byte = 0x8
+	OP_PING         byte = 0x9
+	OP_PONG         byte = 0xa
+
+	FIN_BIT  byte = 0x80
+	MASK_BIT byte = 0x80
+
+	MAX_MESSAGE_SIZE = 1 << 16
+	// seconds
+	WRITE_WAIT = 10
+)
+
+var (
+	ErrNotWebSocket   = errors.New("request is not websocket upgrade")
+	ErrMessageTooBig  = errors.New("message is too big")
+	ErrUnmaskedFrame  = errors.New("client frame is not masked")
+	ErrInvalidControl = errors.New("invalid control frame")
+	ErrClosed         = errors.New("connection is closed")
+)
+
+// server side of websocket connection,
+// one reader and any number of writers
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+	closeOnce sync.Once
+}
+
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + ACCEPT_GUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(r *http.Request, name string, value string) bool {
+	for _, v := range strings.Split(r.Header.Get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// completes handshake and takes over connection from http server
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r, "Connection", "upgrade") ||
+		!headerContains(r, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		http.Error(w, ErrNotWebSocket.Error(), http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking is not supported", http.StatusInternalServerError)
+		return nil, ErrNotWebSocket
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// deadlines of http server remain after hijack
+	conn.SetDeadline(time.Time{})
+
+	res := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	_, err = conn.Write([]byte(res))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: rw.Reader}, nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// returns next text or binary message,
+// pings are answered and close ends with ErrClosed
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OP_PING:
+			err = c.WriteMessage(OP_PONG, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OP_PONG:
+			continue
+		case OP_CLOSE:
+			c.WriteMessage(OP_CLOSE, payload)
+			return 0, nil, ErrClosed
+		case OP_CONTINUATION:
+			if opcode == 0 {
+				return 0, nil, ErrInvalidControl
+			}
+		default:
+			if opcode != 0 {
+				return 0, nil, ErrInvalidControl
+			}
+			opcode = op
+		}
+
+		if len(message)+len(payload) > MAX_MESSAGE_SIZE {
+			return 0, nil, ErrMessageTooBig
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	_, err := io.ReadFull(c.reader, head[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&FIN_BIT != 0
+	op := head[0] & 0x0f
+	if head[1]&MASK_BIT == 0 {
+		return false, 0, nil, ErrUnmaskedFrame
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	// control frames are small and never fragmented
+	if op >= OP_CLOSE && (length > 125 || !fin) {
+		return false, 0, nil, ErrInvalidControl
+	}
+	if length > MAX_MESSAGE_SIZE {
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.reader, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// sends one unfragmented frame
+func (c *Conn) WriteMessage(op byte, data []byte) error {
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, FIN_BIT|op)
+	switch l := len(data); {
+	case l <= 125:
+		frame = append(frame, byte(l))
+	case l <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(l))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(l))
+	}
+	frame = append(frame, data...)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second * WRITE_WAIT))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+	return err
+}
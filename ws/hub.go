@@ -0,0 +1,335 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"simple-blockchain-go/accounts"
+	"simple-blockchain-go/common"
+	"simple-blockchain-go/logger"
+	"simple-blockchain-go/rpc"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+var rpcLog = logger.New(logger.RPC)
+
+const (
+	TOPIC_NEW_HEADS    = "newHeads"
+	TOPIC_PENDING_TXS  = "pendingTransactions"
+	TOPIC_ACCOUNTS     = "accounts"
+	SUBSCRIBE_METHOD   = "subscribe"
+	UNSUBSCRIBE_METHOD = "unsubscribe"
+	NOTIFY_METHOD      = "subscription"
+
+	// queued messages per client,
+	// client which falls behind further is disconnected
+	SEND_BUFFER = 256
+	// seconds
+	PING_INTERVAL = 30
+	PONG_WAIT     = 60
+	// per client
+	MAX_SUBSCRIPTIONS = 32
+	MAX_ACCOUNT_KEYS  = 64
+)
+
+type SubscribeParams struct {
+	Topic string `json:"topic"`
+	// base58 public keys for accounts topic
+	PublicKeys []string `json:"publicKeys,omitempty"`
+}
+
+type UnsubscribeParams struct {
+	Subscription uint64 `json:"subscription"`
+}
+
+type Notification struct {
+	JsonRpc string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  NotificationParams `json:"params"`
+}
+
+type NotificationParams struct {
+	Subscription uint64      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+type subscription struct {
+	id     uint64
+	topic  string
+	client *client
+	// empty means every key
+	keys map[string]bool
+}
+
+type client struct {
+	conn *Conn
+	send chan []byte
+	done chan struct{}
+	once sync.Once
+	// by id
+	subs map[uint64]*subscription
+}
+
+func (c *client) close(reason string) {
+	c.once.Do(func() {
+		if reason != "" {
+			rpcLog.Info("closing subscriber", logger.F("reason", reason))
+		}
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// never blocks publisher
+func (c *client) enqueue(msg []byte) bool {
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return false
+	default:
+		c.close("subscriber is too slow")
+		return false
+	}
+}
+
+// fans out events to websocket subscribers
+type Hub struct {
+	lock    sync.Mutex
+	subs    map[uint64]*subscription
+	clients map[*client]bool
+	nextId  uint64
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs:    map[uint64]*subscription{},
+		clients: map[*client]bool{},
+	}
+}
+
+// disconnects every subscriber
+func (h *Hub) Close() {
+	h.lock.Lock()
+	clients := make([]*client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.lock.Unlock()
+	for _, c := range clients {
+		c.close("node is shutting down")
+	}
+}
+
+// sends result to subscriptions of topic which have key,
+// key is ignored by subscriptions without keys
+func (h *Hub) Publish(topic string, key string, result interface{}) {
+	h.lock.Lock()
+	targets := []*subscription{}
+	for _, s := range h.subs {
+		if s.topic != topic {
+			continue
+		}
+		if len(s.keys) > 0 && !s.keys[key] {
+			continue
+		}
+		targets = append(targets, s)
+	}
+	h.lock.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	for _, s := range targets {
+		enc, err := common.Encode(Notification{
+			JsonRpc: rpc.JSON_RPC_VERSION,
+			Method:  NOTIFY_METHOD,
+			Params: NotificationParams{
+				Subscription: s.id,
+				Result:       result,
+			},
+		})
+		if err != nil {
+			rpcLog.Error("encoding notification failed", logger.F("topic", topic), logger.Err(err))
+			return
+		}
+		s.client.enqueue(enc)
+	}
+}
+
+func (h *Hub) HasSubscribers(topic string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, s := range h.subs {
+		if s.topic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := Upgrade(w, r)
+	if err != nil {
+		rpcLog.Warn("websocket upgrade failed", logger.Peer(r.RemoteAddr), logger.Err(err))
+		return
+	}
+	c := &client{
+		conn: conn,
+		send: make(chan []byte, SEND_BUFFER),
+		done: make(chan struct{}),
+		subs: map[uint64]*subscription{},
+	}
+	h.lock.Lock()
+	h.clients[c] = true
+	h.lock.Unlock()
+	rpcLog.Info("websocket subscriber connected", logger.Peer(r.RemoteAddr))
+	go h.writeLoop(c)
+	h.readLoop(c)
+}
+
+func (h *Hub) writeLoop(c *client) {
+	ticker := time.NewTicker(time.Second * PING_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-c.send:
+			err := c.conn.WriteMessage(OP_TEXT, msg)
+			if err != nil {
+				c.close(err.Error())
+				return
+			}
+		case <-ticker.C:
+			err := c.conn.WriteMessage(OP_PING, nil)
+			if err != nil {
+				c.close(err.Error())
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (h *Hub) readLoop(c *client) {
+	defer func() {
+		h.lock.Lock()
+		for id := range c.subs {
+			delete(h.subs, id)
+		}
+		delete(h.clients, c)
+		h.lock.Unlock()
+		c.close("")
+	}()
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(time.Second * PONG_WAIT))
+		op, raw, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if op != OP_TEXT {
+			continue
+		}
+
+		req, err := common.Decode[rpc.Request](raw)
+		if err != nil {
+			h.reply(c, nil, nil, rpc.NewError(rpc.PARSE_ERROR, err.Error()))
+			continue
+		}
+		result, rpcErr := h.handle(c, req)
+		h.reply(c, req.Id, result, rpcErr)
+	}
+}
+
+func (h *Hub) handle(c *client, req *rpc.Request) (interface{}, *rpc.Error) {
+	switch req.Method {
+	case SUBSCRIBE_METHOD:
+		p, err := rpc.DecodeParams[SubscribeParams](req.Params)
+		if err != nil {
+			return nil, err.(*rpc.Error)
+		}
+		return h.subscribe(c, p)
+	case UNSUBSCRIBE_METHOD:
+		p, err := rpc.DecodeParams[UnsubscribeParams](req.Params)
+		if err != nil {
+			return nil, err.(*rpc.Error)
+		}
+		h.lock.Lock()
+		_, ok := c.subs[p.Subscription]
+		delete(c.subs, p.Subscription)
+		delete(h.subs, p.Subscription)
+		h.lock.Unlock()
+		return ok, nil
+	default:
+		return nil, rpc.NewError(rpc.METHOD_NOT_FOUND, "method not found")
+	}
+}
+
+func (h *Hub) subscribe(
+	c *client, p *SubscribeParams,
+) (interface{}, *rpc.Error) {
+	switch p.Topic {
+	case TOPIC_NEW_HEADS, TOPIC_PENDING_TXS:
+		if len(p.PublicKeys) > 0 {
+			return nil, rpc.NewError(rpc.INVALID_PARAMS, "topic takes no keys")
+		}
+	case TOPIC_ACCOUNTS:
+		if len(p.PublicKeys) == 0 || len(p.PublicKeys) > MAX_ACCOUNT_KEYS {
+			return nil, rpc.NewError(rpc.INVALID_PARAMS, "invalid number of keys")
+		}
+	default:
+		return nil, rpc.NewError(rpc.INVALID_PARAMS, "unknown topic")
+	}
+
+	keys := map[string]bool{}
+	for _, k := range p.PublicKeys {
+		pubKey := base58.Decode(k)
+		if !accounts.IsAccountKey(pubKey) {
+			return nil, rpc.NewError(rpc.INVALID_PARAMS, "invalid public key")
+		}
+		// same form as publisher uses
+		keys[base58.Encode(pubKey)] = true
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(c.subs) >= MAX_SUBSCRIPTIONS {
+		return nil, rpc.NewError(rpc.INVALID_REQUEST, "too many subscriptions")
+	}
+	h.nextId++
+	s := &subscription{
+		id:     h.nextId,
+		topic:  p.Topic,
+		client: c,
+		keys:   keys,
+	}
+	c.subs[s.id] = s
+	h.subs[s.id] = s
+	return s.id, nil
+}
+
+func (h *Hub) reply(
+	c *client, id json.RawMessage, result interface{}, rpcErr *rpc.Error,
+) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	res := rpc.Response{
+		JsonRpc: rpc.JSON_RPC_VERSION,
+		Result:  result,
+		Id:      id,
+	}
+	if rpcErr != nil {
+		res.Error = rpcErr
+		res.Result = nil
+	}
+	enc, err := common.Encode(res)
+	if err != nil {
+		rpcLog.Error("encoding response failed", logger.Err(err))
+		return
+	}
+	c.enqueue(enc)
+}